@@ -0,0 +1,118 @@
+package main
+
+import (
+	"container/list"
+	"slices"
+	"sync"
+	"time"
+)
+
+// albumCache is an LRU of the albums served by GET /albums/{albumID}. Each
+// replica keeps its own, so writes through another replica show up only once
+// the entry's TTL runs out. A nil cache stores nothing.
+type albumCache struct {
+	maxEntries int
+	ttl        time.Duration
+
+	mu      sync.Mutex
+	order   *list.List // most recently used at the front
+	entries map[int]*list.Element
+	// gen is bumped by every invalidation so a read that raced a write
+	// doesn't cache what it loaded before the write
+	gen uint64
+}
+
+type albumCacheEntry struct {
+	album   AlbumInfo
+	expires time.Time
+}
+
+// newAlbumCache returns nil when caching is disabled
+func newAlbumCache(enabled bool, maxEntries int, ttl time.Duration) *albumCache {
+	if !enabled || maxEntries <= 0 || ttl <= 0 {
+		return nil
+	}
+	return &albumCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		order:      list.New(),
+		entries:    make(map[int]*list.Element),
+	}
+}
+
+// get returns a copy of the cached album and counts the hit or miss. On a
+// miss it also returns the generation to hand back to put.
+func (c *albumCache) get(albumID int) (AlbumInfo, uint64, bool) {
+	if c == nil {
+		return AlbumInfo{}, 0, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[albumID]; ok {
+		entry := el.Value.(*albumCacheEntry)
+		if time.Now().Before(entry.expires) {
+			c.order.MoveToFront(el)
+			albumCacheHitsTotal.Inc()
+			return cloneAlbum(entry.album), c.gen, true
+		}
+		c.remove(el)
+	}
+	albumCacheMissesTotal.Inc()
+	return AlbumInfo{}, c.gen, false
+}
+
+// put caches album unless an invalidation happened since get returned gen
+func (c *albumCache) put(album AlbumInfo, gen uint64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+	entry := &albumCacheEntry{album: cloneAlbum(album), expires: time.Now().Add(c.ttl)}
+	if el, ok := c.entries[album.AlbumID]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[album.AlbumID] = c.order.PushFront(entry)
+	for c.order.Len() > c.maxEntries {
+		c.remove(c.order.Back())
+	}
+}
+
+// invalidate drops the given albums after a write
+func (c *albumCache) invalidate(albumIDs ...int) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	for _, id := range albumIDs {
+		if el, ok := c.entries[id]; ok {
+			c.remove(el)
+		}
+	}
+}
+
+func (c *albumCache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*albumCacheEntry).album.AlbumID)
+}
+
+// cloneAlbum copies the slices of album so presentAlbum can rewrite a copy's
+// URLs without touching the cached value
+func cloneAlbum(album AlbumInfo) AlbumInfo {
+	album.Images = slices.Clone(album.Images)
+	album.Metadata.Tags = slices.Clone(album.Metadata.Tags)
+	return album
+}
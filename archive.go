@@ -0,0 +1,175 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxArchiveAlbums caps how many albums one archive may bundle
+const maxArchiveAlbums = 100
+
+// archiveManifestName is the archive entry listing the albums left out
+const archiveManifestName = "skipped.json"
+
+// ArchiveRequest is the body of POST /albums/archive
+type ArchiveRequest struct {
+	IDs []int `json:"ids"`
+}
+
+// ArchiveSkip is an album whose image is not in the archive, and why
+type ArchiveSkip struct {
+	AlbumID int    `json:"albumID"`
+	Reason  string `json:"reason"`
+}
+
+// POST /albums/archive -> streams a zip of the images of the albums in
+// {"ids": [...]}, each saved as "Artist - Title" like ?download=true does.
+// Entries are written as they are read, so only one image is open at a
+// time. The status is sent before the first image is read, so albums that
+// are missing, deleted, have no image or whose file is gone are listed in
+// a skipped.json entry at the end instead of failing the request.
+func (s *Server) archiveAlbums(c *gin.Context) {
+	var req ArchiveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondJSONBodyError(c, err, `Body must be {"ids": [...]}`)
+		return
+	}
+	ids, ok := uniqueAlbumIDs(c, req.IDs, maxArchiveAlbums)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	found, err := s.queryAlbums(ctx, "SELECT "+albumColumns+" FROM albums WHERE id IN ("+placeholders(len(ids))+") AND deleted_at IS NULL", args...)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	if len(found) == 0 {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Album not found")
+		return
+	}
+	byID := make(map[int]AlbumInfo, len(found))
+	for _, album := range found {
+		byID[album.AlbumID] = album
+	}
+
+	// Like exports, an archive runs for as long as the client keeps reading
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		slog.WarnContext(ctx, "Archive keeps the server write timeout", "error", err)
+	}
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", `attachment; filename="albums.zip"`)
+	c.Status(200)
+
+	// The status line is already sent, so a failure can only cut the body short
+	if err := s.writeArchive(ctx, c.Writer, ids, byID); err != nil {
+		slog.ErrorContext(ctx, "Archive failed", "error", err)
+		_ = c.Error(err)
+	}
+}
+
+// writeArchive writes the zip of albums, in the order of ids, to w
+func (s *Server) writeArchive(ctx context.Context, w gin.ResponseWriter, ids []int, albums map[int]AlbumInfo) error {
+	zw := zip.NewWriter(w)
+	names := make(map[string]bool, len(ids))
+	skipped := []ArchiveSkip{}
+
+	for _, id := range ids {
+		album, ok := albums[id]
+		if !ok {
+			skipped = append(skipped, ArchiveSkip{AlbumID: id, Reason: "album not found"})
+			continue
+		}
+		if album.ImageURL == "" {
+			skipped = append(skipped, ArchiveSkip{AlbumID: id, Reason: "album has no image"})
+			continue
+		}
+
+		f, err := s.openStoredFile(ctx, album.ImageURL)
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			reason := "image not found"
+			if !errors.Is(err, errObjectNotFound) {
+				slog.WarnContext(ctx, "Failed to read image for archive", "album_id", id, "error", err)
+				reason = "image could not be read"
+			}
+			skipped = append(skipped, ArchiveSkip{AlbumID: id, Reason: reason})
+			continue
+		}
+
+		err = addArchiveEntry(zw, archiveEntryName(names, album), album.CreatedAt, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		w.Flush()
+	}
+
+	if len(skipped) > 0 {
+		manifest, err := json.MarshalIndent(skipped, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := addArchiveEntry(zw, archiveManifestName, time.Now(), bytes.NewReader(append(manifest, '\n'))); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// addArchiveEntry copies r into the archive under name. Images are already
+// compressed, so entries are stored rather than deflated again.
+func addArchiveEntry(zw *zip.Writer, name string, modified time.Time, r io.Reader) error {
+	entry, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: modified})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, r)
+	return err
+}
+
+// archiveEntryName names an album's image in an archive after its metadata,
+// numbering repeats so two albums with the same artist and title don't
+// collide, and records the name in used
+func archiveEntryName(used map[string]bool, album AlbumInfo) string {
+	base, ext := downloadFilename(album.AlbumID, album.Metadata), path.Ext(album.ImageURL)
+	name := base + ext
+	for n := 2; used[strings.ToLower(name)]; n++ {
+		name = fmt.Sprintf("%s (%d)%s", base, n, ext)
+	}
+	used[strings.ToLower(name)] = true
+	return name
+}
+
+// openStoredFile opens a stored image by URL. Local paths are read directly,
+// since albums created before a switch of STORAGE_BACKEND keep them.
+func (s *Server) openStoredFile(ctx context.Context, url string) (io.ReadCloser, error) {
+	if isRemoteURL(url) {
+		return s.storage.Open(ctx, url)
+	}
+	f, err := os.Open(url)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errObjectNotFound
+	}
+	return f, err
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestArchiveAlbums(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ts.storage.objects["https://cdn.example.com/1.jpg"] = []byte("first")
+	ts.storage.objects["https://cdn.example.com/2.jpg"] = []byte("second")
+
+	const metadata = `{"artist":"Air","title":"Moon Safari"}`
+	now := time.Now()
+	ts.mock.ExpectQuery(regexp.QuoteMeta("SELECT "+albumColumns+" FROM albums WHERE id IN (?, ?, ?, ?, ?) AND deleted_at IS NULL")).
+		WithArgs(3, 1, 2, 4, 5).
+		WillReturnRows(albumRows().
+			AddRow(1, nil, "https://cdn.example.com/1.jpg", nil, nil, nil, nil, nil, nil, metadata, nil, nil, nil, 1, now, now, nil).
+			AddRow(2, nil, "https://cdn.example.com/2.jpg", nil, nil, nil, nil, nil, nil, metadata, nil, nil, nil, 1, now, now, nil).
+			AddRow(4, nil, "", nil, nil, nil, nil, nil, nil, metadata, nil, nil, nil, 1, now, now, nil).
+			AddRow(5, nil, "https://cdn.example.com/gone.jpg", nil, nil, nil, nil, nil, nil, metadata, nil, nil, nil, 1, now, now, nil))
+
+	w := ts.do(http.MethodPost, "/albums/archive", `{"ids": [3, 1, 2, 4, 5]}`, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/zip" {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("read zip: %v", err)
+	}
+	entries := map[string]string{}
+	var names []string
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		names = append(names, f.Name)
+		entries[f.Name] = string(data)
+	}
+
+	wantNames := []string{"Air - Moon Safari.jpg", "Air - Moon Safari (2).jpg", archiveManifestName}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Fatalf("entries = %q, want %q", names, wantNames)
+	}
+	if entries["Air - Moon Safari.jpg"] != "first" || entries["Air - Moon Safari (2).jpg"] != "second" {
+		t.Errorf("entry contents = %q", entries)
+	}
+
+	var skipped []ArchiveSkip
+	if err := json.Unmarshal([]byte(entries[archiveManifestName]), &skipped); err != nil {
+		t.Fatalf("decode manifest: %v", err)
+	}
+	wantSkipped := []ArchiveSkip{
+		{AlbumID: 3, Reason: "album not found"},
+		{AlbumID: 4, Reason: "album has no image"},
+		{AlbumID: 5, Reason: "image not found"},
+	}
+	if !reflect.DeepEqual(skipped, wantSkipped) {
+		t.Errorf("skipped = %+v, want %+v", skipped, wantSkipped)
+	}
+}
+
+func TestArchiveAlbumsRejectsBadIDs(t *testing.T) {
+	ts := newTestServer(t, nil)
+	for _, body := range []string{`{"ids": []}`, `{"ids": [0]}`, `{"ids": "1"}`} {
+		if w := ts.do(http.MethodPost, "/albums/archive", body, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, w.Code)
+		}
+	}
+}
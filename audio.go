@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// formAudio reads the optional "audio" part of an upload form, already
+// bounded by formImage. It returns nil data when the form has no audio and
+// false after responding to an unacceptable file.
+func (s *Server) formAudio(c *gin.Context) ([]byte, bool) {
+	audioFile, err := c.FormFile("audio")
+	if err == http.ErrMissingFile {
+		return nil, true
+	}
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Invalid audio file")
+		return nil, false
+	}
+	if audioFile.Size > s.maxAudioBytes {
+		respondError(c, http.StatusRequestEntityTooLarge, ErrCodePayloadTooLarge, "Audio file too large")
+		return nil, false
+	}
+
+	file, err := audioFile.Open()
+	if err != nil {
+		respondInternalError(c, err)
+		return nil, false
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		respondInternalError(c, err)
+		return nil, false
+	}
+
+	// The part's Content-Type is the client's claim, so check the bytes
+	if !isMPEGAudio(data) {
+		respondError(c, http.StatusUnsupportedMediaType, ErrCodeUnsupportedMedia, "unsupported audio type: only audio/mpeg (MP3) is allowed")
+		return nil, false
+	}
+	return data, true
+}
+
+// isMPEGAudio reports whether data starts like an MP3: an ID3v2 tag or an
+// MPEG audio frame header
+func isMPEGAudio(data []byte) bool {
+	if bytes.HasPrefix(data, []byte("ID3")) {
+		return true
+	}
+	// 11 sync bits, then a version other than "reserved" and a layer
+	// other than "reserved"
+	return len(data) >= 4 && data[0] == 0xFF && data[1]&0xE0 == 0xE0 && data[1]&0x18 != 0x08 && data[1]&0x06 != 0
+}
+
+// storeAudio saves an audio preview and returns its URL
+func (s *Server) storeAudio(ctx context.Context, data []byte) (string, error) {
+	return s.storage.Save(ctx, uuid.NewString()+".mp3", "audio/mpeg", bytes.NewReader(data))
+}
+
+// GET /albums/{albumID}/audio -> serves the album's audio preview. Local
+// files honour Range requests so players can seek.
+func (s *Server) getAlbumAudio(c *gin.Context) {
+	albumID, ok := parseAlbumID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := s.queryContext(c.Request.Context())
+	defer cancel()
+	var audioURL sql.NullString
+	err := s.db.QueryRowContext(ctx, "SELECT audio_url FROM albums WHERE id = ? AND deleted_at IS NULL", albumID).Scan(&audioURL)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Album not found")
+		return
+	}
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	if !audioURL.Valid {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Album has no audio preview")
+		return
+	}
+
+	if isRemoteURL(audioURL.String) {
+		c.Redirect(http.StatusFound, audioURL.String)
+		return
+	}
+
+	c.Header("Content-Type", "audio/mpeg")
+	c.Header("Cache-Control", imageCacheControl)
+	serveFile(c, audioURL.String)
+}
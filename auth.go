@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// claimsContextKey is where jwtAuth stores the validated claims
+const claimsContextKey = "claims"
+
+// parseClaims validates an HS256 token and returns its registered claims
+func parseClaims(tokenString string, secret []byte) (*jwt.RegisteredClaims, error) {
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (any, error) {
+		return secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %v", err)
+	}
+
+	return claims, nil
+}
+
+// jwtAuth rejects requests without a valid Bearer token signed with secret
+func jwtAuth(secret []byte) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		tokenString, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok || tokenString == "" {
+			respondError(c, http.StatusUnauthorized, ErrCodeUnauthorized, "Missing bearer token")
+			return
+		}
+
+		claims, err := parseClaims(tokenString, secret)
+		if err != nil {
+			respondError(c, http.StatusUnauthorized, ErrCodeUnauthorized, "Invalid or expired token")
+			return
+		}
+
+		c.Set(claimsContextKey, claims)
+		c.Next()
+	}
+}
+
+// noAuth lets every request through, used when authentication is not configured
+func noAuth(c *gin.Context) {
+	c.Next()
+}
+
+// apiKeyContextKey is where apiKeyAuth stores the key that matched
+const apiKeyContextKey = "apiKey"
+
+type apiKeyKey struct{}
+
+// apiKeyFromContext returns the API key apiKeyAuth stored on the request
+// context, or "" when the request was not authenticated by API key
+func apiKeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(apiKeyKey{}).(string)
+	return key
+}
+
+// parseAPIKeys splits a comma-separated allowlist, dropping blanks
+func parseAPIKeys(list string) []string {
+	var keys []string
+	for _, k := range strings.Split(list, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// apiKeyAuth rejects requests whose X-API-Key header is not in keys
+func apiKeyAuth(keys []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provided := c.GetHeader("X-API-Key")
+		if provided == "" {
+			respondError(c, http.StatusUnauthorized, ErrCodeUnauthorized, "Missing API key")
+			return
+		}
+
+		// Compare against every key so timing doesn't reveal which one was close
+		matched := ""
+		for _, k := range keys {
+			if subtle.ConstantTimeCompare([]byte(provided), []byte(k)) == 1 {
+				matched = k
+			}
+		}
+		if matched == "" {
+			respondError(c, http.StatusUnauthorized, ErrCodeUnauthorized, "Invalid API key")
+			return
+		}
+
+		// Also on the request context, so upload transactions can charge the key
+		c.Set(apiKeyContextKey, matched)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), apiKeyKey{}, matched))
+		c.Next()
+	}
+}
+
+// newAuthMiddleware picks the authenticator named by AUTH_MODE ("jwt",
+// "apikey" or "none"). When AUTH_MODE is unset it uses JWT if JWT_SECRET is
+// set, then API keys if API_KEYS is set, and otherwise no authentication.
+func newAuthMiddleware(mode, jwtSecret, apiKeys string) (gin.HandlerFunc, error) {
+	if mode == "" {
+		switch {
+		case jwtSecret != "":
+			mode = "jwt"
+		case apiKeys != "":
+			mode = "apikey"
+		default:
+			mode = "none"
+		}
+	}
+
+	switch mode {
+	case "jwt":
+		if jwtSecret == "" {
+			return nil, fmt.Errorf("AUTH_MODE=jwt requires JWT_SECRET")
+		}
+		return jwtAuth([]byte(jwtSecret)), nil
+	case "apikey":
+		keys := parseAPIKeys(apiKeys)
+		if len(keys) == 0 {
+			return nil, fmt.Errorf("AUTH_MODE=apikey requires API_KEYS")
+		}
+		return apiKeyAuth(keys), nil
+	case "none":
+		slog.Warn("No authentication configured, album endpoints are unauthenticated")
+		return noAuth, nil
+	default:
+		return nil, fmt.Errorf("unknown AUTH_MODE %q", mode)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestJWTAuth(t *testing.T) {
+	const secret = "test-secret"
+	sign := func(method jwt.SigningMethod, key any, claims jwt.RegisteredClaims) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	valid := jwt.RegisteredClaims{Subject: "tester", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+	}{
+		{"valid token", "Bearer " + sign(jwt.SigningMethodHS256, []byte(secret), valid), http.StatusOK},
+		{"missing header", "", http.StatusUnauthorized},
+		{"other scheme", "Basic dXNlcjpwYXNz", http.StatusUnauthorized},
+		{"empty token", "Bearer ", http.StatusUnauthorized},
+		{"garbage token", "Bearer not.a.jwt", http.StatusUnauthorized},
+		{"wrong secret", "Bearer " + sign(jwt.SigningMethodHS256, []byte("other"), valid), http.StatusUnauthorized},
+		{"other algorithm", "Bearer " + sign(jwt.SigningMethodHS512, []byte(secret), valid), http.StatusUnauthorized},
+		{"unsigned", "Bearer " + sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, valid), http.StatusUnauthorized},
+		{"expired", "Bearer " + sign(jwt.SigningMethodHS256, []byte(secret),
+			jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute))}), http.StatusUnauthorized},
+		{"no expiry", "Bearer " + sign(jwt.SigningMethodHS256, []byte(secret), jwt.RegisteredClaims{Subject: "tester"}), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.POST("/albums", jwtAuth([]byte(secret)), func(c *gin.Context) {
+				if _, ok := c.Get(claimsContextKey); !ok {
+					t.Error("claims not set on the context")
+				}
+				c.Status(http.StatusOK)
+			})
+			req := httptest.NewRequest(http.MethodPost, "/albums", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d, body %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+func TestJWTAuthLeavesReadsPublic(t *testing.T) {
+	ts := newTestServer(t, func(cfg *Config) { cfg.AuthMode, cfg.JWTSecret = "jwt", "test-secret" })
+
+	if w := ts.do(http.MethodGet, "/health/live", "", nil); w.Code != http.StatusOK {
+		t.Errorf("GET /health/live status = %d", w.Code)
+	}
+	if w := ts.do(http.MethodGet, "/albums/0", "", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("GET /albums/0 status = %d, want 400 from the handler rather than 401", w.Code)
+	}
+	if w := ts.do(http.MethodDelete, "/albums/1", "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("DELETE /albums/1 status = %d, want 401", w.Code)
+	}
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+// Config holds every setting the service reads from the environment. It is
+// loaded and validated once at startup so a misconfigured deployment fails
+// immediately instead of when a handler first needs a value.
+type Config struct {
+	Port     string // PORT, default 8080
+	LogLevel string // LOG_LEVEL: debug, info, warn or error
+
+	OTLPEndpoint string // OTEL_EXPORTER_OTLP_ENDPOINT, OTLP/HTTP collector URL; unset disables tracing
+	ServiceName  string // OTEL_SERVICE_NAME, service name reported on spans
+
+	TLSCertFile string // TLS_CERT_FILE, serve HTTPS when set with TLS_KEY_FILE
+	TLSKeyFile  string // TLS_KEY_FILE
+
+	// Connection timeouts of the HTTP server; 0 disables one
+	HTTPReadHeaderTimeout time.Duration // HTTP_READ_HEADER_TIMEOUT, time to send the request headers; stops slowloris clients
+	HTTPReadTimeout       time.Duration // HTTP_READ_TIMEOUT, time to send the whole request, including an upload body
+	HTTPWriteTimeout      time.Duration // HTTP_WRITE_TIMEOUT, time from the end of the headers to the end of the response; exports are exempt
+	HTTPIdleTimeout       time.Duration // HTTP_IDLE_TIMEOUT, how long a keep-alive connection may wait for its next request
+	RequestTimeout        time.Duration // REQUEST_TIMEOUT, cooperative deadline on each handler, honoured by DB, storage and image processing; exports are exempt
+	ExportTimeout         time.Duration // EXPORT_TIMEOUT, longest GET /albums/export may run, in place of REQUEST_TIMEOUT; 0 disables
+
+	DBDSN             string        // DB_DSN, required
+	DBMaxOpenConns    int           // DB_MAX_OPEN_CONNS
+	DBMaxIdleConns    int           // DB_MAX_IDLE_CONNS
+	DBConnMaxLifetime time.Duration // DB_CONN_MAX_LIFETIME
+	DBQueryTimeout    time.Duration // DB_QUERY_TIMEOUT
+	DBSlowThreshold   time.Duration // DB_SLOW_QUERY_THRESHOLD, statements taking longer are logged; 0 disables
+	DBRetryAttempts   int           // DB_RETRY_MAX_ATTEMPTS, including the first try
+	DBRetryErrorCodes []int         // DB_RETRY_ERROR_CODES, MySQL error numbers treated as transient
+
+	AlbumCacheEnabled    bool          // ALBUM_CACHE_ENABLED, cache GET /albums/{albumID} in memory
+	AlbumCacheMaxEntries int           // ALBUM_CACHE_MAX_ENTRIES, albums kept before the least recently used is evicted
+	AlbumCacheTTL        time.Duration // ALBUM_CACHE_TTL, how stale a cached album may get, e.g. after a write on another replica
+
+	MaxUploadBytes int64 // MAX_UPLOAD_BYTES
+	MaxAudioBytes  int64 // MAX_AUDIO_BYTES, largest audio preview accepted with POST /albums
+	MaxJSONBytes   int64 // MAX_JSON_BODY_BYTES, largest JSON request body, independent of MAX_UPLOAD_BYTES
+	MaxImagePixels int64 // MAX_IMAGE_PIXELS, largest width×height of an image that is decoded
+	StripEXIF      bool  // STRIP_EXIF
+	AutoOrient     bool  // AUTO_ORIENT, rotate JPEG uploads upright from their EXIF orientation
+	DedupUploads   bool  // DEDUP_UPLOADS
+	ConvertWebP    bool  // CONVERT_WEBP, serve cached WebP copies when the client accepts them
+
+	ReencodeFormat  string // REENCODE_FORMAT: jpeg, png or webp to store every upload in that format; unset keeps each upload's own
+	ReencodeQuality int    // REENCODE_QUALITY, JPEG quality from 1 to 100
+	KeepOriginals   bool   // KEEP_ORIGINALS, store re-encoded uploads as received under ./originals or S3_PREFIX/originals
+
+	MultipartMemoryBytes int64 // MULTIPART_MEMORY_BYTES, form data held in memory before spilling to temp files
+	MultipartMaxParts    int   // MULTIPART_MAX_PARTS, most fields plus files accepted in an upload form
+
+	PlaceholderImage string // PLACEHOLDER_IMAGE, image file served for albums without one; unset keeps the 404
+
+	PublicBaseURL string // PUBLIC_BASE_URL, e.g. https://albums.example.com, prefixed to image URLs of locally stored files
+
+	StorageBackend      string   // STORAGE_BACKEND: local or s3
+	StorageMinFreeBytes int64    // STORAGE_MIN_FREE_BYTES, free disk space local storage needs to pass /health/ready; 0 disables
+	StorageShardDepth   int      // STORAGE_SHARD_DEPTH, levels of two-character hash subdirectories for local files; 0 keeps a flat directory
+	S3                  S3Config // S3_BUCKET, S3_REGION (or AWS_REGION), S3_PREFIX, S3_ENDPOINT, S3_FORCE_PATH_STYLE
+
+	DirectUploadTTL time.Duration // DIRECT_UPLOAD_TTL, lifetime of presigned upload URLs
+
+	SourceFetchTimeout time.Duration // SOURCE_FETCH_TIMEOUT, limit on downloading a source_url image
+
+	UploadSessionDir string        // UPLOAD_SESSION_DIR, where resumable uploads are staged
+	UploadSessionTTL time.Duration // UPLOAD_SESSION_TTL, how long a resumable upload may sit idle
+
+	IdempotencyKeyTTL time.Duration // IDEMPOTENCY_KEY_TTL, how long POST /albums replays the result for a repeated Idempotency-Key
+
+	MetadataStorage string // METADATA_STORAGE: json keeps all metadata in the JSON column; normalized stores artist, title and year in typed columns
+	DefaultLocale   string // DEFAULT_LOCALE, BCP 47 locale of plain album titles
+
+	UploadQuotaBytes int64 // UPLOAD_QUOTA_BYTES, total bytes each API key may upload; 0 tracks usage without a limit
+
+	WebhookURL     string        // WEBHOOK_URL, receives album.created events; unset disables webhooks
+	WebhookSecret  string        // WEBHOOK_SECRET, HMAC-SHA256 key for X-AlbumStore-Signature
+	WebhookTimeout time.Duration // WEBHOOK_TIMEOUT, per delivery attempt
+
+	AuthMode         string // AUTH_MODE: jwt, apikey or none
+	JWTSecret        string // JWT_SECRET
+	APIKeys          string // API_KEYS, comma-separated
+	AuthProtectReads bool   // AUTH_PROTECT_READS (or the older JWT_PROTECT_READS)
+
+	CORS corsConfig // CORS_ALLOWED_ORIGINS, CORS_ALLOWED_METHODS, CORS_ALLOWED_HEADERS
+
+	RateLimitRPS   float64  // RATE_LIMIT_RPS, 0 disables limiting
+	RateLimitBurst int      // RATE_LIMIT_BURST
+	TrustedProxies []string // TRUSTED_PROXIES, IPs or CIDRs whose X-Forwarded-For names the client; unset trusts none
+	GzipMinSize    int      // GZIP_MIN_SIZE
+
+	EnablePprof bool // ENABLE_PPROF, serves /debug/pprof behind the write authenticator
+
+	DebugLogBodies    bool // DEBUG_LOG_BODIES, logs JSON and XML request and response bodies
+	DebugLogBodyBytes int  // DEBUG_LOG_BODY_BYTES, how much of each body is logged
+
+	SelfTest bool // SELFTEST, same as --selftest: check dependencies and exit instead of serving
+}
+
+// loadConfig reads the environment into a Config. Every missing or invalid
+// variable is reported together in the returned error.
+func loadConfig() (Config, error) {
+	e := &envReader{}
+
+	cfg := Config{
+		Port:     e.string("PORT", "8080"),
+		LogLevel: e.string("LOG_LEVEL", "info"),
+
+		OTLPEndpoint: e.string("OTEL_EXPORTER_OTLP_ENDPOINT", ""),
+		ServiceName:  e.string("OTEL_SERVICE_NAME", "album-store"),
+
+		TLSCertFile: e.string("TLS_CERT_FILE", ""),
+		TLSKeyFile:  e.string("TLS_KEY_FILE", ""),
+
+		// Reads and writes get two minutes so a maximum-size upload or image
+		// still completes over a slow mobile link
+		HTTPReadHeaderTimeout: e.duration("HTTP_READ_HEADER_TIMEOUT", 10*time.Second),
+		HTTPReadTimeout:       e.duration("HTTP_READ_TIMEOUT", 2*time.Minute),
+		HTTPWriteTimeout:      e.duration("HTTP_WRITE_TIMEOUT", 2*time.Minute),
+		HTTPIdleTimeout:       e.duration("HTTP_IDLE_TIMEOUT", 2*time.Minute),
+		// Runs from the first byte, so it matches the read timeout to leave a
+		// slow upload time to be stored once its body has arrived
+		RequestTimeout: e.duration("REQUEST_TIMEOUT", 2*time.Minute),
+		ExportTimeout:  e.duration("EXPORT_TIMEOUT", 30*time.Minute),
+
+		DBDSN: e.required("DB_DSN"),
+		// Pool defaults: 25 open connections keeps us well under MySQL's default
+		// max_connections of 151 with a few replicas, 10 idle connections avoids
+		// reconnect churn between bursts, and a 5 minute lifetime recycles
+		// connections before proxies or the server drop them as stale.
+		DBMaxOpenConns:    e.int("DB_MAX_OPEN_CONNS", 25),
+		DBMaxIdleConns:    e.int("DB_MAX_IDLE_CONNS", 10),
+		DBConnMaxLifetime: e.duration("DB_CONN_MAX_LIFETIME", 5*time.Minute),
+		DBQueryTimeout:    e.duration("DB_QUERY_TIMEOUT", 5*time.Second),
+		DBSlowThreshold:   e.duration("DB_SLOW_QUERY_THRESHOLD", 500*time.Millisecond),
+		// 1205 is ER_LOCK_WAIT_TIMEOUT and 1213 is ER_LOCK_DEADLOCK
+		DBRetryAttempts:   e.int("DB_RETRY_MAX_ATTEMPTS", 3),
+		DBRetryErrorCodes: e.intList("DB_RETRY_ERROR_CODES", []int{1205, 1213}),
+
+		AlbumCacheEnabled:    e.bool("ALBUM_CACHE_ENABLED", true),
+		AlbumCacheMaxEntries: e.int("ALBUM_CACHE_MAX_ENTRIES", 1000),
+		AlbumCacheTTL:        e.duration("ALBUM_CACHE_TTL", 30*time.Second),
+
+		MaxUploadBytes: int64(e.int("MAX_UPLOAD_BYTES", 10<<20)),
+		MaxAudioBytes:  int64(e.int("MAX_AUDIO_BYTES", 5<<20)),
+		MaxJSONBytes:   int64(e.int("MAX_JSON_BODY_BYTES", 1<<20)),
+		MaxImagePixels: int64(e.int("MAX_IMAGE_PIXELS", 50_000_000)),
+		StripEXIF:      e.bool("STRIP_EXIF", true),
+		AutoOrient:     e.bool("AUTO_ORIENT", true),
+		DedupUploads:   e.bool("DEDUP_UPLOADS", true),
+		ConvertWebP:    e.bool("CONVERT_WEBP", false),
+
+		ReencodeFormat:  e.string("REENCODE_FORMAT", ""),
+		ReencodeQuality: e.int("REENCODE_QUALITY", 85),
+		KeepOriginals:   e.bool("KEEP_ORIGINALS", false),
+
+		MultipartMemoryBytes: int64(e.int("MULTIPART_MEMORY_BYTES", 8<<20)),
+		MultipartMaxParts:    e.int("MULTIPART_MAX_PARTS", 50),
+
+		PlaceholderImage: e.string("PLACEHOLDER_IMAGE", ""),
+
+		PublicBaseURL: e.string("PUBLIC_BASE_URL", ""),
+
+		StorageBackend:      e.string("STORAGE_BACKEND", "local"),
+		StorageMinFreeBytes: int64(e.int("STORAGE_MIN_FREE_BYTES", 100<<20)),
+		StorageShardDepth:   e.int("STORAGE_SHARD_DEPTH", 0),
+		S3: S3Config{
+			Bucket:         e.string("S3_BUCKET", ""),
+			Region:         e.string("S3_REGION", os.Getenv("AWS_REGION")),
+			Prefix:         e.string("S3_PREFIX", ""),
+			Endpoint:       e.string("S3_ENDPOINT", ""),
+			ForcePathStyle: e.bool("S3_FORCE_PATH_STYLE", false),
+		},
+
+		DirectUploadTTL: e.duration("DIRECT_UPLOAD_TTL", 15*time.Minute),
+
+		SourceFetchTimeout: e.duration("SOURCE_FETCH_TIMEOUT", 30*time.Second),
+
+		UploadSessionDir: e.string("UPLOAD_SESSION_DIR", "./upload-sessions"),
+		UploadSessionTTL: e.duration("UPLOAD_SESSION_TTL", 24*time.Hour),
+
+		IdempotencyKeyTTL: e.duration("IDEMPOTENCY_KEY_TTL", 24*time.Hour),
+
+		MetadataStorage: e.string("METADATA_STORAGE", "json"),
+		DefaultLocale:   e.string("DEFAULT_LOCALE", "en"),
+
+		UploadQuotaBytes: int64(e.int("UPLOAD_QUOTA_BYTES", 0)),
+
+		WebhookURL:     e.string("WEBHOOK_URL", ""),
+		WebhookSecret:  e.string("WEBHOOK_SECRET", ""),
+		WebhookTimeout: e.duration("WEBHOOK_TIMEOUT", 5*time.Second),
+
+		AuthMode:         e.string("AUTH_MODE", ""),
+		JWTSecret:        e.string("JWT_SECRET", ""),
+		APIKeys:          e.string("API_KEYS", ""),
+		AuthProtectReads: e.bool("AUTH_PROTECT_READS", false) || e.bool("JWT_PROTECT_READS", false),
+
+		CORS: corsConfig{
+			AllowedOrigins: e.list("CORS_ALLOWED_ORIGINS", nil),
+			AllowedMethods: e.list("CORS_ALLOWED_METHODS", []string{"GET", "POST", "PUT", "PATCH", "DELETE"}),
+			AllowedHeaders: e.list("CORS_ALLOWED_HEADERS", []string{"Authorization", "Content-Type", "Idempotency-Key", "If-Match", "Range", "Upload-Offset", "X-API-Key"}),
+		},
+
+		RateLimitRPS:   e.float("RATE_LIMIT_RPS", 10),
+		RateLimitBurst: e.int("RATE_LIMIT_BURST", 20),
+		TrustedProxies: e.list("TRUSTED_PROXIES", nil),
+		GzipMinSize:    e.int("GZIP_MIN_SIZE", 1024),
+
+		// Profiles expose memory contents and stack traces, so they are opt-in
+		EnablePprof: e.bool("ENABLE_PPROF", false),
+
+		// Bodies hold whatever clients send, so logging them is opt-in too
+		DebugLogBodies:    e.bool("DEBUG_LOG_BODIES", false),
+		DebugLogBodyBytes: e.int("DEBUG_LOG_BODY_BYTES", 4096),
+
+		SelfTest: e.bool("SELFTEST", false),
+	}
+
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		e.fail("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	for name, d := range map[string]time.Duration{
+		"HTTP_READ_HEADER_TIMEOUT": cfg.HTTPReadHeaderTimeout,
+		"HTTP_READ_TIMEOUT":        cfg.HTTPReadTimeout,
+		"HTTP_WRITE_TIMEOUT":       cfg.HTTPWriteTimeout,
+		"HTTP_IDLE_TIMEOUT":        cfg.HTTPIdleTimeout,
+		"REQUEST_TIMEOUT":          cfg.RequestTimeout,
+		"EXPORT_TIMEOUT":           cfg.ExportTimeout,
+		"DB_SLOW_QUERY_THRESHOLD":  cfg.DBSlowThreshold,
+	} {
+		if d < 0 {
+			e.fail(name + " must not be negative")
+		}
+	}
+	if cfg.PublicBaseURL != "" {
+		if u, err := url.Parse(cfg.PublicBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" {
+			e.fail("PUBLIC_BASE_URL must be an absolute http or https URL without a query")
+		}
+	}
+	if cfg.WebhookURL != "" {
+		if u, err := url.Parse(cfg.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			e.fail("WEBHOOK_URL must be an absolute http or https URL")
+		}
+		if cfg.WebhookSecret == "" {
+			e.fail("WEBHOOK_SECRET is required when WEBHOOK_URL is set")
+		}
+		if cfg.WebhookTimeout <= 0 {
+			e.fail("WEBHOOK_TIMEOUT must be positive")
+		}
+	}
+	if cfg.DebugLogBodies && cfg.DebugLogBodyBytes < 1 {
+		e.fail("DEBUG_LOG_BODY_BYTES must be at least 1")
+	}
+	if cfg.SourceFetchTimeout <= 0 {
+		e.fail("SOURCE_FETCH_TIMEOUT must be positive")
+	}
+	if cfg.DBQueryTimeout <= 0 {
+		// Every DB call derives its deadline from it, so 0 would fail them all
+		e.fail("DB_QUERY_TIMEOUT must be positive")
+	}
+	if cfg.DBMaxOpenConns < 1 {
+		e.fail("DB_MAX_OPEN_CONNS must be at least 1")
+	}
+	if cfg.DBMaxIdleConns < 0 || cfg.DBMaxIdleConns > cfg.DBMaxOpenConns {
+		e.fail("DB_MAX_IDLE_CONNS must be between 0 and DB_MAX_OPEN_CONNS")
+	}
+	if cfg.DBRetryAttempts < 1 {
+		e.fail("DB_RETRY_MAX_ATTEMPTS must be at least 1")
+	}
+	for _, code := range cfg.DBRetryErrorCodes {
+		if code < 1 || code > 65535 {
+			e.fail(fmt.Sprintf("DB_RETRY_ERROR_CODES must be MySQL error numbers, got %d", code))
+		}
+	}
+	if cfg.AlbumCacheEnabled {
+		if cfg.AlbumCacheMaxEntries < 1 {
+			e.fail("ALBUM_CACHE_MAX_ENTRIES must be at least 1")
+		}
+		if cfg.AlbumCacheTTL <= 0 {
+			e.fail("ALBUM_CACHE_TTL must be positive")
+		}
+	}
+	if cfg.MultipartMemoryBytes <= 0 {
+		e.fail("MULTIPART_MEMORY_BYTES must be positive")
+	}
+	if cfg.MultipartMaxParts < 1 {
+		e.fail("MULTIPART_MAX_PARTS must be at least 1")
+	}
+	if cfg.MaxUploadBytes <= 0 {
+		e.fail("MAX_UPLOAD_BYTES must be positive")
+	}
+	if cfg.MaxAudioBytes <= 0 {
+		e.fail("MAX_AUDIO_BYTES must be positive")
+	}
+	if cfg.MaxJSONBytes <= 0 {
+		e.fail("MAX_JSON_BODY_BYTES must be positive")
+	}
+	if cfg.MaxImagePixels <= 0 {
+		e.fail("MAX_IMAGE_PIXELS must be positive")
+	}
+	if cfg.UploadSessionTTL < time.Second {
+		e.fail("UPLOAD_SESSION_TTL must be at least 1s")
+	}
+	if cfg.IdempotencyKeyTTL < time.Second {
+		e.fail("IDEMPOTENCY_KEY_TTL must be at least 1s")
+	}
+	if cfg.MetadataStorage != "json" && cfg.MetadataStorage != "normalized" {
+		e.fail(fmt.Sprintf("METADATA_STORAGE must be json or normalized, got %q", cfg.MetadataStorage))
+	}
+	if _, err := language.Parse(cfg.DefaultLocale); err != nil {
+		e.fail(fmt.Sprintf("DEFAULT_LOCALE must be a BCP 47 locale code, got %q", cfg.DefaultLocale))
+	}
+	if cfg.UploadQuotaBytes < 0 {
+		e.fail("UPLOAD_QUOTA_BYTES must not be negative")
+	}
+	if cfg.DirectUploadTTL < time.Second || cfg.DirectUploadTTL > 7*24*time.Hour {
+		// S3 rejects presigned URLs valid for longer than a week
+		e.fail("DIRECT_UPLOAD_TTL must be between 1s and 168h")
+	}
+	if _, ok := reencodeFormats[cfg.ReencodeFormat]; cfg.ReencodeFormat != "" && !ok {
+		e.fail(fmt.Sprintf("REENCODE_FORMAT must be jpeg, png or webp, got %q", cfg.ReencodeFormat))
+	}
+	if cfg.ReencodeQuality < 1 || cfg.ReencodeQuality > 100 {
+		e.fail("REENCODE_QUALITY must be between 1 and 100")
+	}
+	if cfg.KeepOriginals && cfg.ReencodeFormat == "" {
+		e.fail("KEEP_ORIGINALS needs REENCODE_FORMAT")
+	}
+	if cfg.StorageMinFreeBytes < 0 {
+		e.fail("STORAGE_MIN_FREE_BYTES must not be negative")
+	}
+	if cfg.StorageShardDepth < 0 || cfg.StorageShardDepth > maxShardDepth {
+		e.fail(fmt.Sprintf("STORAGE_SHARD_DEPTH must be between 0 and %d", maxShardDepth))
+	}
+	if cfg.RateLimitRPS < 0 {
+		e.fail("RATE_LIMIT_RPS must not be negative")
+	}
+	for _, proxy := range cfg.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			e.fail(fmt.Sprintf("TRUSTED_PROXIES must list IPs or CIDRs, got %q", proxy))
+		}
+	}
+	switch cfg.StorageBackend {
+	case "local":
+	case "s3":
+		if cfg.S3.Bucket == "" {
+			e.fail("S3_BUCKET is required when STORAGE_BACKEND=s3")
+		}
+	default:
+		e.fail(fmt.Sprintf("STORAGE_BACKEND must be local or s3, got %q", cfg.StorageBackend))
+	}
+	switch cfg.AuthMode {
+	case "", "none":
+	case "jwt":
+		if cfg.JWTSecret == "" {
+			e.fail("JWT_SECRET is required when AUTH_MODE=jwt")
+		}
+	case "apikey":
+		if len(parseAPIKeys(cfg.APIKeys)) == 0 {
+			e.fail("API_KEYS is required when AUTH_MODE=apikey")
+		}
+	default:
+		e.fail(fmt.Sprintf("AUTH_MODE must be jwt, apikey or none, got %q", cfg.AuthMode))
+	}
+
+	return cfg, e.err()
+}
+
+// envReader parses environment variables, collecting problems instead of
+// stopping at the first one
+type envReader struct {
+	problems []string
+}
+
+func (e *envReader) fail(problem string) {
+	e.problems = append(e.problems, problem)
+}
+
+func (e *envReader) err() error {
+	if len(e.problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration: %s", strings.Join(e.problems, "; "))
+}
+
+func (e *envReader) string(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func (e *envReader) required(key string) string {
+	v := os.Getenv(key)
+	if v == "" {
+		e.fail(key + " is required")
+	}
+	return v
+}
+
+func (e *envReader) int(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		e.fail(fmt.Sprintf("%s must be an integer, got %q", key, v))
+		return def
+	}
+	return n
+}
+
+func (e *envReader) float(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		e.fail(fmt.Sprintf("%s must be a number, got %q", key, v))
+		return def
+	}
+	return f
+}
+
+func (e *envReader) bool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		e.fail(fmt.Sprintf("%s must be true or false, got %q", key, v))
+		return def
+	}
+	return b
+}
+
+// duration parses values such as "30s" or "5m"
+func (e *envReader) duration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		e.fail(fmt.Sprintf("%s must be a duration such as 30s, got %q", key, v))
+		return def
+	}
+	return d
+}
+
+// list splits a comma-separated value, dropping blank items
+func (e *envReader) list(key string, def []string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// intList splits a comma-separated list of integers
+func (e *envReader) intList(key string, def []int) []int {
+	items := e.list(key, nil)
+	if items == nil {
+		return def
+	}
+	var nums []int
+	for _, item := range items {
+		n, err := strconv.Atoi(item)
+		if err != nil {
+			e.fail(fmt.Sprintf("%s must be a comma-separated list of integers, got %q", key, item))
+			return def
+		}
+		nums = append(nums, n)
+	}
+	return nums
+}
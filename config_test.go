@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigDBPoolValues(t *testing.T) {
+	tests := []struct {
+		name                      string
+		env                       map[string]string
+		wantOpen, wantIdle        int
+		wantLifetime, wantTimeout time.Duration
+	}{
+		{"defaults", nil, 25, 10, 5 * time.Minute, 5 * time.Second},
+		{"overridden", map[string]string{
+			"DB_MAX_OPEN_CONNS": "50", "DB_MAX_IDLE_CONNS": "20", "DB_CONN_MAX_LIFETIME": "90s", "DB_QUERY_TIMEOUT": "3s",
+		}, 50, 20, 90 * time.Second, 3 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DB_DSN", "test:test@tcp(127.0.0.1:3306)/albums")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			cfg, err := loadConfig()
+			if err != nil {
+				t.Fatalf("loadConfig: %v", err)
+			}
+			if cfg.DBMaxOpenConns != tt.wantOpen || cfg.DBMaxIdleConns != tt.wantIdle || cfg.DBConnMaxLifetime != tt.wantLifetime {
+				t.Errorf("pool = %d open, %d idle, %v lifetime; want %d, %d, %v",
+					cfg.DBMaxOpenConns, cfg.DBMaxIdleConns, cfg.DBConnMaxLifetime, tt.wantOpen, tt.wantIdle, tt.wantLifetime)
+			}
+			if cfg.DBQueryTimeout != tt.wantTimeout {
+				t.Errorf("DBQueryTimeout = %v, want %v", cfg.DBQueryTimeout, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+func TestLoadConfigDBPool(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{"defaults", nil, ""},
+		{"zero query timeout", map[string]string{"DB_QUERY_TIMEOUT": "0s"}, "DB_QUERY_TIMEOUT must be positive"},
+		{"negative query timeout", map[string]string{"DB_QUERY_TIMEOUT": "-1s"}, "DB_QUERY_TIMEOUT must be positive"},
+		{"no open connections", map[string]string{"DB_MAX_OPEN_CONNS": "0", "DB_MAX_IDLE_CONNS": "0"}, "DB_MAX_OPEN_CONNS must be at least 1"},
+		{"more idle than open", map[string]string{"DB_MAX_OPEN_CONNS": "5", "DB_MAX_IDLE_CONNS": "6"}, "DB_MAX_IDLE_CONNS must be between 0 and DB_MAX_OPEN_CONNS"},
+		{"negative idle", map[string]string{"DB_MAX_IDLE_CONNS": "-1"}, "DB_MAX_IDLE_CONNS must be between 0 and DB_MAX_OPEN_CONNS"},
+		{"idle equal to open", map[string]string{"DB_MAX_OPEN_CONNS": "5", "DB_MAX_IDLE_CONNS": "5"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DB_DSN", "test:test@tcp(127.0.0.1:3306)/albums")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			_, err := loadConfig()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("loadConfig: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadConfig error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
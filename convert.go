@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/HugoSmits86/nativewebp"
+)
+
+// webpVariantExt is appended to a stored image's path for its cached WebP copy
+const webpVariantExt = ".variant.webp"
+
+// acceptsWebP reports whether an Accept header explicitly lists image/webp with
+// a non-zero quality. Wildcards don't count: clients that only send */* get
+// the original format.
+func acceptsWebP(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || mediaType != "image/webp" {
+			continue
+		}
+		if q, ok := params["q"]; ok {
+			if v, err := strconv.ParseFloat(q, 64); err != nil || v <= 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// webpVariant returns the path of a WebP copy of the local image at path,
+// transcoding and caching it next to the original on first use. It returns
+// "" when the original should be served as is: it is already WebP, it can't
+// be decoded or has more than maxPixels, or the lossless WebP copy would be
+// larger than the original.
+func webpVariant(ctx context.Context, path string, maxPixels int64) string {
+	original, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+
+	variantPath := path + webpVariantExt
+	if variant, err := os.Stat(variantPath); err == nil {
+		if variant.Size() < original.Size() {
+			return variantPath
+		}
+		return ""
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	if http.DetectContentType(data) == "image/webp" {
+		return ""
+	}
+
+	size, err := writeWebPVariant(ctx, data, variantPath, maxPixels)
+	if err != nil {
+		slog.WarnContext(ctx, "Skipping WebP conversion", "path", path, "error", err)
+		return ""
+	}
+	if size < original.Size() {
+		return variantPath
+	}
+	return ""
+}
+
+// writeWebPVariant transcodes an image to WebP and writes it atomically to
+// path, so concurrent requests never serve a half-written file. The variant
+// is kept even when it turns out larger, which stops later requests from
+// transcoding again.
+func writeWebPVariant(ctx context.Context, data []byte, path string, maxPixels int64) (int64, error) {
+	img, err := decodeImage(ctx, data, maxPixels)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode image: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var buf bytes.Buffer
+	if err := nativewebp.Encode(&buf, img, nil); err != nil {
+		return 0, fmt.Errorf("failed to encode WebP: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".variant-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create variant: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("failed to write variant: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write variant: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, fmt.Errorf("failed to store variant: %v", err)
+	}
+	return int64(buf.Len()), nil
+}
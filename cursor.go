@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// albumCursor marks where a keyset-paginated listing stopped. Clients treat
+// the encoded form as opaque.
+type albumCursor struct {
+	CreatedAt time.Time `json:"c"`
+	ID        int       `json:"i"`
+	Dir       string    `json:"d"`
+}
+
+// encodeCursor returns the opaque cursor that continues after album
+func encodeCursor(album AlbumInfo, dir string) string {
+	data, _ := json.Marshal(albumCursor{CreatedAt: album.CreatedAt, ID: album.AlbumID, Dir: dir})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a cursor produced by encodeCursor
+func decodeCursor(s string) (albumCursor, error) {
+	var cur albumCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(data, &cur) != nil || cur.ID <= 0 || (cur.Dir != "ASC" && cur.Dir != "DESC") {
+		return cur, fmt.Errorf("cursor is invalid")
+	}
+	return cur, nil
+}
+
+// addTo narrows f to the rows after cur in (created_at, id) order
+func (cur albumCursor) addTo(f *albumFilter) {
+	op := "<"
+	if cur.Dir == "ASC" {
+		op = ">"
+	}
+	f.add("(created_at "+op+" ? OR (created_at = ? AND id "+op+" ?))", cur.CreatedAt, cur.CreatedAt, cur.ID)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestDecodeCursor(t *testing.T) {
+	created := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	raw := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	tests := []struct {
+		name    string
+		cursor  string
+		want    albumCursor
+		wantErr bool
+	}{
+		{"round trip", encodeCursor(AlbumInfo{AlbumID: 42, CreatedAt: created}, "DESC"), albumCursor{CreatedAt: created, ID: 42, Dir: "DESC"}, false},
+		{"ascending", encodeCursor(AlbumInfo{AlbumID: 1, CreatedAt: created}, "ASC"), albumCursor{CreatedAt: created, ID: 1, Dir: "ASC"}, false},
+		{"empty", "", albumCursor{}, true},
+		{"not base64", "not a cursor!", albumCursor{}, true},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"c":"2024-05-06T07:08:09Z","i":42,"d":"DESC"}`)), albumCursor{}, true},
+		{"not JSON", raw("42"), albumCursor{}, true},
+		{"zero id", raw(`{"c":"2024-05-06T07:08:09Z","i":0,"d":"DESC"}`), albumCursor{}, true},
+		{"negative id", raw(`{"c":"2024-05-06T07:08:09Z","i":-3,"d":"DESC"}`), albumCursor{}, true},
+		{"bad direction", raw(`{"c":"2024-05-06T07:08:09Z","i":42,"d":"desc"}`), albumCursor{}, true},
+		{"bad time", raw(`{"c":"yesterday","i":42,"d":"DESC"}`), albumCursor{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeCursor(tt.cursor)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("decodeCursor(%q) = %+v, want an error", tt.cursor, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeCursor: %v", err)
+			}
+			if !got.CreatedAt.Equal(tt.want.CreatedAt) || got.ID != tt.want.ID || got.Dir != tt.want.Dir {
+				t.Errorf("decodeCursor = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// redactedHeaders are never logged in the clear
+var redactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"X-Api-Key":           true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// redactedFields are JSON object keys whose values are replaced before a
+// body is logged, wherever they appear. A presigned upload_url lets anyone
+// holding it write to the bucket until it expires.
+var redactedFields = []string{"password", "secret", "token", "api_key", "apikey", "authorization", "upload_url"}
+
+// sensitiveValue matches a redactedFields key and its string or scalar
+// value in raw JSON, even when the body was cut off partway through
+var sensitiveValue = regexp.MustCompile(`(?i)("[^"]*(?:` + strings.Join(redactedFields, "|") + `)[^"]*"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\]\s]*)`)
+
+// amzQueryValue matches the value of an X-Amz-* query parameter, such as
+// the signature and credential of a presigned S3 URL, in a URL that is
+// plain, JSON-escaped or XML-escaped
+var amzQueryValue = regexp.MustCompile(`(?i)((?:\?|&(?:amp;)?|\\u0026)X-Amz-[a-z0-9-]+=)[^&"'<\s\\]*`)
+
+// redactPresigned replaces the X-Amz-* query values in s
+func redactPresigned(s string) string {
+	return amzQueryValue.ReplaceAllString(s, "${1}"+redacted)
+}
+
+// redacted replaces sensitive values in logged headers and bodies
+const redacted = "[REDACTED]"
+
+// logBodies logs the headers and the first maxBytes of the request and
+// response bodies of JSON and XML exchanges, for debugging what clients
+// send. Credentials are redacted; multipart, image and other binary
+// bodies are never captured, only noted. It sits inside gzip so it sees
+// uncompressed responses, and captures while the handler streams, so
+// bodies reach the handler and client unchanged.
+func logBodies(maxBytes int) gin.HandlerFunc {
+	slog.Warn("DEBUG_LOG_BODIES is set, request and response bodies are logged; do not leave this on in production")
+
+	return func(c *gin.Context) {
+		var req *bodyCapture
+		if textual(c.GetHeader("Content-Type")) && c.Request.Body != nil {
+			req = &bodyCapture{max: maxBytes}
+			c.Request.Body = &captureReader{ReadCloser: c.Request.Body, capture: req}
+		}
+		w := &captureWriter{ResponseWriter: c.Writer, capture: bodyCapture{max: maxBytes}}
+		c.Writer = w
+
+		c.Next()
+
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"request_headers", sanitizeHeaders(c.Request.Header),
+			"request_body", req.String(c.GetHeader("Content-Type")),
+			"response_headers", sanitizeHeaders(w.Header()),
+		}
+		if w.captured {
+			attrs = append(attrs, "response_body", w.capture.String(w.Header().Get("Content-Type")))
+		} else {
+			attrs = append(attrs, "response_body", omitted(w.Header().Get("Content-Type")))
+		}
+		slog.InfoContext(c.Request.Context(), "Request and response bodies", attrs...)
+	}
+}
+
+// textual reports whether a Content-Type is JSON or XML, the bodies worth
+// and safe to log
+func textual(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || mediaType == "application/xml" || mediaType == "text/xml" ||
+		strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+// omitted describes a body that was not captured
+func omitted(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+	return "[omitted " + contentType + "]"
+}
+
+// sanitizeHeaders copies h with credentials redacted
+func sanitizeHeaders(h http.Header) map[string]string {
+	out := make(map[string]string, len(h))
+	for name, values := range h {
+		if redactedHeaders[http.CanonicalHeaderKey(name)] {
+			out[name] = redacted
+			continue
+		}
+		out[name] = redactPresigned(strings.Join(values, ", "))
+	}
+	return out
+}
+
+// bodyCapture keeps the first max bytes of a body and counts the rest
+type bodyCapture struct {
+	max   int
+	buf   []byte
+	total int
+}
+
+func (b *bodyCapture) write(p []byte) {
+	b.total += len(p)
+	if room := b.max - len(b.buf); room > 0 {
+		b.buf = append(b.buf, p[:min(room, len(p))]...)
+	}
+}
+
+// String renders the captured body for the log, redacting sensitive JSON
+// fields and presigned URL parameters. A body cut off at max can't be
+// parsed, so its fields are redacted by pattern and its full size noted. A
+// nil capture is a body that wasn't read.
+func (b *bodyCapture) String(contentType string) string {
+	if b == nil {
+		return omitted(contentType)
+	}
+	if b.total > len(b.buf) {
+		body := sensitiveValue.ReplaceAllString(string(b.buf), `${1}"`+redacted+`"`)
+		return redactPresigned(body) + "... [truncated, " + strconv.Itoa(b.total) + " bytes]"
+	}
+
+	var v any
+	if json.Unmarshal(b.buf, &v) == nil {
+		if out, err := json.Marshal(redactJSON(v)); err == nil {
+			return redactPresigned(string(out))
+		}
+	}
+	return redactPresigned(string(b.buf))
+}
+
+// redactJSON replaces the values of redactedFields in a decoded JSON value
+func redactJSON(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, val := range v {
+			if sensitiveField(k) {
+				v[k] = redacted
+			} else {
+				v[k] = redactJSON(val)
+			}
+		}
+	case []any:
+		for i := range v {
+			v[i] = redactJSON(v[i])
+		}
+	}
+	return v
+}
+
+// sensitiveField reports whether a JSON key names a credential
+func sensitiveField(key string) bool {
+	key = strings.ToLower(key)
+	for _, f := range redactedFields {
+		if strings.Contains(key, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// captureReader records what the handler reads from the request body
+type captureReader struct {
+	io.ReadCloser
+	capture *bodyCapture
+}
+
+func (r *captureReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.capture.write(p[:n])
+	return n, err
+}
+
+// captureWriter records response bodies whose Content-Type is textual,
+// deciding on the first write once the handler has set it
+type captureWriter struct {
+	gin.ResponseWriter
+	capture  bodyCapture
+	decided  bool
+	captured bool
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.decided = true
+		w.captured = textual(w.Header().Get("Content-Type"))
+	}
+	if w.captured {
+		w.capture.write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Unwrap lets http.ResponseController reach the connection
+func (w *captureWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const presignedURL = "https://bucket.s3.amazonaws.com/albums/k.jpg?X-Amz-Algorithm=AWS4-HMAC-SHA256" +
+	"&X-Amz-Credential=AKIAEXAMPLE%2F20260101%2Fus-east-1%2Fs3%2Faws4_request&X-Amz-Expires=900" +
+	"&X-Amz-SignedHeaders=content-type%3Bhost&X-Amz-Signature=0123456789abcdef"
+
+func TestRedactPresigned(t *testing.T) {
+	tests := []struct{ name, in string }{
+		{"plain", presignedURL},
+		{"json escaped", strings.ReplaceAll(presignedURL, "&", `\u0026`)},
+		{"xml escaped", strings.ReplaceAll(presignedURL, "&", "&amp;")},
+		{"lower case", strings.ToLower(presignedURL)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := redactPresigned(tt.in)
+			for _, secret := range []string{"AKIAEXAMPLE", "0123456789abcdef"} {
+				if strings.Contains(strings.ToLower(got), strings.ToLower(secret)) {
+					t.Errorf("redactPresigned kept %s: %s", secret, got)
+				}
+			}
+			if !strings.HasPrefix(got, strings.SplitN(tt.in, "?", 2)[0]) {
+				t.Errorf("redactPresigned changed the URL before the query: %s", got)
+			}
+		})
+	}
+
+	if got := redactPresigned("https://cdn.example.com/a.jpg?v=2"); got != "https://cdn.example.com/a.jpg?v=2" {
+		t.Errorf("plain URL changed to %s", got)
+	}
+}
+
+func TestLogBodiesRedactsPresignedURLs(t *testing.T) {
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	for _, maxBytes := range []int{4096, 200} {
+		logs.Reset()
+		r := gin.New()
+		r.Use(logBodies(maxBytes))
+		r.POST("/albums/upload-url", func(c *gin.Context) {
+			c.Header("Location", presignedURL)
+			c.JSON(http.StatusCreated, gin.H{"upload_url": presignedURL, "upload_id": "u1", "image_url": presignedURL})
+		})
+		req := httptest.NewRequest(http.MethodPost, "/albums/upload-url", strings.NewReader(`{"content_type":"image/jpeg"}`))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+
+		out := logs.String()
+		if !strings.Contains(out, "Request and response bodies") {
+			t.Fatalf("max %d: nothing logged: %s", maxBytes, out)
+		}
+		for _, secret := range []string{"AKIAEXAMPLE", "0123456789abcdef"} {
+			if strings.Contains(out, secret) {
+				t.Errorf("max %d: log holds %s: %s", maxBytes, secret, out)
+			}
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+// freeDiskBytes is not implemented on this platform, so only the writability
+// half of the storage check runs
+func freeDiskBytes(dir string) (uint64, error) {
+	return 0, errDiskStatUnsupported
+}
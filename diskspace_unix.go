@@ -0,0 +1,18 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// freeDiskBytes returns the bytes available to unprivileged users on the
+// file system holding dir
+func freeDiskBytes(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, fmt.Errorf("failed to stat file system: %v", err)
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorResponse is the body returned for every failed request
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+// Error codes returned in ErrorResponse.Code. Clients should switch on these
+// rather than on the human-readable message.
+const (
+	// ErrCodeBadRequest: the request is malformed (bad path or query parameter, unparseable body)
+	ErrCodeBadRequest = "bad_request"
+	// ErrCodeValidation: the request is well-formed but a field value is not acceptable
+	ErrCodeValidation = "validation_failed"
+	// ErrCodeUnauthorized: credentials are missing, invalid or expired
+	ErrCodeUnauthorized = "unauthorized"
+	// ErrCodeQuotaExceeded: the API key has used up its UPLOAD_QUOTA_BYTES
+	ErrCodeQuotaExceeded = "quota_exceeded"
+	// ErrCodeNotFound: the album or its image does not exist
+	ErrCodeNotFound = "not_found"
+	// ErrCodeNotAcceptable: the Accept header allows neither JSON nor XML
+	ErrCodeNotAcceptable = "not_acceptable"
+	// ErrCodePayloadTooLarge: the upload or JSON body exceeds its configured size limit
+	ErrCodePayloadTooLarge = "payload_too_large"
+	// ErrCodeFetchFailed: the image at source_url could not be downloaded
+	ErrCodeFetchFailed = "fetch_failed"
+	// ErrCodeUnsupportedMedia: the uploaded file is not an accepted image or audio type
+	ErrCodeUnsupportedMedia = "unsupported_media_type"
+	// ErrCodeDuplicate: the uploaded image is already stored; details.albumID names the album
+	ErrCodeDuplicate = "duplicate"
+	// ErrCodeConflict: the request conflicts with the resource's current state;
+	// for a stale PUT, details.version is the current version
+	ErrCodeConflict = "conflict"
+	// ErrCodeIdempotencyKeyReused: the Idempotency-Key was already used for a different request
+	ErrCodeIdempotencyKeyReused = "idempotency_key_reused"
+	// ErrCodePreconditionRequired: the request must be conditional, e.g. PUT without If-Match
+	ErrCodePreconditionRequired = "precondition_required"
+	// ErrCodeNotImplemented: the feature is not available with the current configuration
+	ErrCodeNotImplemented = "not_implemented"
+	// ErrCodeRateLimited: the client exceeded its request rate; see Retry-After
+	ErrCodeRateLimited = "rate_limited"
+	// ErrCodeTimeout: a database query did not finish within DB_QUERY_TIMEOUT
+	// (504), or the whole request within REQUEST_TIMEOUT (503)
+	ErrCodeTimeout = "timeout"
+	// ErrCodeInternal: an unexpected server-side failure
+	ErrCodeInternal = "internal_error"
+)
+
+// respondError aborts the request with an ErrorResponse
+func respondError(c *gin.Context, status int, code, message string) {
+	c.AbortWithStatusJSON(status, ErrorResponse{Code: code, Message: message})
+}
+
+// respondErrorDetails aborts the request with an ErrorResponse carrying details
+func respondErrorDetails(c *gin.Context, status int, code, message string, details any) {
+	c.AbortWithStatusJSON(status, ErrorResponse{Code: code, Message: message, Details: details})
+}
+
+// respondInternalError logs err and aborts with a generic 500 so internals
+// such as SQL errors are not leaked to clients. Query deadlines map to 504,
+// and the request deadline set by requestTimeout to 503.
+func respondInternalError(c *gin.Context, err error) {
+	slog.ErrorContext(c.Request.Context(), "Request failed", "method", c.Request.Method, "path", c.Request.URL.Path, "error", err)
+	_ = c.Error(err)
+
+	if errors.Is(c.Request.Context().Err(), context.DeadlineExceeded) {
+		respondRequestTimeout(c)
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		respondError(c, http.StatusGatewayTimeout, ErrCodeTimeout, "Database query timed out")
+		return
+	}
+	respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Internal server error")
+}
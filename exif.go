@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var errMalformedImage = errors.New("malformed image data")
+
+// stripImageMetadata removes EXIF and similar metadata from JPEG and PNG data
+// without re-encoding the pixels. Other content types are returned unchanged.
+func stripImageMetadata(contentType string, data []byte) ([]byte, error) {
+	switch contentType {
+	case "image/jpeg":
+		return stripJPEGMetadata(data)
+	case "image/png":
+		return stripPNGMetadata(data)
+	default:
+		return data, nil
+	}
+}
+
+// jpegDroppedMarkers are the APPn segments that carry EXIF (APP1, which also
+// holds XMP) and IPTC (APP13). JFIF, ICC profiles and Adobe segments are kept.
+var jpegDroppedMarkers = map[byte]bool{
+	0xE1: true,
+	0xED: true,
+}
+
+// stripJPEGMetadata copies the JPEG segment by segment, dropping metadata
+// segments. Everything from the start of scan onward is copied verbatim.
+func stripJPEGMetadata(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errMalformedImage
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+
+	i := 2
+	for i < len(data) {
+		if data[i] != 0xFF {
+			return nil, errMalformedImage
+		}
+		// Skip fill bytes between segments
+		for i < len(data) && data[i] == 0xFF {
+			i++
+		}
+		if i >= len(data) {
+			return nil, errMalformedImage
+		}
+		marker := data[i]
+		i++
+
+		// Standalone markers carry no length
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			out.Write([]byte{0xFF, marker})
+			continue
+		}
+		if marker == 0xD9 {
+			out.Write([]byte{0xFF, marker})
+			return out.Bytes(), nil
+		}
+
+		if i+2 > len(data) {
+			return nil, errMalformedImage
+		}
+		length := int(binary.BigEndian.Uint16(data[i:]))
+		if length < 2 || i+length > len(data) {
+			return nil, errMalformedImage
+		}
+
+		// Start of scan: the compressed image data follows, keep the rest as is
+		if marker == 0xDA {
+			out.Write([]byte{0xFF, marker})
+			out.Write(data[i:])
+			return out.Bytes(), nil
+		}
+
+		if !jpegDroppedMarkers[marker] {
+			out.Write([]byte{0xFF, marker})
+			out.Write(data[i : i+length])
+		}
+		i += length
+	}
+
+	return out.Bytes(), nil
+}
+
+// pngSignature starts every PNG file
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngDroppedChunks are ancillary chunks that carry EXIF, text or timestamps
+var pngDroppedChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+// stripPNGMetadata copies the PNG chunk by chunk, dropping metadata chunks.
+// Each chunk carries its own CRC, so the remaining chunks stay valid.
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errMalformedImage
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(pngSignature)
+
+	i := len(pngSignature)
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, errMalformedImage
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		chunkType := string(data[i+4 : i+8])
+		end := i + 12 + length
+		if length < 0 || end > len(data) || end < i {
+			return nil, errMalformedImage
+		}
+
+		if !pngDroppedChunks[chunkType] {
+			out.Write(data[i:end])
+		}
+		i = end
+
+		if chunkType == "IEND" {
+			break
+		}
+	}
+
+	return out.Bytes(), nil
+}
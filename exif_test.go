@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// exifSegment returns a JPEG APP1 segment holding a big-endian TIFF IFD0
+// with just an orientation entry
+func exifSegment(orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01")
+	tiff = binary.BigEndian.AppendUint16(tiff, exifOrientationTag)
+	tiff = append(tiff, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+	return jpegSegment(0xE1, append([]byte("Exif\x00\x00"), tiff...))
+}
+
+// jpegSegment renders a marker segment with its length
+func jpegSegment(marker byte, payload []byte) []byte {
+	seg := []byte{0xFF, marker}
+	seg = binary.BigEndian.AppendUint16(seg, uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// testJPEG encodes a w×h JPEG and inserts segments right after SOI
+func testJPEG(t *testing.T, w, h int, segments ...[]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	out := append([]byte{}, data[:2]...)
+	for _, seg := range segments {
+		out = append(out, seg...)
+	}
+	return append(out, data[2:]...)
+}
+
+// pngChunk renders a PNG chunk with its CRC
+func pngChunk(chunkType string, payload []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, payload...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// testPNG encodes a w×h PNG and inserts chunks right after IHDR
+func testPNG(t *testing.T, w, h int, chunks ...[]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	ihdrEnd := len(pngSignature) + 12 + 13
+	out := append([]byte{}, data[:ihdrEnd]...)
+	for _, chunk := range chunks {
+		out = append(out, chunk...)
+	}
+	return append(out, data[ihdrEnd:]...)
+}
+
+func TestStripImageMetadata(t *testing.T) {
+	iptc := jpegSegment(0xED, []byte("Photoshop 3.0\x008BIM secret caption"))
+	icc := jpegSegment(0xE2, []byte("ICC_PROFILE\x00profile"))
+
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+		dropped     [][]byte
+		kept        [][]byte
+	}{
+		{
+			name:        "jpeg",
+			contentType: "image/jpeg",
+			data:        testJPEG(t, 4, 3, exifSegment(6), iptc, icc),
+			dropped:     [][]byte{[]byte("Exif\x00\x00"), []byte("secret caption")},
+			kept:        [][]byte{icc},
+		},
+		{
+			name:        "png",
+			contentType: "image/png",
+			data: testPNG(t, 4, 3, pngChunk("tEXt", []byte("Author\x00secret author")),
+				pngChunk("eXIf", []byte("MM\x00\x2a")), pngChunk("tIME", make([]byte, 7)), pngChunk("gAMA", []byte{0, 0, 0xB1, 0x8F})),
+			dropped: [][]byte{[]byte("secret author"), []byte("eXIf"), []byte("tIME")},
+			kept:    [][]byte{[]byte("gAMA")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := stripImageMetadata(tt.contentType, tt.data)
+			if err != nil {
+				t.Fatalf("stripImageMetadata: %v", err)
+			}
+			for _, b := range tt.dropped {
+				if !bytes.Contains(tt.data, b) {
+					t.Fatalf("input lacks %q", b)
+				}
+				if bytes.Contains(out, b) {
+					t.Errorf("output still contains %q", b)
+				}
+			}
+			for _, b := range tt.kept {
+				if !bytes.Contains(out, b) {
+					t.Errorf("output lost %q", b)
+				}
+			}
+			cfg, _, err := image.DecodeConfig(bytes.NewReader(out))
+			if err != nil || cfg.Width != 4 || cfg.Height != 3 {
+				t.Errorf("stripped image = %dx%d, %v; want a 4x3 image", cfg.Width, cfg.Height, err)
+			}
+			if _, _, err := image.Decode(bytes.NewReader(out)); err != nil {
+				t.Errorf("stripped image does not decode: %v", err)
+			}
+		})
+	}
+}
+
+func TestStripImageMetadataMalformed(t *testing.T) {
+	jpg := testJPEG(t, 2, 2)
+	pngData := testPNG(t, 2, 2)
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+	}{
+		{"jpeg without SOI", "image/jpeg", jpg[2:]},
+		{"jpeg cut in a segment header", "image/jpeg", jpg[:5]},
+		{"jpeg segment past the end", "image/jpeg", append(jpg[:2:2], 0xFF, 0xE1, 0xFF, 0xFF, 0x00)},
+		{"png without signature", "image/png", pngData[8:]},
+		{"png chunk past the end", "image/png", pngData[:len(pngSignature)+10]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := stripImageMetadata(tt.contentType, tt.data); !errors.Is(err, errMalformedImage) {
+				t.Errorf("err = %v, want errMalformedImage", err)
+			}
+		})
+	}
+
+	webp := []byte("RIFF\x00\x00\x00\x00WEBPVP8 ")
+	if out, err := stripImageMetadata("image/webp", webp); err != nil || !bytes.Equal(out, webp) {
+		t.Errorf("webp = %q, %v; want it unchanged", out, err)
+	}
+}
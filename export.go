@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportBatchRows is how many rows are written between flushes to the client
+const exportBatchRows = 100
+
+// exportCSVHeader names the columns of a CSV export
+var exportCSVHeader = []string{"id", "artist", "title", "year", "image_url", "created_at"}
+
+// csvFormulaPrefixes start a cell that spreadsheets evaluate as a formula
+const csvFormulaPrefixes = "=+-@\t\r"
+
+// csvSafe defuses a user-supplied CSV field that a spreadsheet would run as
+// a formula, such as =HYPERLINK(...), by prefixing it with a quote
+func csvSafe(field string) string {
+	if field != "" && strings.IndexByte(csvFormulaPrefixes, field[0]) >= 0 {
+		return "'" + field
+	}
+	return field
+}
+
+// GET /albums/export -> streams every album matching the list filters as CSV
+// (?format=csv, the default) or as a JSON array (?format=json). Rows are
+// written as they are read so the catalog never has to fit in memory.
+func (s *Server) exportAlbums(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "json" {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "format must be csv or json")
+		return
+	}
+
+	filter, err := parseAlbumFilter(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		return
+	}
+
+	// A large export outlives the usual query and write timeouts, so
+	// EXPORT_TIMEOUT bounds both the query and the writes instead. It ends
+	// early when the client disconnects.
+	ctx := c.Request.Context()
+	var deadline time.Time
+	if s.exportTimeout > 0 {
+		deadline = time.Now().Add(s.exportTimeout)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(deadline); err != nil {
+		slog.WarnContext(ctx, "Export keeps the server write timeout", "error", err)
+	}
+	rows, err := s.db.QueryContext(ctx, "SELECT "+albumColumns+" FROM albums"+filter.where()+" ORDER BY id", filter.args...)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	defer rows.Close()
+
+	c.Header("Content-Disposition", `attachment; filename="albums.`+format+`"`)
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		err = s.streamAlbumsCSV(c, rows)
+	} else {
+		c.Header("Content-Type", "application/json; charset=utf-8")
+		err = s.streamAlbumsJSON(ctx, c, rows)
+	}
+
+	// The status line is already sent, so a failure can only cut the body short
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Export failed", "format", format, "error", err)
+		_ = c.Error(err)
+	}
+}
+
+// streamAlbumsCSV writes a header row and one row per album
+func (s *Server) streamAlbumsCSV(c *gin.Context, rows *sql.Rows) error {
+	w := csv.NewWriter(c.Writer)
+	if err := w.Write(exportCSVHeader); err != nil {
+		return err
+	}
+
+	var err error
+	c.Stream(func(io.Writer) bool {
+		for i := 0; i < exportBatchRows; i++ {
+			if !rows.Next() {
+				err = rows.Err()
+				return false
+			}
+			var album AlbumInfo
+			if album, err = scanAlbum(rows); err != nil {
+				return false
+			}
+			s.presentAlbum(&album)
+			err = w.Write([]string{
+				strconv.Itoa(album.AlbumID),
+				csvSafe(album.Metadata.Artist),
+				csvSafe(album.Metadata.Title),
+				csvSafe(album.Metadata.Year),
+				csvSafe(album.ImageURL),
+				album.CreatedAt.UTC().Format(time.RFC3339),
+			})
+			if err != nil {
+				return false
+			}
+		}
+		w.Flush()
+		err = w.Error()
+		return err == nil
+	})
+
+	w.Flush()
+	if err != nil {
+		return err
+	}
+	return w.Error()
+}
+
+// streamAlbumsJSON writes a JSON array with one AlbumInfo per album, shaped
+// like GET /albums/{albumID}. The images of each batch of rows are loaded
+// with one query.
+func (s *Server) streamAlbumsJSON(ctx context.Context, c *gin.Context, rows *sql.Rows) error {
+	c.Status(200)
+	if _, err := io.WriteString(c.Writer, "["); err != nil {
+		return err
+	}
+
+	first := true
+	var err error
+	c.Stream(func(w io.Writer) bool {
+		batch := make([]AlbumInfo, 0, exportBatchRows)
+		for len(batch) < exportBatchRows && rows.Next() {
+			var album AlbumInfo
+			if album, err = scanAlbum(rows); err != nil {
+				return false
+			}
+			batch = append(batch, album)
+		}
+		if err = rows.Err(); err != nil {
+			return false
+		}
+		if err = s.attachImages(ctx, batch); err != nil {
+			return false
+		}
+
+		for i := range batch {
+			s.presentAlbum(&batch[i])
+			var data []byte
+			if data, err = json.Marshal(batch[i]); err != nil {
+				return false
+			}
+			if !first {
+				data = append([]byte(","), data...)
+			}
+			first = false
+			if _, err = w.Write(data); err != nil {
+				return false
+			}
+		}
+		return len(batch) == exportBatchRows
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(c.Writer, "]")
+	return err
+}
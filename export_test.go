@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// streamRecorder is a ResponseRecorder that gin's Context.Stream accepts
+type streamRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (streamRecorder) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+// exportRequest sends a GET through the router and returns the streamed response
+func exportRequest(ts *testServer, target string) *httptest.ResponseRecorder {
+	w := streamRecorder{httptest.NewRecorder()}
+	ts.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w.ResponseRecorder
+}
+
+func TestExportTimeoutBoundsQuery(t *testing.T) {
+	ts := newTestServer(t, func(cfg *Config) { cfg.ExportTimeout = 20 * time.Millisecond })
+	// The query outlasts EXPORT_TIMEOUT, so the export gives up on it
+	ts.mock.ExpectQuery(regexp.QuoteMeta("SELECT " + albumColumns + " FROM albums")).
+		WillDelayFor(time.Second).WillReturnRows(albumRows())
+
+	start := time.Now()
+	w := ts.do(http.MethodGet, "/albums/export", "", nil)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500, body %s", w.Code, w.Body)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("export took %v, want it cut off after EXPORT_TIMEOUT", elapsed)
+	}
+}
+
+func TestCSVSafe(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"Moon Safari", "Moon Safari"},
+		{"", ""},
+		{"=HYPERLINK(\"https://evil.example\")", "'=HYPERLINK(\"https://evil.example\")"},
+		{"+1+1", "'+1+1"},
+		{"-2+3", "'-2+3"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\t=1", "'\t=1"},
+		{"\r=1", "'\r=1"},
+		{"a=1", "a=1"},
+	}
+	for _, tt := range tests {
+		if got := csvSafe(tt.in); got != tt.want {
+			t.Errorf("csvSafe(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestExportCSVEscapesFormulas(t *testing.T) {
+	ts := newTestServer(t, nil)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	ts.mock.ExpectQuery(regexp.QuoteMeta("SELECT " + albumColumns + " FROM albums")).
+		WillReturnRows(albumRows().AddRow(1, nil, "https://cdn.example.com/a.jpg", nil, nil, nil, nil, nil, nil,
+			`{"artist":"=cmd|' /C calc'!A0","title":"@SUM(1+1)","year":"2004"}`, nil, nil, nil, 1, now, now, nil))
+
+	w := exportRequest(ts, "/albums/export?format=csv")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		exportCSVHeader,
+		{"1", "'=cmd|' /C calc'!A0", "'@SUM(1+1)", "2004", "https://cdn.example.com/a.jpg", "2024-05-01T12:00:00Z"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records = %q, want %q", records, want)
+	}
+}
+
+func TestExportJSONIncludesImages(t *testing.T) {
+	ts := newTestServer(t, nil)
+	now := time.Now()
+	ts.mock.ExpectQuery(regexp.QuoteMeta("SELECT " + albumColumns + " FROM albums")).
+		WillReturnRows(albumRows().
+			AddRow(1, nil, "https://cdn.example.com/a.jpg", nil, nil, nil, nil, nil, nil, `{"artist":"Air","title":"Moon Safari"}`, nil, nil, nil, 1, now, now, nil).
+			AddRow(2, nil, "", nil, nil, nil, nil, nil, nil, `{"artist":"Air","title":"Talkie Walkie"}`, nil, nil, nil, 1, now, now, nil))
+	ts.mock.ExpectQuery(regexp.QuoteMeta("FROM album_images WHERE album_id IN (?, ?)")).
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"album_id", "id", "image_url", "position", "width", "height", "size_bytes", "created_at"}).
+			AddRow(1, 10, "https://cdn.example.com/a.jpg", 0, nil, nil, nil, now))
+
+	w := exportRequest(ts, "/albums/export?format=json")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	// An album without images exports "images": [] like GET /albums/{albumID}, never null
+	var albums []map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &albums); err != nil {
+		t.Fatalf("decode %s: %v", w.Body, err)
+	}
+	if len(albums) != 2 {
+		t.Fatalf("exported %d albums, want 2", len(albums))
+	}
+	var images []AlbumImage
+	if err := json.Unmarshal(albums[0]["images"], &images); err != nil || len(images) != 1 || images[0].ImageID != 10 {
+		t.Errorf("album 1 images = %s, want image 10", albums[0]["images"])
+	}
+	if got := string(albums[1]["images"]); got != "[]" {
+		t.Errorf("album 2 images = %s, want []", got)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// validateExternalID trims an optional external_id and checks it fits the
+// albums.external_id column
+func validateExternalID(id *string) []FieldError {
+	*id = strings.TrimSpace(*id)
+	if utf8.RuneCountInString(*id) > maxFieldLength {
+		return []FieldError{{Field: "external_id", Message: fmt.Sprintf("external_id must be at most %d characters", maxFieldLength)}}
+	}
+	return nil
+}
+
+// existingExternalAlbum answers a create whose external_id is already taken
+// with that album and 200, so importers can re-run safely. An album deleted
+// since still holds the ID and gets a 409 pointing at it. It reports false,
+// without responding, when no album has the ID.
+func (s *Server) existingExternalAlbum(c *gin.Context, externalID string) bool {
+	if externalID == "" {
+		return false
+	}
+
+	ctx, cancel := s.queryContext(c.Request.Context())
+	var id int
+	err := s.db.QueryRowContext(ctx, "SELECT id FROM albums WHERE external_id = ?", externalID).Scan(&id)
+	cancel()
+	if err == sql.ErrNoRows {
+		return false
+	}
+	if err != nil {
+		respondInternalError(c, err)
+		return true
+	}
+
+	album, err := s.fetchAlbum(c.Request.Context(), id, true)
+	if err != nil {
+		respondInternalError(c, err)
+		return true
+	}
+	if album.DeletedAt != nil {
+		respondErrorDetails(c, http.StatusConflict, ErrCodeConflict, "The album with this external_id is deleted; restore it instead", gin.H{"albumID": id})
+		return true
+	}
+
+	s.presentAlbum(&album)
+	c.Header("Location", "/albums/"+strconv.Itoa(id))
+	c.JSON(http.StatusOK, album)
+	return true
+}
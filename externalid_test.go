@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestCreateWithExternalID(t *testing.T) {
+	const body = `{"external_id":" discogs-42 ","image_url":"https://cdn.example.com/a.jpg","artist":"Air","title":"Moon Safari"}`
+	lookup := regexp.QuoteMeta("SELECT id FROM albums WHERE external_id = ?")
+	imageColumns := []string{"album_id", "id", "image_url", "position", "width", "height", "size_bytes", "created_at"}
+
+	// expectAlbum expects album id to be read back, deleted or not
+	expectAlbum := func(m sqlmock.Sqlmock, id int, deleted bool) {
+		now := time.Now()
+		var deletedAt any
+		if deleted {
+			deletedAt = now
+		}
+		m.ExpectQuery(regexp.QuoteMeta("SELECT " + albumColumns + " FROM albums WHERE id = ?")).
+			WithArgs(id).
+			WillReturnRows(albumRows().AddRow(id, "discogs-42", "https://cdn.example.com/a.jpg", nil, nil, nil, nil, nil, nil,
+				`{"artist":"Air","title":"Moon Safari","year":""}`, nil, nil, nil, 1, now, now, deletedAt))
+		m.ExpectQuery(regexp.QuoteMeta("FROM album_images WHERE album_id IN (?)")).
+			WithArgs(id).WillReturnRows(sqlmock.NewRows(imageColumns))
+	}
+	// expectInsert expects the album to be inserted under the trimmed ID
+	expectInsert := func(m sqlmock.Sqlmock, err error) {
+		m.ExpectBegin()
+		insert := m.ExpectExec(regexp.QuoteMeta("INSERT INTO albums (")).
+			WithArgs("https://cdn.example.com/a.jpg", nil, nil, nil, nil, nil, nil, nil, "discogs-42",
+				sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg())
+		if err != nil {
+			insert.WillReturnError(err)
+			m.ExpectRollback()
+			return
+		}
+		insert.WillReturnResult(sqlmock.NewResult(8, 1))
+		m.ExpectExec(regexp.QuoteMeta("INSERT INTO album_images")).WillReturnResult(sqlmock.NewResult(1, 1))
+		m.ExpectCommit()
+	}
+
+	tests := []struct {
+		name         string
+		body         string
+		expect       func(m sqlmock.Sqlmock)
+		wantStatus   int
+		wantLocation string
+	}{
+		{
+			name: "new ID creates",
+			body: body,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(lookup).WithArgs("discogs-42").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				expectInsert(m, nil)
+				expectAlbum(m, 8, false)
+			},
+			wantStatus:   http.StatusCreated,
+			wantLocation: "/albums/8",
+		},
+		{
+			name: "known ID returns the album",
+			body: body,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(lookup).WithArgs("discogs-42").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+				expectAlbum(m, 5, false)
+			},
+			wantStatus:   http.StatusOK,
+			wantLocation: "/albums/5",
+		},
+		{
+			name: "deleted album holds the ID",
+			body: body,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(lookup).WithArgs("discogs-42").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+				expectAlbum(m, 5, true)
+			},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name: "concurrent import wins the insert",
+			body: body,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(lookup).WithArgs("discogs-42").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				expectInsert(m, &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"})
+				m.ExpectQuery(lookup).WithArgs("discogs-42").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(6))
+				expectAlbum(m, 6, false)
+			},
+			wantStatus:   http.StatusOK,
+			wantLocation: "/albums/6",
+		},
+		{
+			name:       "ID too long",
+			body:       `{"external_id":"` + strings.Repeat("x", maxFieldLength+1) + `","image_url":"https://cdn.example.com/a.jpg","artist":"Air","title":"T"}`,
+			expect:     func(m sqlmock.Sqlmock) {},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, nil)
+			tt.expect(ts.mock)
+
+			w := ts.do(http.MethodPost, "/albums", tt.body, nil)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if got := w.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+			if tt.wantStatus == http.StatusConflict && !strings.Contains(w.Body.String(), `"albumID":5`) {
+				t.Errorf("conflict body = %s, want the deleted album's ID", w.Body)
+			}
+		})
+	}
+}
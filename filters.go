@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// albumFilter is a parameterized WHERE clause built from list query params
+type albumFilter struct {
+	conditions []string
+	args       []any
+}
+
+// where renders the filter as a SQL WHERE clause, empty when unfiltered
+func (f albumFilter) where() string {
+	if len(f.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(f.conditions, " AND ")
+}
+
+// add appends a condition with its placeholder arguments
+func (f *albumFilter) add(condition string, args ...any) {
+	f.conditions = append(f.conditions, condition)
+	f.args = append(f.args, args...)
+}
+
+// likeEscaper escapes LIKE wildcards so user input matches literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// searchColumns are the indexed lowercase copies of artist and title that
+// migration 0013 generates from the metadata, whichever METADATA_STORAGE
+// wrote it
+var searchColumns = map[string]string{
+	"artist": "artist_search",
+	"title":  "title_search",
+}
+
+// parseAlbumFilter reads the list search parameters. ?artist= and ?title=
+// match case-insensitively anywhere in the stored value; ?yearFrom= and
+// ?yearTo= bound the year inclusively; each ?tag= must be one of the album's
+// tags. All filters are combined with AND. Soft-deleted albums are excluded
+// unless ?includeDeleted=true.
+func parseAlbumFilter(c *gin.Context) (albumFilter, error) {
+	var f albumFilter
+	if c.Query("includeDeleted") != "true" {
+		f.add("deleted_at IS NULL")
+	}
+
+	for _, field := range []string{"artist", "title"} {
+		if v := strings.TrimSpace(c.Query(field)); v != "" {
+			f.add(searchColumns[field]+" LIKE ?", "%"+likeEscaper.Replace(strings.ToLower(v))+"%")
+		}
+	}
+
+	for _, tag := range c.QueryArray("tag") {
+		if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" {
+			f.add("JSON_CONTAINS(JSON_EXTRACT(metadata, '$.tags'), JSON_QUOTE(?))", tag)
+		}
+	}
+
+	yearFrom, err := parseYearParam(c, "yearFrom")
+	if err != nil {
+		return f, err
+	}
+	yearTo, err := parseYearParam(c, "yearTo")
+	if err != nil {
+		return f, err
+	}
+	if yearFrom > 0 && yearTo > 0 && yearFrom > yearTo {
+		return f, fmt.Errorf("yearFrom must not be after yearTo")
+	}
+	// year_num is the generated numeric year, NULL for blank years
+	if yearFrom > 0 {
+		f.add("year_num >= ?", yearFrom)
+	}
+	if yearTo > 0 {
+		f.add("year_num <= ?", yearTo)
+	}
+
+	return f, nil
+}
+
+// parseYearParam reads an optional year query parameter, returning 0 when unset
+func parseYearParam(c *gin.Context, name string) (int, error) {
+	v := c.Query(name)
+	if v == "" {
+		return 0, nil
+	}
+
+	if err := validateYear(v); err != nil {
+		return 0, fmt.Errorf("%s: %v", name, err)
+	}
+
+	n, _ := strconv.Atoi(v)
+	return n, nil
+}
+
+// albumSortKeys maps each ?sort= key to the SQL expression it orders by. Only
+// these fixed expressions ever reach the query.
+var albumSortKeys = map[string]string{
+	"created_at": "created_at",
+	"year":       "year_num",
+	"artist":     "artist_search",
+	"title":      "title_search",
+}
+
+// albumSort is a validated ?sort= choice
+type albumSort struct {
+	key string // a key of albumSortKeys
+	dir string // ASC or DESC
+}
+
+// orderBy renders the ORDER BY clause; id breaks ties so pages stay stable
+func (o albumSort) orderBy() string {
+	return " ORDER BY " + albumSortKeys[o.key] + " " + o.dir + ", id " + o.dir
+}
+
+// parseAlbumSort reads ?sort=key[:asc|:desc], defaulting to newest first
+func parseAlbumSort(c *gin.Context) (albumSort, error) {
+	v := c.DefaultQuery("sort", "created_at:desc")
+	key, dir, hasDir := strings.Cut(v, ":")
+
+	if _, ok := albumSortKeys[key]; !ok {
+		return albumSort{}, fmt.Errorf("sort must be one of created_at, year, artist or title")
+	}
+
+	switch {
+	case !hasDir, dir == "asc":
+		dir = "ASC"
+	case dir == "desc":
+		dir = "DESC"
+	default:
+		return albumSort{}, fmt.Errorf("sort direction must be asc or desc")
+	}
+
+	return albumSort{key: key, dir: dir}, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+// listContext returns a gin context for a GET of target
+func listContext(target string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+	return c
+}
+
+func TestParseAlbumFilter(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		wantWhere string
+		wantArgs  []any
+		wantErr   string
+	}{
+		{"default excludes deleted", "", " WHERE deleted_at IS NULL", nil, ""},
+		{"include deleted", "?includeDeleted=true", "", nil, ""},
+		{"blank search ignored", "?artist=%20%20", " WHERE deleted_at IS NULL", nil, ""},
+		{"artist search lowercased", "?artist=%20Björk%20", " WHERE deleted_at IS NULL AND artist_search LIKE ?", []any{"%björk%"}, ""},
+		{"wildcards match literally", "?title=100%25_done", " WHERE deleted_at IS NULL AND title_search LIKE ?", []any{`%100\%\_done%`}, ""},
+		{"artist and title", "?artist=air&title=moon", " WHERE deleted_at IS NULL AND artist_search LIKE ? AND title_search LIKE ?", []any{"%air%", "%moon%"}, ""},
+		{"year range", "?yearFrom=1990&yearTo=1999", " WHERE deleted_at IS NULL AND year_num >= ? AND year_num <= ?", []any{1990, 1999}, ""},
+		{"single year bound", "?yearTo=2000", " WHERE deleted_at IS NULL AND year_num <= ?", []any{2000}, ""},
+		{"same year both ends", "?yearFrom=1994&yearTo=1994", " WHERE deleted_at IS NULL AND year_num >= ? AND year_num <= ?", []any{1994, 1994}, ""},
+		{"tags", "?tag=Rock&tag=%20&tag=jazz", " WHERE deleted_at IS NULL AND JSON_CONTAINS(JSON_EXTRACT(metadata, '$.tags'), JSON_QUOTE(?)) AND JSON_CONTAINS(JSON_EXTRACT(metadata, '$.tags'), JSON_QUOTE(?))", []any{"rock", "jazz"}, ""},
+		{"year not a number", "?yearFrom=abcd", "", nil, "yearFrom: "},
+		{"year too short", "?yearTo=99", "", nil, "yearTo: "},
+		{"inverted range", "?yearFrom=2000&yearTo=1990", "", nil, "yearFrom must not be after yearTo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := parseAlbumFilter(listContext("/albums" + tt.query))
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want prefix %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseAlbumFilter: %v", err)
+			}
+			if got := f.where(); got != tt.wantWhere {
+				t.Errorf("where = %q, want %q", got, tt.wantWhere)
+			}
+			if !reflect.DeepEqual(f.args, tt.wantArgs) {
+				t.Errorf("args = %#v, want %#v", f.args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestParseAlbumSort(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    string
+		wantErr bool
+	}{
+		{"?sort=created_at:desc", " ORDER BY created_at DESC, id DESC", false},
+		{"", " ORDER BY created_at DESC, id DESC", false},
+		{"?sort=year", " ORDER BY year_num ASC, id ASC", false},
+		{"?sort=artist:desc", " ORDER BY artist_search DESC, id DESC", false},
+		{"?sort=title:asc", " ORDER BY title_search ASC, id ASC", false},
+		{"?sort=id", "", true},
+		{"?sort=year_num%20DESC,%20(SELECT%201)", "", true},
+		{"?sort=year:up", "", true},
+		{"?sort=created_at:DESC", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			o, err := parseAlbumSort(listContext("/albums" + tt.query))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseAlbumSort = %+v, want an error", o)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseAlbumSort: %v", err)
+			}
+			if got := o.orderBy(); got != tt.want {
+				t.Errorf("orderBy = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestListAlbumsRejectsInvalidQuery checks that bad list parameters answer
+// 400 before any rows are read. Cursors are checked after the count.
+func TestListAlbumsRejectsInvalidQuery(t *testing.T) {
+	validCursor := encodeCursor(AlbumInfo{AlbumID: 5, CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}, "DESC")
+
+	tests := []struct {
+		name     string
+		query    string
+		counted  bool
+		wantBody string
+	}{
+		{"negative limit", "?limit=-1", false, "limit must be a non-negative integer"},
+		{"non-numeric offset", "?offset=x", false, "offset must be a non-negative integer"},
+		{"bad year", "?yearFrom=19", false, "yearFrom: "},
+		{"inverted years", "?yearFrom=2001&yearTo=2000", false, "yearFrom must not be after yearTo"},
+		{"unknown sort key", "?sort=size", false, "sort must be one of created_at, year, artist or title"},
+		{"bad sort direction", "?sort=year:sideways", false, "sort direction must be asc or desc"},
+		{"garbage cursor", "?cursor=not-a-cursor", true, "cursor is invalid"},
+		{"cursor with offset", "?offset=10&cursor=" + validCursor, true, "cursor and offset cannot be combined"},
+		{"cursor for another sort", "?sort=year&cursor=" + validCursor, true, "cursor was issued for a different sort"},
+		{"cursor for another direction", "?sort=created_at:asc&cursor=" + validCursor, true, "cursor was issued for a different sort"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, nil)
+			if tt.counted {
+				ts.mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM albums")).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(12))
+			}
+
+			w := ts.do(http.MethodGet, "/albums"+tt.query, "", nil)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400, body %s", w.Code, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body = %s, want %q", w.Body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestListAlbumsQuery(t *testing.T) {
+	cursorTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	cursor := encodeCursor(AlbumInfo{AlbumID: 5, CreatedAt: cursorTime}, "DESC")
+
+	tests := []struct {
+		name      string
+		query     string
+		wantSQL   string
+		countArgs []any
+		listArgs  []any
+	}{
+		{
+			"defaults", "",
+			" WHERE deleted_at IS NULL ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?",
+			nil, []any{defaultPageLimit, 0},
+		},
+		{
+			"filtered and sorted page", "?artist=Portishead&yearFrom=1994&sort=year:asc&limit=500&offset=20",
+			" WHERE deleted_at IS NULL AND artist_search LIKE ? AND year_num >= ? ORDER BY year_num ASC, id ASC LIMIT ? OFFSET ?",
+			[]any{"%portishead%", 1994}, []any{"%portishead%", 1994, maxPageLimit, 20},
+		},
+		{
+			"cursor", "?cursor=" + cursor,
+			" WHERE deleted_at IS NULL AND (created_at < ? OR (created_at = ? AND id < ?)) ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?",
+			nil, []any{cursorTime, cursorTime, 5, defaultPageLimit, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, nil)
+			count := ts.mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM albums WHERE deleted_at IS NULL"))
+			if len(tt.countArgs) > 0 {
+				count.WithArgs(toDriverArgs(tt.countArgs)...)
+			}
+			count.WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+			ts.mock.ExpectQuery("^" + regexp.QuoteMeta("SELECT "+albumColumns+" FROM albums"+tt.wantSQL) + "$").
+				WithArgs(toDriverArgs(tt.listArgs)...).WillReturnRows(albumRows())
+
+			w := ts.do(http.MethodGet, "/albums"+tt.query, "", nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", w.Code, w.Body)
+			}
+		})
+	}
+}
+
+// toDriverArgs lets a []any of expected values be passed to WithArgs
+func toDriverArgs(args []any) []driver.Value {
+	out := make([]driver.Value, len(args))
+	for i, a := range args {
+		out[i] = a
+	}
+	return out
+}
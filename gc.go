@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// orphanMinAge keeps garbage collection away from files an in-flight upload
+// has written but not yet recorded in the DB
+const orphanMinAge = time.Hour
+
+// OrphanReport lists the stored files that no album or image references
+type OrphanReport struct {
+	// Applied is false for a dry run, where nothing was deleted
+	Applied    bool         `json:"applied"`
+	Files      []OrphanFile `json:"files"`
+	TotalBytes int64        `json:"total_bytes"`
+}
+
+// OrphanFile is a single unreferenced file; Error is set when deleting it failed
+type OrphanFile struct {
+	Path      string `json:"path"`
+	SizeBytes int64  `json:"size_bytes"`
+	Error     string `json:"error,omitempty"`
+}
+
+// DELETE /admin/orphaned-files -> reports files in the storage directory that
+// nothing in the DB references. It is a dry run unless ?apply=true, which
+// deletes them.
+func (s *Server) deleteOrphanedFiles(c *gin.Context) {
+	local, ok := s.storage.(*LocalStorage)
+	if !ok {
+		respondError(c, http.StatusNotImplemented, ErrCodeNotImplemented, "Orphaned file collection requires STORAGE_BACKEND=local")
+		return
+	}
+	apply := c.Query("apply") == "true"
+
+	// List the files before reading the references so a file saved and
+	// recorded in between is never reported
+	files, err := listStoredFiles(local.Dir)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	referenced, err := s.referencedFiles(c.Request.Context())
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	report := OrphanReport{Applied: apply, Files: []OrphanFile{}}
+	cutoff := time.Now().Add(-orphanMinAge)
+	for _, f := range files {
+		// A WebP variant belongs to the original it was transcoded from
+		if referenced[f.path] || referenced[strings.TrimSuffix(f.path, webpVariantExt)] {
+			continue
+		}
+		if f.info.ModTime().After(cutoff) {
+			continue
+		}
+
+		file := OrphanFile{Path: f.path, SizeBytes: f.info.Size()}
+		if apply {
+			if err := local.Delete(c.Request.Context(), f.path); err != nil {
+				file.Error = err.Error()
+			}
+		}
+		report.Files = append(report.Files, file)
+		report.TotalBytes += f.info.Size()
+	}
+
+	if apply {
+		slog.InfoContext(c.Request.Context(), "Removed orphaned files", "count", len(report.Files), "bytes", report.TotalBytes)
+	}
+	c.JSON(200, report)
+}
+
+// storedFile is a regular file found under the storage directory
+type storedFile struct {
+	path string
+	info fs.FileInfo
+}
+
+// listStoredFiles walks dir, including shard subdirectories, and returns its
+// regular files. A missing dir has no files.
+func listStoredFiles(dir string) ([]storedFile, error) {
+	var files []storedFile
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir && errors.Is(err, fs.ErrNotExist) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			// Removed since the directory was read
+			return nil
+		}
+		files = append(files, storedFile{path: path, info: info})
+		return nil
+	})
+	return files, err
+}
+
+// referencedFiles returns every image, thumbnail and audio URL recorded in the DB,
+// including those of soft-deleted albums, which can still be restored
+func (s *Server) referencedFiles(ctx context.Context) (map[string]bool, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT image_url FROM albums WHERE image_url IS NOT NULL
+		UNION SELECT thumbnail_url FROM albums WHERE thumbnail_url IS NOT NULL
+		UNION SELECT audio_url FROM albums WHERE audio_url IS NOT NULL
+		UNION SELECT image_url FROM album_images`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	referenced := make(map[string]bool)
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			return nil, err
+		}
+		referenced[filepath.Clean(url)] = true
+	}
+	return referenced, rows.Err()
+}
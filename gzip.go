@@ -0,0 +1,131 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gzipWriter compresses JSON and XML bodies once they reach minSize bytes. Smaller
+// bodies and other content types (such as images, which are already
+// compressed) are written through untouched.
+type gzipWriter struct {
+	gin.ResponseWriter
+	minSize int
+	buf     []byte
+	gz      *gzip.Writer
+	decided bool
+}
+
+func (w *gzipWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		if !compressible(w.Header()) {
+			w.decided = true
+			return w.ResponseWriter.Write(p)
+		}
+
+		w.buf = append(w.buf, p...)
+		if len(w.buf) < w.minSize {
+			return len(p), nil
+		}
+
+		w.startGzip()
+		_, err := w.gz.Write(w.buf)
+		w.buf = nil
+		return len(p), err
+	}
+
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the connection, e.g. to lift
+// the write deadline
+func (w *gzipWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush forces a decision on buffered data so streamed responses keep flowing
+func (w *gzipWriter) Flush() {
+	if !w.decided {
+		w.writeBuffered()
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *gzipWriter) startGzip() {
+	h := w.Header()
+	h.Set("Content-Encoding", "gzip")
+	h.Add("Vary", "Accept-Encoding")
+	h.Del("Content-Length")
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+	w.decided = true
+}
+
+// writeBuffered sends a body that stayed under the threshold uncompressed
+func (w *gzipWriter) writeBuffered() {
+	w.decided = true
+	if len(w.buf) > 0 {
+		w.ResponseWriter.Write(w.buf)
+		w.buf = nil
+	}
+}
+
+// finish flushes whatever the handler left in the buffer or compressor
+func (w *gzipWriter) finish() {
+	if !w.decided {
+		w.writeBuffered()
+	}
+	if w.gz != nil {
+		w.gz.Close()
+	}
+}
+
+// compressible reports whether a response with these headers should be gzipped
+func compressible(h http.Header) bool {
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	ct := h.Get("Content-Type")
+	return strings.HasPrefix(ct, "application/json") || strings.HasPrefix(ct, "application/xml")
+}
+
+// gzipMiddleware compresses JSON and XML responses of at least minSize bytes for
+// clients that send Accept-Encoding: gzip
+func gzipMiddleware(minSize int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodHead || !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+			c.Next()
+			return
+		}
+
+		w := &gzipWriter{ResponseWriter: c.Writer, minSize: minSize}
+		c.Writer = w
+		defer w.finish()
+
+		c.Next()
+	}
+}
+
+// acceptsGzip checks an Accept-Encoding header for gzip, honoring q=0
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.TrimSpace(coding) != "gzip" {
+			continue
+		}
+		return strings.ReplaceAll(strings.TrimSpace(params), " ", "") != "q=0"
+	}
+	return false
+}
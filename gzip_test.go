@@ -0,0 +1,90 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"gzip", true},
+		{"deflate, gzip;q=0.8", true},
+		{"br, gzip ; q=1", true},
+		{"", false},
+		{"identity", false},
+		{"gzip;q=0", false},
+		{"gzip; q = 0", false},
+		{"x-gzip", false},
+	}
+	for _, tt := range tests {
+		if got := acceptsGzip(tt.header); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestGzipMiddleware(t *testing.T) {
+	big := strings.Repeat("x", 2048)
+	r := gin.New()
+	r.Use(gzipMiddleware(1024))
+	r.GET("/big", func(c *gin.Context) { c.JSON(200, gin.H{"v": big}) })
+	r.GET("/small", func(c *gin.Context) { c.JSON(200, gin.H{"v": "tiny"}) })
+	r.GET("/xml", func(c *gin.Context) { c.XML(200, gin.H{"v": big}) })
+	r.GET("/image", func(c *gin.Context) { c.Data(200, "image/png", []byte(big)) })
+
+	tests := []struct {
+		name           string
+		method, path   string
+		acceptEncoding string
+		wantGzip       bool
+	}{
+		{"large JSON", http.MethodGet, "/big", "gzip", true},
+		{"large XML", http.MethodGet, "/xml", "gzip, deflate", true},
+		{"below threshold", http.MethodGet, "/small", "gzip", false},
+		{"image", http.MethodGet, "/image", "gzip", false},
+		{"client without gzip", http.MethodGet, "/big", "", false},
+		{"gzip refused", http.MethodGet, "/big", "gzip;q=0", false},
+		{"HEAD", http.MethodHead, "/big", "gzip", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			gzipped := w.Header().Get("Content-Encoding") == "gzip"
+			if gzipped != tt.wantGzip {
+				t.Fatalf("Content-Encoding = %q, want gzip %v", w.Header().Get("Content-Encoding"), tt.wantGzip)
+			}
+			if !gzipped {
+				if tt.method == http.MethodGet && w.Body.Len() == 0 {
+					t.Error("uncompressed body is empty")
+				}
+				return
+			}
+			if w.Header().Get("Vary") != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", w.Header().Get("Vary"))
+			}
+			zr, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(zr)
+			if err != nil || !strings.Contains(string(body), big) {
+				t.Errorf("decompressed body = %d bytes, %v", len(body), err)
+			}
+		})
+	}
+}
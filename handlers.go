@@ -0,0 +1,1618 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// AlbumMetadata represents the metadata of an album
+type AlbumMetadata struct {
+	Artist string   `json:"artist" xml:"artist"`
+	Title  string   `json:"title" xml:"title"`
+	Year   string   `json:"year" xml:"year"`
+	Tags   []string `json:"tags,omitempty" xml:"tags>tag,omitempty"`
+	// Titles holds translations of Title keyed by locale
+	Titles LocalizedTitles `json:"titles,omitempty" xml:"titles,omitempty"`
+}
+
+// AlbumInfo represents the information returned by the GET endpoint. The
+// xml tags mirror the JSON names for clients that ask for application/xml.
+// Placeholder marks an image_url that serves PLACEHOLDER_IMAGE because the
+// album has no image of its own.
+type AlbumInfo struct {
+	XMLName      xml.Name      `json:"-" xml:"album"`
+	AlbumID      int           `json:"albumID" xml:"albumID"`
+	ExternalID   string        `json:"external_id,omitempty" xml:"external_id,omitempty"`
+	ImageURL     string        `json:"image_url" xml:"image_url"`
+	Placeholder  bool          `json:"image_placeholder" xml:"image_placeholder"`
+	ThumbnailURL string        `json:"thumbnail_url,omitempty" xml:"thumbnail_url,omitempty"`
+	AudioURL     string        `json:"audio_url,omitempty" xml:"audio_url,omitempty"`
+	Checksum     string        `json:"checksum,omitempty" xml:"checksum,omitempty"`
+	Width        *int          `json:"width,omitempty" xml:"width,omitempty"`
+	Height       *int          `json:"height,omitempty" xml:"height,omitempty"`
+	SizeBytes    *int64        `json:"size_bytes,omitempty" xml:"size_bytes,omitempty"`
+	Metadata     AlbumMetadata `json:"metadata" xml:"metadata"`
+	// LocalizedTitle and TitleLocale are the title best matching the
+	// request's Accept-Language; only set when the request sends one
+	LocalizedTitle string       `json:"localized_title,omitempty" xml:"localized_title,omitempty"`
+	TitleLocale    string       `json:"title_locale,omitempty" xml:"title_locale,omitempty"`
+	Version        int          `json:"version" xml:"version"`
+	CreatedAt      time.Time    `json:"created_at" xml:"created_at"`
+	UpdatedAt      time.Time    `json:"updated_at" xml:"updated_at"`
+	DeletedAt      *time.Time   `json:"deleted_at,omitempty" xml:"deleted_at,omitempty"`
+	Images         []AlbumImage `json:"images" xml:"images>image"`
+}
+
+// AlbumList represents a page of albums returned by the list endpoint
+type AlbumList struct {
+	XMLName xml.Name    `json:"-" xml:"albums"`
+	Albums  []AlbumInfo `json:"albums" xml:"album"`
+	Total   int         `json:"total" xml:"total"`
+	Limit   int         `json:"limit" xml:"limit"`
+	Offset  int         `json:"offset" xml:"offset"`
+	// NextCursor continues the listing with ?cursor=; only set for
+	// sort=created_at when more rows may follow
+	NextCursor string `json:"next_cursor,omitempty" xml:"next_cursor,omitempty"`
+}
+
+// imageCacheControl lets browsers and CDNs cache images but revalidate with
+// the ETag once a day, since an album's image can be replaced
+const imageCacheControl = "public, max-age=86400, must-revalidate"
+
+// BatchAlbum is one metadata-only album in a POST /albums/batch request
+type BatchAlbum struct {
+	ImageURL string          `json:"image_url"`
+	Artist   string          `json:"artist"`
+	Title    string          `json:"title"`
+	Year     string          `json:"year"`
+	Tags     []string        `json:"tags"`
+	Titles   LocalizedTitles `json:"titles"`
+}
+
+// HostedAlbum is the JSON body of POST /albums for an image that is already
+// hosted elsewhere, such as on a CDN
+type HostedAlbum struct {
+	ImageURL   string          `json:"image_url"`
+	SourceURL  string          `json:"source_url"`
+	ExternalID string          `json:"external_id"`
+	Artist     string          `json:"artist"`
+	Title      string          `json:"title"`
+	Year       string          `json:"year"`
+	Tags       []string        `json:"tags"`
+	Titles     LocalizedTitles `json:"titles"`
+}
+
+// maxBatchSize caps how many albums one batch import may create
+const maxBatchSize = 500
+
+// Pagination bounds for the list endpoint
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// allowedImageTypes lists the sniffed content types accepted for upload
+var allowedImageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+}
+
+// multipartOverheadBytes leaves room for the form fields and part headers
+// around the image when capping the request body
+const multipartOverheadBytes = 1 << 20
+
+var errUnsupportedImageType = errors.New("unsupported image type: only JPEG, PNG and WebP are allowed")
+
+// POST /albums -> uploads image and stores metadata. A JSON body registers
+// an already-hosted image_url instead of uploading a file.
+func (s *Server) createAlbum(c *gin.Context) {
+	if c.ContentType() == "application/json" {
+		s.createHostedAlbum(c)
+		return
+	}
+	// Hosted albums store no bytes, so only uploads count against the quota
+	if !s.quotaLeft(c) {
+		return
+	}
+
+	// Parse the image file, the optional audio preview and the metadata
+	imageFile, ok := s.formImage(c, true)
+	if !ok {
+		return
+	}
+	audio, ok := s.formAudio(c)
+	if !ok {
+		return
+	}
+
+	if c.PostForm("image_url") != "" {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Provide either an image file or image_url, not both")
+		return
+	}
+
+	// Tags arrive as a repeated "tag" form field
+	metadata := AlbumMetadata{
+		Artist: c.PostForm("artist"),
+		Title:  c.PostForm("title"),
+		Year:   c.PostForm("year"),
+		Tags:   c.PostFormArray("tag"),
+	}
+	// Localized titles arrive as titles[<locale>] form fields
+	if titles := c.PostFormMap("titles"); len(titles) > 0 {
+		metadata.Titles = make(LocalizedTitles, len(titles))
+		for locale, title := range titles {
+			metadata.Titles[locale] = LocalizedTitle{Title: title}
+		}
+	}
+	externalID := c.PostForm("external_id")
+	errs := validateMetadata(&metadata, true)
+	errs = append(errs, validateExternalID(&externalID)...)
+	if len(errs) > 0 {
+		respondValidationErrors(c, errs)
+		return
+	}
+
+	img, err := s.readUpload(c.Request.Context(), imageFile)
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+
+	s.createUploadedAlbum(c, img, audio, metadata, externalID, imageFile.Size)
+}
+
+// createUploadedAlbum stores a prepared upload with its thumbnail and any
+// audio preview, records the album and responds with it. uploadedBytes is
+// what the client sent for the image, for the upload metric and quota. An
+// externalID already in use returns that album instead.
+func (s *Server) createUploadedAlbum(c *gin.Context, img *uploadedImage, audio []byte, metadata AlbumMetadata, externalID string, uploadedBytes int64) {
+	if s.existingExternalAlbum(c, externalID) {
+		return
+	}
+
+	// Return the existing album rather than storing the same image twice
+	if s.dedupUploads {
+		existingID, found, err := s.findDuplicate(c.Request.Context(), img.checksum)
+		if err != nil {
+			respondInternalError(c, err)
+			return
+		}
+		if found {
+			respondDuplicate(c, existingID)
+			return
+		}
+	}
+
+	// Encode the metadata before anything is stored, so a failure leaves no files behind
+	stored, err := s.storeMetadata(metadata)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	// Save the image to the configured storage
+	imagePath, err := s.storeImage(c.Request.Context(), img)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	uploadedBytesTotal.Add(float64(uploadedBytes))
+
+	var audioURL string
+	if audio != nil {
+		if audioURL, err = s.storeAudio(c.Request.Context(), audio); err != nil {
+			s.removeStoredFiles(c.Request.Context(), imagePath)
+			respondInternalError(c, err)
+			return
+		}
+	}
+
+	thumbnailURL := s.saveThumbnail(c.Request.Context(), img.data)
+
+	// Store image URL and metadata in the database
+	ctx, cancel := s.queryContext(c.Request.Context())
+	defer cancel()
+	id, err := s.insertAlbum(ctx, imagePath, img.stored(),
+		sql.NullString{String: thumbnailURL, Valid: thumbnailURL != ""},
+		sql.NullString{String: audioURL, Valid: audioURL != ""},
+		sql.NullString{String: img.checksum, Valid: s.dedupUploads},
+		sql.NullString{String: externalID, Valid: externalID != ""},
+		stored, uploadedBytes+int64(len(audio)))
+	if err != nil {
+		// Nothing references the stored files now, so remove them
+		s.removeStoredFiles(c.Request.Context(), imagePath, thumbnailURL, audioURL)
+
+		// A concurrent import of the same external ID got there first
+		if isDuplicateKey(err) && s.existingExternalAlbum(c, externalID) {
+			return
+		}
+		// A concurrent upload of the same image won the race for the unique key
+		if s.dedupUploads && isDuplicateKey(err) {
+			if existingID, found, lookupErr := s.findDuplicate(c.Request.Context(), img.checksum); lookupErr == nil && found {
+				respondDuplicate(c, existingID)
+				return
+			}
+		}
+		if errors.Is(err, errQuotaExceeded) {
+			respondQuotaExceeded(c)
+			return
+		}
+		respondInternalError(c, err)
+		return
+	}
+
+	s.notifyAlbumCreated(c.Request.Context(), id)
+
+	album, err := s.fetchAlbum(c.Request.Context(), int(id), false)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	s.presentAlbum(&album)
+	c.Set(createdAlbumIDKey, id)
+	c.Header("Location", "/albums/"+strconv.FormatInt(id, 10))
+	c.JSON(http.StatusCreated, album)
+}
+
+// createHostedAlbum stores an album whose image stays at the client-supplied
+// URL: nothing is saved to storage and no thumbnail or checksum is recorded.
+// A source_url instead is downloaded and stored like an upload.
+func (s *Server) createHostedAlbum(c *gin.Context) {
+	var req HostedAlbum
+	if !bindMetadataJSON(c, &req, hostedAlbumFields, "Invalid album") {
+		return
+	}
+
+	req.ImageURL = strings.TrimSpace(req.ImageURL)
+	req.SourceURL = strings.TrimSpace(req.SourceURL)
+	metadata := AlbumMetadata{Artist: req.Artist, Title: req.Title, Year: req.Year, Tags: req.Tags, Titles: req.Titles}
+
+	errs := validateMetadata(&metadata, true)
+	errs = append(errs, validateExternalID(&req.ExternalID)...)
+	switch {
+	case req.SourceURL != "" && req.ImageURL != "":
+		errs = append(errs, FieldError{Field: "source_url", Message: "Provide either image_url or source_url, not both"})
+	case req.SourceURL != "":
+		if err := validateImageURL("source_url", req.SourceURL); err != nil {
+			errs = append(errs, FieldError{Field: "source_url", Message: err.Error()})
+		}
+	default:
+		if err := validateImageURL("image_url", req.ImageURL); err != nil {
+			errs = append(errs, FieldError{Field: "image_url", Message: err.Error()})
+		}
+	}
+	if len(errs) > 0 {
+		respondValidationErrors(c, errs)
+		return
+	}
+
+	if req.SourceURL != "" {
+		s.createFetchedAlbum(c, req.SourceURL, metadata, req.ExternalID)
+		return
+	}
+
+	if s.existingExternalAlbum(c, req.ExternalID) {
+		return
+	}
+
+	stored, err := s.storeMetadata(metadata)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	ctx, cancel := s.queryContext(c.Request.Context())
+	defer cancel()
+	id, err := s.insertAlbum(ctx, req.ImageURL, storedImage{}, sql.NullString{}, sql.NullString{}, sql.NullString{},
+		sql.NullString{String: req.ExternalID, Valid: req.ExternalID != ""}, stored, 0)
+	if err != nil {
+		if isDuplicateKey(err) && s.existingExternalAlbum(c, req.ExternalID) {
+			return
+		}
+		respondInternalError(c, err)
+		return
+	}
+
+	s.notifyAlbumCreated(c.Request.Context(), id)
+
+	album, err := s.fetchAlbum(c.Request.Context(), int(id), false)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	s.presentAlbum(&album)
+	c.Set(createdAlbumIDKey, id)
+	c.Header("Location", "/albums/"+strconv.FormatInt(id, 10))
+	c.JSON(http.StatusCreated, album)
+}
+
+// POST /albums/batch -> imports metadata-only albums in one transaction
+func (s *Server) createAlbumBatch(c *gin.Context) {
+	var raw []json.RawMessage
+	if err := c.ShouldBindJSON(&raw); err != nil {
+		respondJSONBodyError(c, err, "Body must be a JSON array of albums")
+		return
+	}
+	items := make([]BatchAlbum, len(raw))
+	for i, item := range raw {
+		errs, err := checkMetadataSchema(item, imageURLField)
+		if err == nil && len(errs) == 0 {
+			err = json.Unmarshal(item, &items[i])
+		}
+		if err != nil {
+			respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Body must be a JSON array of albums")
+			return
+		}
+		if len(errs) > 0 {
+			respondSchemaErrors(c, fmt.Sprintf("Album at index %d: ", i), errs)
+			return
+		}
+	}
+
+	if len(items) == 0 || len(items) > maxBatchSize {
+		respondError(c, http.StatusBadRequest, ErrCodeValidation, fmt.Sprintf("Batch must contain between 1 and %d albums", maxBatchSize))
+		return
+	}
+
+	// Validate everything up front so a bad item never opens a transaction
+	stored := make([]storedMetadata, len(items))
+	for i := range items {
+		item := &items[i]
+		item.ImageURL = strings.TrimSpace(item.ImageURL)
+		metadata := AlbumMetadata{Artist: item.Artist, Title: item.Title, Year: item.Year, Tags: item.Tags, Titles: item.Titles}
+
+		errs := validateMetadata(&metadata, true)
+		// Metadata-only albums have no image; any other image_url must be a
+		// hosted URL, since a bare path would be served from the local disk
+		if item.ImageURL != "" {
+			if err := validateImageURL("image_url", item.ImageURL); err != nil {
+				errs = append(errs, FieldError{Field: "image_url", Message: err.Error()})
+			}
+		}
+		if len(errs) > 0 {
+			respondErrorDetails(c, http.StatusBadRequest, ErrCodeValidation, fmt.Sprintf("Invalid album at index %d: %s", i, fieldNames(errs)), errs)
+			return
+		}
+
+		var err error
+		if stored[i], err = s.storeMetadata(metadata); err != nil {
+			respondInternalError(c, err)
+			return
+		}
+	}
+
+	// One deadline covers the whole transaction so it can't hold locks indefinitely
+	ctx, cancel := s.queryContext(c.Request.Context())
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO albums (image_url, "+metadataColumns+") VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	defer stmt.Close()
+
+	ids := make([]int64, len(items))
+	for i, item := range items {
+		res, err := stmt.ExecContext(ctx, append([]any{item.ImageURL}, stored[i].args()...)...)
+		if err != nil {
+			respondInternalError(c, err)
+			return
+		}
+		ids[i], _ = res.LastInsertId()
+		if err := insertPrimaryImage(ctx, tx, ids[i], item.ImageURL, storedImage{}); err != nil {
+			respondInternalError(c, err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	for _, id := range ids {
+		s.notifyAlbumCreated(c.Request.Context(), id)
+	}
+
+	c.JSON(200, gin.H{"albumIDs": ids})
+}
+
+// GET /albums -> lists albums page by page, optionally searched and sorted
+func (s *Server) listAlbums(c *gin.Context) {
+	format, ok := negotiateFormat(c)
+	if !ok {
+		return
+	}
+
+	limit, offset, err := parsePagination(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		return
+	}
+
+	filter, err := parseAlbumFilter(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		return
+	}
+
+	order, err := parseAlbumSort(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		return
+	}
+
+	// The total covers every match, not just what follows the cursor
+	total, err := s.countMatching(c.Request.Context(), filter)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	// Keyset pagination: ?cursor= continues after the last row of the previous
+	// page, which stays correct while rows are inserted or deleted
+	if v := c.Query("cursor"); v != "" {
+		if c.Query("offset") != "" {
+			respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "cursor and offset cannot be combined")
+			return
+		}
+		cur, err := decodeCursor(v)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+			return
+		}
+		if order.key != "created_at" || order.dir != cur.Dir {
+			respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "cursor was issued for a different sort; cursors require sort=created_at")
+			return
+		}
+		cur.addTo(&filter)
+	}
+
+	args := append(filter.args, limit, offset)
+	albums, err := s.queryAlbums(c.Request.Context(), "SELECT "+albumColumns+" FROM albums"+filter.where()+order.orderBy()+" LIMIT ? OFFSET ?", args...)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	if err := s.attachImages(c.Request.Context(), albums); err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	prefs := acceptLanguage(c)
+	for i := range albums {
+		s.presentAlbum(&albums[i])
+		s.localizeTitle(&albums[i], prefs)
+	}
+	list := AlbumList{Albums: albums, Total: total, Limit: limit, Offset: offset}
+	if order.key == "created_at" && limit > 0 && len(albums) == limit {
+		list.NextCursor = encodeCursor(albums[len(albums)-1], order.dir)
+	}
+
+	s.setPaginationHeaders(c, list, c.Query("cursor") != "")
+	render(c, 200, format, list)
+}
+
+// GET /albums/count -> counts albums matching the same filters as the list
+// endpoint without loading any rows
+func (s *Server) countAlbums(c *gin.Context) {
+	filter, err := parseAlbumFilter(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, err.Error())
+		return
+	}
+
+	count, err := s.countMatching(c.Request.Context(), filter)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	c.JSON(200, gin.H{"count": count})
+}
+
+// GET /albums/{albumID} -> retrieves album info; ?includeDeleted=true also
+// returns soft-deleted albums
+func (s *Server) getAlbum(c *gin.Context) {
+	albumID, ok := parseAlbumID(c)
+	if !ok {
+		return
+	}
+	format, ok := negotiateFormat(c)
+	if !ok {
+		return
+	}
+
+	var album AlbumInfo
+	var err error
+	if c.Query("includeDeleted") == "true" {
+		album, err = s.fetchAlbum(c.Request.Context(), albumID, true)
+	} else {
+		album, err = s.cachedAlbum(c.Request.Context(), albumID)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusNotFound, ErrCodeNotFound, "Album not found")
+			return
+		}
+		respondInternalError(c, err)
+		return
+	}
+
+	s.presentAlbum(&album)
+	s.localizeTitle(&album, acceptLanguage(c))
+	if album.TitleLocale != "" {
+		c.Header("Content-Language", album.TitleLocale)
+	}
+	c.Header("ETag", albumETag(album.Version))
+	render(c, 200, format, album)
+}
+
+// GET /albums/{albumID}/image -> serves the stored image, or the
+// placeholder for albums created without one
+func (s *Server) getAlbumImage(c *gin.Context) {
+	albumID, ok := parseAlbumID(c)
+	if !ok {
+		return
+	}
+
+	album, err := s.fetchAlbum(c.Request.Context(), albumID, false)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusNotFound, ErrCodeNotFound, "Album not found")
+			return
+		}
+		respondInternalError(c, err)
+		return
+	}
+	if album.ImageURL == "" {
+		s.servePlaceholder(c)
+		return
+	}
+
+	downloadName := ""
+	if c.Query("download") == "true" {
+		downloadName = downloadFilename(album.AlbumID, album.Metadata)
+	}
+	s.serveImage(c, album.ImageURL, album.Checksum, downloadName)
+}
+
+// GET /albums/{albumID}/thumbnail -> serves the album's thumbnail
+func (s *Server) getAlbumThumbnail(c *gin.Context) {
+	albumID, ok := parseAlbumID(c)
+	if !ok {
+		return
+	}
+
+	album, err := s.fetchAlbum(c.Request.Context(), albumID, false)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusNotFound, ErrCodeNotFound, "Album not found")
+			return
+		}
+		respondInternalError(c, err)
+		return
+	}
+	if album.ThumbnailURL == "" {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Album has no thumbnail")
+		return
+	}
+
+	// Thumbnails have no stored checksum, so serveImage hashes the small file
+	s.serveImage(c, album.ThumbnailURL, "", "")
+}
+
+// serveImage redirects to a remote image or serves a local one with its
+// checksum as the ETag. A non-empty downloadName makes a local image an
+// attachment saved under that name plus the served file's extension;
+// redirects leave the disposition to the remote host.
+func (s *Server) serveImage(c *gin.Context, imageURL, checksum, downloadName string) {
+	// Remote backends such as S3 serve the object themselves
+	if isRemoteURL(imageURL) {
+		c.Redirect(http.StatusFound, imageURL)
+		return
+	}
+
+	if info, err := os.Stat(imageURL); err != nil || info.IsDir() {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Image not found")
+		return
+	}
+
+	// Images uploaded before checksums were stored are hashed on the fly
+	if checksum == "" {
+		var err error
+		if checksum, err = fileChecksum(imageURL); err != nil {
+			respondInternalError(c, err)
+			return
+		}
+	}
+
+	// Serve a cached WebP copy to clients that ask for it, when it is smaller
+	servePath, etag := imageURL, checksum
+	if s.convertWebP {
+		// Shared caches must key on Accept once the format can vary
+		c.Writer.Header().Add("Vary", "Accept")
+		if acceptsWebP(c.GetHeader("Accept")) {
+			if variant := webpVariant(c.Request.Context(), imageURL, s.maxImagePixels); variant != "" {
+				servePath, etag = variant, checksum+"-webp"
+			}
+		}
+	}
+
+	c.Header("ETag", `"`+etag+`"`)
+	c.Header("Cache-Control", imageCacheControl)
+	if downloadName != "" {
+		// FormatMediaType quotes the name and switches to RFC 2231 encoding
+		// for non-ASCII names
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": downloadName + filepath.Ext(servePath)}))
+	}
+	serveFile(c, servePath)
+}
+
+// maxDownloadNameLength caps download filenames well below file system limits
+const maxDownloadNameLength = 100
+
+// downloadFilename builds "Artist - Title" for a download from the album
+// metadata. Control characters, including the CR and LF that could split
+// the header, and characters file systems reject or treat as path separators
+// become "_". Leading dots and blanks are dropped so the name can't be hidden
+// or climb directories. An empty result falls back to "album-{id}".
+func downloadFilename(albumID int, m AlbumMetadata) string {
+	var parts []string
+	for _, p := range []string{m.Artist, m.Title} {
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, strings.Join(parts, " - "))
+	name = strings.TrimLeft(name, ". ")
+	if runes := []rune(name); len(runes) > maxDownloadNameLength {
+		name = strings.TrimSpace(string(runes[:maxDownloadNameLength]))
+	}
+
+	if name == "" {
+		return fmt.Sprintf("album-%d", albumID)
+	}
+	return name
+}
+
+// serveFile streams a local file through http.ServeContent. It answers Range
+// requests with 206 Partial Content and a Content-Range, checks If-Range and
+// If-None-Match against an ETag already set and If-Modified-Since against the
+// file's mtime, and sniffs the Content-Type from the extension or the content
+// unless one is set.
+func serveFile(c *gin.Context, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			respondError(c, http.StatusNotFound, ErrCodeNotFound, "File not found")
+			return
+		}
+		respondInternalError(c, err)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	http.ServeContent(c.Writer, c.Request, filepath.Base(path), info.ModTime(), f)
+}
+
+// PUT /albums/{albumID} -> replaces the album metadata. The If-Match header
+// must carry the version being replaced, as returned in the album's ETag.
+func (s *Server) updateAlbum(c *gin.Context) {
+	albumID, ok := parseAlbumID(c)
+	if !ok {
+		return
+	}
+
+	expectedVersion, ok := parseIfMatch(c)
+	if !ok {
+		return
+	}
+
+	var metadata AlbumMetadata
+	if !bindMetadataJSON(c, &metadata, nil, "Invalid metadata") {
+		return
+	}
+
+	if errs := validateMetadata(&metadata, false); len(errs) > 0 {
+		respondValidationErrors(c, errs)
+		return
+	}
+
+	// Guard against an empty body wiping the stored metadata
+	if metadata.Artist == "" && metadata.Title == "" && metadata.Year == "" && len(metadata.Tags) == 0 && len(metadata.Titles) == 0 {
+		respondError(c, http.StatusBadRequest, ErrCodeValidation, "At least one of artist, title, year, tags or titles is required")
+		return
+	}
+
+	stored, err := s.storeMetadata(metadata)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	query := "UPDATE albums SET " + metadataAssignments + ", version = version + 1 WHERE id = ? AND deleted_at IS NULL"
+	args := append(stored.args(), albumID)
+	if expectedVersion > 0 {
+		query += " AND version = ?"
+		args = append(args, expectedVersion)
+	}
+
+	// A single-statement UPDATE that failed on a lock was rolled back, so it is
+	// safe to run again
+	var n int64
+	err = s.withRetry(c.Request.Context(), func(ctx context.Context) error {
+		ctx, cancel := s.queryContext(ctx)
+		defer cancel()
+		res, err := s.db.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		// The version bump always changes the row, so zero affected rows means
+		// the album is missing or was updated since the client read it
+		n, _ = res.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	if n > 0 {
+		s.albums.invalidate(albumID)
+	}
+
+	album, err := s.fetchAlbum(c.Request.Context(), albumID, false)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusNotFound, ErrCodeNotFound, "Album not found")
+			return
+		}
+		respondInternalError(c, err)
+		return
+	}
+	if n == 0 {
+		respondErrorDetails(c, http.StatusConflict, ErrCodeConflict, "Album was modified by another request", gin.H{"version": album.Version})
+		return
+	}
+
+	s.presentAlbum(&album)
+	c.Header("ETag", albumETag(album.Version))
+	c.JSON(200, album)
+}
+
+// albumPatchFields are the metadata fields PATCH may set, in response order
+var albumPatchFields = []string{"artist", "title", "year", "tags", "titles"}
+
+// PATCH /albums/{albumID} -> updates only the metadata fields present in the
+// body; null clears a field. The merge happens under a row lock, so
+// concurrent patches of different fields don't undo each other. If-Match is
+// optional here and, when sent, must match the current version.
+func (s *Server) patchAlbum(c *gin.Context) {
+	albumID, ok := parseAlbumID(c)
+	if !ok {
+		return
+	}
+
+	expectedVersion := 0
+	if c.GetHeader("If-Match") != "" {
+		if expectedVersion, ok = parseIfMatch(c); !ok {
+			return
+		}
+	}
+
+	var patch map[string]json.RawMessage
+	if err := c.ShouldBindJSON(&patch); err != nil || patch == nil {
+		respondJSONBodyError(c, err, "Body must be a JSON object")
+		return
+	}
+	var unknown []FieldError
+	for field := range patch {
+		if !slices.Contains(albumPatchFields, field) {
+			unknown = append(unknown, FieldError{Field: field, Message: "unknown field"})
+		}
+	}
+	if len(unknown) > 0 {
+		slices.SortFunc(unknown, func(a, b FieldError) int { return strings.Compare(a.Field, b.Field) })
+		respondErrorDetails(c, http.StatusBadRequest, ErrCodeBadRequest, "Unknown fields: "+fieldNames(unknown), unknown)
+		return
+	}
+	if len(patch) == 0 {
+		respondError(c, http.StatusBadRequest, ErrCodeValidation, "At least one of artist, title, year, tags or titles is required")
+		return
+	}
+
+	ctx, cancel := s.queryContext(c.Request.Context())
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var metadataJSON, artist, title sql.NullString
+	var year sql.NullInt64
+	var version int
+	err = tx.QueryRowContext(ctx, "SELECT "+metadataColumns+", version FROM albums WHERE id = ? AND deleted_at IS NULL FOR UPDATE", albumID).
+		Scan(&metadataJSON, &artist, &title, &year, &version)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Album not found")
+		return
+	}
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	if expectedVersion > 0 && version != expectedVersion {
+		respondErrorDetails(c, http.StatusConflict, ErrCodeConflict, "Album was modified by another request", gin.H{"version": version})
+		return
+	}
+
+	metadata, err := decodeMetadata(metadataJSON, artist, title, year)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	// Only report problems with the fields being changed; stored values that
+	// predate today's rules are left alone
+	errs := applyAlbumPatch(&metadata, patch)
+	for _, e := range validateMetadata(&metadata, false) {
+		if _, patched := patch[e.Field]; patched {
+			errs = append(errs, e)
+		}
+	}
+	if len(errs) > 0 {
+		respondValidationErrors(c, errs)
+		return
+	}
+
+	updated, err := s.storeMetadata(metadata)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE albums SET "+metadataAssignments+", version = version + 1 WHERE id = ?", append(updated.args(), albumID)...); err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	s.albums.invalidate(albumID)
+
+	album, err := s.fetchAlbum(c.Request.Context(), albumID, false)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	s.presentAlbum(&album)
+	c.Header("ETag", albumETag(album.Version))
+	c.JSON(200, album)
+}
+
+// applyAlbumPatch overwrites the fields of m present in patch, returning an
+// error for each value of the wrong JSON type
+func applyAlbumPatch(m *AlbumMetadata, patch map[string]json.RawMessage) []FieldError {
+	var errs []FieldError
+	for _, field := range albumPatchFields {
+		raw, ok := patch[field]
+		if !ok {
+			continue
+		}
+
+		var err error
+		switch field {
+		case "artist":
+			m.Artist, err = patchString(raw)
+		case "title":
+			m.Title, err = patchString(raw)
+		case "year":
+			m.Year, err = patchString(raw)
+		case "tags":
+			m.Tags = nil
+			err = json.Unmarshal(raw, &m.Tags)
+		case "titles":
+			// The whole map is replaced; send every locale to keep
+			m.Titles = nil
+			if string(raw) != "null" && !hasJSONType(raw, jsonTitleMap) {
+				err = errNotObject
+				break
+			}
+			err = json.Unmarshal(raw, &m.Titles)
+		}
+		if err != nil {
+			errs = append(errs, FieldError{Field: field, Message: field + " has the wrong type"})
+		}
+	}
+	return errs
+}
+
+// patchString decodes a JSON string, treating null as empty
+func patchString(raw json.RawMessage) (string, error) {
+	var v *string
+	if err := json.Unmarshal(raw, &v); err != nil || v == nil {
+		return "", err
+	}
+	return *v, nil
+}
+
+// DELETE /albums/{albumID} -> soft-deletes the album; its image files are
+// kept so the album can be restored until a purge removes them
+func (s *Server) deleteAlbum(c *gin.Context) {
+	albumID, ok := parseAlbumID(c)
+	if !ok {
+		return
+	}
+
+	deleted, err := s.softDeleteAlbums(c.Request.Context(), []int{albumID})
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	if len(deleted) == 0 {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Album not found")
+		return
+	}
+
+	c.JSON(200, gin.H{"albumID": albumID})
+}
+
+// BulkDeleteRequest is the body of DELETE /albums
+type BulkDeleteRequest struct {
+	IDs []int `json:"ids"`
+}
+
+// maxBulkDeleteIDs caps how many albums one bulk delete may name
+const maxBulkDeleteIDs = 100
+
+// DELETE /albums -> soft-deletes several albums in one transaction, reporting
+// which IDs were deleted and which were missing or already deleted
+func (s *Server) deleteAlbums(c *gin.Context) {
+	var req BulkDeleteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondJSONBodyError(c, err, `Body must be {"ids": [...]}`)
+		return
+	}
+
+	ids, ok := uniqueAlbumIDs(c, req.IDs, maxBulkDeleteIDs)
+	if !ok {
+		return
+	}
+
+	deleted, err := s.softDeleteAlbums(c.Request.Context(), ids)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	wasDeleted := make(map[int]bool, len(deleted))
+	for _, id := range deleted {
+		wasDeleted[id] = true
+	}
+	notFound := []int{}
+	for _, id := range ids {
+		if !wasDeleted[id] {
+			notFound = append(notFound, id)
+		}
+	}
+
+	c.JSON(200, gin.H{"deleted": deleted, "not_found": notFound})
+}
+
+// uniqueAlbumIDs checks an "ids" list of between 1 and max positive album
+// IDs and returns it without duplicates, in order. Otherwise it responds
+// with a 400 and returns false.
+func uniqueAlbumIDs(c *gin.Context, requested []int, max int) ([]int, bool) {
+	if len(requested) == 0 || len(requested) > max {
+		respondError(c, http.StatusBadRequest, ErrCodeValidation, fmt.Sprintf("ids must contain between 1 and %d album IDs", max))
+		return nil, false
+	}
+
+	var ids []int
+	seen := make(map[int]bool, len(requested))
+	for _, id := range requested {
+		if id <= 0 {
+			respondError(c, http.StatusBadRequest, ErrCodeValidation, "ids must be positive integers")
+			return nil, false
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, true
+}
+
+// softDeleteAlbums marks the given albums deleted in one transaction and
+// returns the IDs that were live. Image files are kept so the albums can be
+// restored until a purge removes them.
+func (s *Server) softDeleteAlbums(ctx context.Context, ids []int) ([]int, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT id FROM albums WHERE id IN ("+placeholders(len(ids))+") AND deleted_at IS NULL ORDER BY id FOR UPDATE", args...)
+	if err != nil {
+		return nil, err
+	}
+	deleted := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		deleted = append(deleted, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(deleted) == 0 {
+		return deleted, nil
+	}
+
+	args = args[:0]
+	for _, id := range deleted {
+		args = append(args, id)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE albums SET deleted_at = CURRENT_TIMESTAMP WHERE id IN ("+placeholders(len(deleted))+")", args...); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	s.albums.invalidate(deleted...)
+	return deleted, nil
+}
+
+// placeholders returns n comma-separated "?" placeholders for an IN list
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// POST /albums/{albumID}/restore -> undoes a soft delete
+func (s *Server) restoreAlbum(c *gin.Context) {
+	albumID, ok := parseAlbumID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := s.queryContext(c.Request.Context())
+	defer cancel()
+	res, err := s.db.ExecContext(ctx, "UPDATE albums SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL", albumID)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Deleted album not found")
+		return
+	}
+	s.albums.invalidate(albumID)
+
+	album, err := s.fetchAlbum(c.Request.Context(), albumID, false)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	s.presentAlbum(&album)
+	c.JSON(200, album)
+}
+
+// albumColumns is the column list expected by scanAlbum
+const albumColumns = "id, external_id, image_url, thumbnail_url, audio_url, checksum, width, height, size_bytes, " + metadataColumns + ", version, created_at, updated_at, deleted_at"
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanAlbum reads an album row and decodes its metadata
+func scanAlbum(row rowScanner) (AlbumInfo, error) {
+	var album AlbumInfo
+	var externalID, imageURL, thumbnailURL, audioURL, checksum, metadataJSON, artist, title sql.NullString
+	var width, height, sizeBytes, year sql.NullInt64
+	var deletedAt sql.NullTime
+
+	if err := row.Scan(&album.AlbumID, &externalID, &imageURL, &thumbnailURL, &audioURL, &checksum, &width, &height, &sizeBytes,
+		&metadataJSON, &artist, &title, &year, &album.Version, &album.CreatedAt, &album.UpdatedAt, &deletedAt); err != nil {
+		return album, err
+	}
+	album.ExternalID = externalID.String
+	// Deleting an album's last extra image used to write NULL here
+	album.ImageURL = imageURL.String
+	album.ThumbnailURL = thumbnailURL.String
+	album.AudioURL = audioURL.String
+	album.Checksum = checksum.String
+	if width.Valid && height.Valid {
+		w, h := int(width.Int64), int(height.Int64)
+		album.Width, album.Height = &w, &h
+	}
+	if sizeBytes.Valid {
+		album.SizeBytes = &sizeBytes.Int64
+	}
+	if deletedAt.Valid {
+		album.DeletedAt = &deletedAt.Time
+	}
+
+	// Rows written out of band may hold anything; fail this request with a
+	// 500 rather than guess, and say which album needs fixing
+	var err error
+	if album.Metadata, err = decodeMetadata(metadataJSON, artist, title, year); err != nil {
+		slog.Warn("Album has malformed metadata", "album_id", album.AlbumID, "error", err)
+		return album, fmt.Errorf("album %d: %w", album.AlbumID, err)
+	}
+	return album, nil
+}
+
+// fetchAlbum loads a single album by ID, skipping soft-deleted albums unless
+// includeDeleted is set
+func (s *Server) fetchAlbum(ctx context.Context, albumID int, includeDeleted bool) (AlbumInfo, error) {
+	query := "SELECT " + albumColumns + " FROM albums WHERE id = ?"
+	if !includeDeleted {
+		query += " AND deleted_at IS NULL"
+	}
+
+	var album AlbumInfo
+	err := s.withRetry(ctx, func(ctx context.Context) error {
+		queryCtx, cancel := s.queryContext(ctx)
+		defer cancel()
+		var err error
+		album, err = scanAlbum(s.db.QueryRowContext(queryCtx, query, albumID))
+		return err
+	})
+	if err != nil {
+		return album, err
+	}
+
+	albums := []AlbumInfo{album}
+	if err := s.attachImages(ctx, albums); err != nil {
+		return album, err
+	}
+	return albums[0], nil
+}
+
+// cachedAlbum is fetchAlbum for live albums, answered from the album cache
+// when possible
+func (s *Server) cachedAlbum(ctx context.Context, albumID int) (AlbumInfo, error) {
+	album, gen, ok := s.albums.get(albumID)
+	if ok {
+		return album, nil
+	}
+
+	album, err := s.fetchAlbum(ctx, albumID, false)
+	if err != nil {
+		return album, err
+	}
+	s.albums.put(album, gen)
+	return album, nil
+}
+
+// queryAlbums runs a SELECT of albumColumns and scans every row, retrying
+// transient errors
+func (s *Server) queryAlbums(ctx context.Context, query string, args ...any) ([]AlbumInfo, error) {
+	var albums []AlbumInfo
+	err := s.withRetry(ctx, func(ctx context.Context) error {
+		queryCtx, cancel := s.queryContext(ctx)
+		defer cancel()
+		rows, err := s.db.QueryContext(queryCtx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		albums = []AlbumInfo{}
+		for rows.Next() {
+			album, err := scanAlbum(rows)
+			if err != nil {
+				return err
+			}
+			albums = append(albums, album)
+		}
+		return rows.Err()
+	})
+	return albums, err
+}
+
+// countMatching counts the albums that match filter, retrying transient errors
+func (s *Server) countMatching(ctx context.Context, filter albumFilter) (int, error) {
+	var count int
+	err := s.withRetry(ctx, func(ctx context.Context) error {
+		queryCtx, cancel := s.queryContext(ctx)
+		defer cancel()
+		return s.db.QueryRowContext(queryCtx, "SELECT COUNT(*) FROM albums"+filter.where(), filter.args...).Scan(&count)
+	})
+	return count, err
+}
+
+// parseAlbumID reads the :albumID path parameter, responding with 400 and
+// returning false unless it is a positive integer
+func parseAlbumID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("albumID"))
+	if err != nil || id <= 0 {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "albumID must be a positive integer")
+		return 0, false
+	}
+	return id, true
+}
+
+// albumETag renders an album version as an entity tag
+func albumETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// parseIfMatch reads the version a PUT or PATCH expects to replace from
+// If-Match, responding with 428 when it is missing and 400 when it is not a
+// version. "*" matches any version and is returned as 0.
+func parseIfMatch(c *gin.Context) (int, bool) {
+	v := strings.TrimSpace(c.GetHeader("If-Match"))
+	if v == "" {
+		respondError(c, http.StatusPreconditionRequired, ErrCodePreconditionRequired, "If-Match header with the album version is required")
+		return 0, false
+	}
+	if v == "*" {
+		return 0, true
+	}
+
+	version, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(v, "W/"), `"`))
+	if err != nil || version <= 0 {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "If-Match must be an album version")
+		return 0, false
+	}
+	return version, true
+}
+
+// parsePagination reads the limit and offset query parameters
+func parsePagination(c *gin.Context) (int, int, error) {
+	limit := defaultPageLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("limit must be a non-negative integer")
+		}
+		limit = min(n, maxPageLimit)
+	}
+
+	offset := 0
+	if v := c.Query("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("offset must be a non-negative integer")
+		}
+		offset = n
+	}
+
+	return limit, offset, nil
+}
+
+// removeStoredFiles deletes stored objects, logging rather than failing on errors
+func (s *Server) removeStoredFiles(ctx context.Context, urls ...string) {
+	for _, url := range urls {
+		if err := s.storage.Delete(ctx, url); err != nil {
+			slog.WarnContext(ctx, "Failed to remove stored file", "url", url, "error", err)
+		}
+	}
+}
+
+// trashReplacedFiles disposes of files an album no longer references.
+// Backends implementing Trasher move them aside; local files are left for
+// DELETE /admin/orphaned-files to collect.
+func (s *Server) trashReplacedFiles(ctx context.Context, urls ...string) {
+	trasher, ok := s.storage.(Trasher)
+	if !ok {
+		return
+	}
+	for _, url := range urls {
+		if url == "" {
+			continue
+		}
+		if err := trasher.Trash(ctx, url); err != nil {
+			slog.WarnContext(ctx, "Failed to move replaced file to trash", "url", url, "error", err)
+		}
+	}
+}
+
+// fileChecksum returns the hex SHA-256 of a file's contents
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open image: %v", err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", fmt.Errorf("failed to hash image: %v", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// uploadedImage is a validated upload, ready to be stored
+type uploadedImage struct {
+	data        []byte
+	contentType string
+	checksum    string
+	ext         string
+	// original is the upload as received when it was re-encoded and
+	// KEEP_ORIGINALS is set, stored under the same name with originalExt
+	original     []byte
+	originalExt  string
+	originalType string
+	// width and height are 0 when the image header couldn't be decoded
+	width  int
+	height int
+}
+
+// stored describes the image as recorded alongside its URL
+func (img *uploadedImage) stored() storedImage {
+	return storedImage{
+		checksum:  sql.NullString{String: img.checksum, Valid: true},
+		width:     sql.NullInt64{Int64: int64(img.width), Valid: img.width > 0},
+		height:    sql.NullInt64{Int64: int64(img.height), Valid: img.height > 0},
+		sizeBytes: sql.NullInt64{Int64: int64(len(img.data)), Valid: true},
+	}
+}
+
+// readUpload validates the uploaded image and prepares the bytes to store,
+// with EXIF stripped when enabled, the SHA-256 checksum of the result and
+// its dimensions
+func (s *Server) readUpload(ctx context.Context, imageFile *multipart.FileHeader) (*uploadedImage, error) {
+	file, err := imageFile.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open uploaded image: %v", err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read uploaded image: %v", err)
+	}
+	return s.prepareUpload(ctx, data)
+}
+
+// prepareUpload checks the type of uploaded image data, orients it, strips its
+// metadata and re-encodes it to REENCODE_FORMAT if configured, and records its
+// checksum and dimensions. The stored extension comes from the stored content
+// type, never from the client's filename, so a name like "../../x.html" can't
+// pick the path or the type a file is later served as.
+func (s *Server) prepareUpload(ctx context.Context, data []byte) (*uploadedImage, error) {
+	// Don't start on an image for a request already out of time
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Sniff the content before anything is written to storage
+	contentType := http.DetectContentType(data)
+	if !allowedImageTypes[contentType] {
+		return nil, errUnsupportedImageType
+	}
+	ext := imageExtensions[contentType]
+	received, receivedExt, receivedType := data, ext, contentType
+
+	// Every later step decodes the whole image, so its size is checked first
+	_, err := checkImagePixels(data, s.maxImagePixels)
+	if errors.Is(err, errImageTooLarge) {
+		return nil, err
+	}
+
+	// Orient before stripping, which would discard the orientation tag
+	if s.autoOrient && contentType == "image/jpeg" {
+		if data, err = autoOrientJPEG(ctx, data, s.maxImagePixels); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.stripEXIF {
+		if data, err = stripImageMetadata(contentType, data); err != nil {
+			return nil, err
+		}
+	}
+
+	// Animations would be cut to their first frame, so they keep their format
+	reencoded := false
+	if s.reencodeType != "" && contentType != s.reencodeType && !isAnimated(contentType, data) {
+		if data, err = reencodeImage(ctx, data, s.reencodeType, s.reencodeQuality, s.maxImagePixels); err != nil {
+			return nil, err
+		}
+		contentType, ext, reencoded = s.reencodeType, imageExtensions[s.reencodeType], true
+	}
+
+	sum := sha256.Sum256(data)
+	img := &uploadedImage{
+		data:        data,
+		contentType: contentType,
+		checksum:    hex.EncodeToString(sum[:]),
+		ext:         ext,
+	}
+	if reencoded && s.originals != nil {
+		img.original, img.originalExt, img.originalType = received, receivedExt, receivedType
+	}
+
+	// Dimensions are informational, so an undecodable header is only logged
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err != nil {
+		slog.WarnContext(ctx, "Could not read image dimensions", "error", err)
+	} else {
+		img.width, img.height = cfg.Width, cfg.Height
+	}
+	return img, nil
+}
+
+// formImage reads the "image" file from a multipart body capped at the upload
+// limit and MULTIPART_MAX_PARTS, responding with 413 or 400 and returning
+// false when it is unusable
+func (s *Server) formImage(c *gin.Context, withAudio bool) (*multipart.FileHeader, bool) {
+	// Cap the body so an oversized upload is rejected while it is being read
+	maxBody := s.maxUploadBytes + multipartOverheadBytes
+	if withAudio {
+		maxBody += s.maxAudioBytes
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBody)
+
+	imageFile, err := c.FormFile("image")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondError(c, http.StatusRequestEntityTooLarge, ErrCodePayloadTooLarge, "Image file too large")
+			return nil, false
+		}
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Invalid image file")
+		return nil, false
+	}
+
+	if imageFile.Size > s.maxUploadBytes {
+		respondError(c, http.StatusRequestEntityTooLarge, ErrCodePayloadTooLarge, "Image file too large")
+		return nil, false
+	}
+
+	// The body cap and net/http's own ceiling of 1000 parts bound the parse
+	// itself; a real album form is far smaller, so anything bigger is refused
+	fields, files := 0, 0
+	for _, values := range c.Request.MultipartForm.Value {
+		fields += len(values)
+	}
+	for name, headers := range c.Request.MultipartForm.File {
+		files += len(headers)
+		if name != "image" && (!withAudio || name != "audio") {
+			respondError(c, http.StatusBadRequest, ErrCodeBadRequest, fmt.Sprintf("Unexpected file part %q", name))
+			return nil, false
+		}
+		if len(headers) > 1 {
+			respondError(c, http.StatusBadRequest, ErrCodeBadRequest, fmt.Sprintf("Only one %s file is allowed", name))
+			return nil, false
+		}
+	}
+	if fields+files > s.maxFormParts {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, fmt.Sprintf("Form has more than %d parts", s.maxFormParts))
+		return nil, false
+	}
+	return imageFile, true
+}
+
+// respondUploadError maps a readUpload failure to its response
+func respondUploadError(c *gin.Context, err error) {
+	if errors.Is(err, errUnsupportedImageType) {
+		respondError(c, http.StatusUnsupportedMediaType, ErrCodeUnsupportedMedia, err.Error())
+		return
+	}
+	if errors.Is(err, errImageTooLarge) {
+		respondError(c, http.StatusRequestEntityTooLarge, ErrCodePayloadTooLarge, "Image dimensions too large")
+		return
+	}
+	if errors.Is(err, errMalformedImage) {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Image data is malformed")
+		return
+	}
+	respondInternalError(c, err)
+}
+
+// storeImage hands a prepared image to the storage backend and returns its URL.
+// A kept original is saved first under the same UUID, so it can be matched
+// to the image; originals are an archive and outlive their albums.
+func (s *Server) storeImage(ctx context.Context, img *uploadedImage) (string, error) {
+	// Name the file by UUID so uploads sharing a filename don't overwrite each other
+	name := uuid.NewString()
+	if img.original == nil {
+		return s.storage.Save(ctx, name+img.ext, img.contentType, bytes.NewReader(img.data))
+	}
+
+	originalURL, err := s.originals.Save(ctx, name+img.originalExt, img.originalType, bytes.NewReader(img.original))
+	if err != nil {
+		return "", fmt.Errorf("failed to keep original: %v", err)
+	}
+	url, err := s.storage.Save(ctx, name+img.ext, img.contentType, bytes.NewReader(img.data))
+	if err != nil {
+		if delErr := s.originals.Delete(context.WithoutCancel(ctx), originalURL); delErr != nil {
+			slog.WarnContext(ctx, "Failed to remove kept original", "url", originalURL, "error", delErr)
+		}
+		return "", err
+	}
+	return url, nil
+}
+
+// findDuplicate looks up the album holding the same image. Soft-deleted albums
+// count too, since restoring them would otherwise violate the unique key.
+func (s *Server) findDuplicate(ctx context.Context, checksum string) (int, bool, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	var id int
+	err := s.db.QueryRowContext(ctx, "SELECT id FROM albums WHERE dedup_key = ?", checksum).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return id, true, nil
+}
+
+// respondDuplicate reports that the uploaded image is already stored
+func respondDuplicate(c *gin.Context, albumID int) {
+	respondErrorDetails(c, http.StatusConflict, ErrCodeDuplicate, "An album with this image already exists", gin.H{"albumID": albumID})
+}
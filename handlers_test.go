@@ -0,0 +1,666 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func TestDeleteAlbum(t *testing.T) {
+	tests := []struct {
+		name       string
+		live       bool
+		wantStatus int
+	}{
+		{"live album", true, http.StatusOK},
+		{"missing or already deleted", false, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, nil)
+			m := ts.mock
+			rows := sqlmock.NewRows([]string{"id"})
+			if tt.live {
+				rows.AddRow(3)
+			}
+			m.ExpectBegin()
+			m.ExpectQuery(regexp.QuoteMeta("SELECT id FROM albums WHERE id IN (?) AND deleted_at IS NULL ORDER BY id FOR UPDATE")).
+				WithArgs(3).WillReturnRows(rows)
+			if tt.live {
+				m.ExpectExec(regexp.QuoteMeta("UPDATE albums SET deleted_at = CURRENT_TIMESTAMP WHERE id IN (?)")).
+					WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectCommit()
+			} else {
+				m.ExpectRollback()
+			}
+
+			w := ts.do(http.MethodDelete, "/albums/3", "", nil)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.live && w.Body.String() != `{"albumID":3}` {
+				t.Errorf("body = %s", w.Body)
+			}
+			// Soft deletion keeps the files for a restore
+			if len(ts.storage.deleted) > 0 {
+				t.Errorf("files removed: %v", ts.storage.deleted)
+			}
+		})
+	}
+}
+
+func TestStoreImageNamesAreUnique(t *testing.T) {
+	ts := newTestServer(t, nil)
+	img := &uploadedImage{data: []byte("same bytes"), contentType: "image/png", ext: ".png"}
+
+	first, err := ts.storeImage(context.Background(), img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ts.storeImage(context.Background(), img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Fatalf("both uploads stored at %q", first)
+	}
+	for _, url := range []string{first, second} {
+		if !strings.HasSuffix(url, ".png") {
+			t.Errorf("url %q lost the image extension", url)
+		}
+		if _, ok := ts.storage.objects[url]; !ok {
+			t.Errorf("%q not stored", url)
+		}
+	}
+}
+
+// postForm sends a multipart body with the named files and fields
+func (ts *testServer) postForm(method, target string, files map[string][]byte, fields map[string]string) *httptest.ResponseRecorder {
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	for name, data := range files {
+		part, _ := mw.CreateFormFile(name, name+".bin")
+		part.Write(data)
+	}
+	for name, value := range fields {
+		mw.WriteField(name, value)
+	}
+	mw.Close()
+
+	req := httptest.NewRequest(method, target, &form)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	ts.router.ServeHTTP(w, req)
+	return w
+}
+
+// testPNGData encodes a blank w×h PNG
+func testPNGData(w, h int) []byte {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h)))
+	return buf.Bytes()
+}
+
+func TestUploadRejectsNonImages(t *testing.T) {
+	gif := []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;")
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"html", []byte("<!DOCTYPE html><html><script>alert(1)</script></html>")},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`)},
+		{"gif", gif},
+		{"text", []byte("just some text")},
+		{"empty", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, nil)
+			w := ts.postForm(http.MethodPost, "/albums", map[string][]byte{"image": tt.data},
+				map[string]string{"artist": "Artist", "title": "Title"})
+			if w.Code != http.StatusUnsupportedMediaType {
+				t.Errorf("status = %d, want 415, body %s", w.Code, w.Body)
+			}
+			if len(ts.storage.objects) != 0 {
+				t.Errorf("stored %d objects, want none", len(ts.storage.objects))
+			}
+		})
+	}
+}
+
+func TestUploadSizeLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"just over the limit", 1025},
+		{"past the body cap", 1024 + multipartOverheadBytes + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, func(cfg *Config) { cfg.MaxUploadBytes = 1024 })
+			data := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, tt.size-8)...)
+
+			w := ts.postForm(http.MethodPost, "/albums", map[string][]byte{"image": data},
+				map[string]string{"artist": "Artist", "title": "Title"})
+			if w.Code != http.StatusRequestEntityTooLarge {
+				t.Errorf("status = %d, want 413, body %s", w.Code, w.Body)
+			}
+			if len(ts.storage.objects) != 0 {
+				t.Errorf("stored %d objects, want none", len(ts.storage.objects))
+			}
+		})
+	}
+}
+
+func TestCreateAlbumRequiresArtistAndTitle(t *testing.T) {
+	tests := []struct {
+		name       string
+		fields     map[string]string
+		wantFields []string
+	}{
+		{"missing artist", map[string]string{"title": "Moon Safari"}, []string{"artist"}},
+		{"blank title", map[string]string{"artist": "Air", "title": "   "}, []string{"title"}},
+		{"both blank", map[string]string{"artist": "", "title": "\t"}, []string{"artist", "title"}},
+		{"artist too long", map[string]string{"artist": strings.Repeat("a", maxFieldLength+1), "title": "T"}, []string{"artist"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, nil)
+			w := ts.postForm(http.MethodPost, "/albums", map[string][]byte{"image": testPNGData(2, 2)}, tt.fields)
+
+			var resp struct {
+				Code    string       `json:"code"`
+				Details []FieldError `json:"details"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			var fields []string
+			for _, d := range resp.Details {
+				fields = append(fields, d.Field)
+			}
+			if w.Code != http.StatusBadRequest || resp.Code != ErrCodeValidation || !slices.Equal(fields, tt.wantFields) {
+				t.Errorf("status = %d, code %q, fields %v; want 400 %s %v", w.Code, resp.Code, fields, ErrCodeValidation, tt.wantFields)
+			}
+			if len(ts.storage.objects) != 0 {
+				t.Errorf("stored %d objects, want none", len(ts.storage.objects))
+			}
+		})
+	}
+}
+
+func TestCreateAlbumReturnsLocation(t *testing.T) {
+	pngData := testPNGData(8, 6)
+	ts := newTestServer(t, func(cfg *Config) { cfg.DedupUploads = false })
+	m := ts.mock
+
+	// The 8×6 fixture's dimensions and size are recorded with the album
+	m.ExpectBegin()
+	m.ExpectExec(regexp.QuoteMeta("INSERT INTO albums (image_url, thumbnail_url, audio_url, checksum, width, height, size_bytes, dedup_key, external_id, ")).
+		WithArgs(newURL{}, newURL{}, nil, sqlmock.AnyArg(), 8, 6, len(pngData), nil, nil, []byte(`{"artist":"Artist","title":"Title","year":""}`), nil, nil, nil).
+		WillReturnResult(sqlmock.NewResult(42, 1))
+	m.ExpectExec(regexp.QuoteMeta("INSERT INTO album_images")).WillReturnResult(sqlmock.NewResult(1, 1))
+	m.ExpectCommit()
+	now := time.Now()
+	m.ExpectQuery(regexp.QuoteMeta("SELECT " + albumColumns + " FROM albums WHERE id = ? AND deleted_at IS NULL")).
+		WithArgs(42).
+		WillReturnRows(albumRows().AddRow(42, nil, "mem/a.png", "mem/a_thumb.jpg", nil, nil, 8, 6, len(pngData),
+			`{"artist":"Artist","title":"Title","year":""}`, nil, nil, nil, 1, now, now, nil))
+	m.ExpectQuery(regexp.QuoteMeta("FROM album_images WHERE album_id IN (?)")).
+		WithArgs(42).WillReturnRows(sqlmock.NewRows([]string{"album_id", "id", "image_url", "position", "width", "height", "size_bytes", "created_at"}))
+
+	w := ts.postForm(http.MethodPost, "/albums", map[string][]byte{"image": pngData},
+		map[string]string{"artist": "Artist", "title": "Title"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want 201, body %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Location"); got != "/albums/42" {
+		t.Errorf("Location = %q, want /albums/42", got)
+	}
+	var album AlbumInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &album); err != nil || album.AlbumID != 42 {
+		t.Errorf("body = %s, want album 42", w.Body)
+	}
+}
+
+// TestCreateAlbumRemovesFilesWhenInsertFails checks that a failed INSERT
+// leaves no orphaned image or thumbnail in storage
+func TestCreateAlbumRemovesFilesWhenInsertFails(t *testing.T) {
+	ts := newTestServer(t, func(cfg *Config) { cfg.DedupUploads = false })
+	ts.mock.ExpectBegin()
+	ts.mock.ExpectExec(regexp.QuoteMeta("INSERT INTO albums (")).
+		WillReturnError(errors.New("connection reset by peer"))
+	ts.mock.ExpectRollback()
+
+	w := ts.postForm(http.MethodPost, "/albums", map[string][]byte{"image": testPNGData(8, 6)},
+		map[string]string{"artist": "Artist", "title": "Title"})
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500, body %s", w.Code, w.Body)
+	}
+	if len(ts.storage.objects) != 0 {
+		t.Errorf("orphaned objects left in storage: %v", ts.storage.objects)
+	}
+	if deleted := slices.DeleteFunc(slices.Clone(ts.storage.deleted), func(url string) bool { return url == "" }); len(deleted) != 2 {
+		t.Errorf("deleted %v, want the image and its thumbnail", ts.storage.deleted)
+	}
+}
+
+func TestCreateAlbumBatchImageURL(t *testing.T) {
+	// A bare path would later be served, and migrated, straight off the disk
+	for _, imageURL := range []string{"/etc/passwd", "../images/a.jpg", "file:///etc/passwd", "images/a.jpg", "https://"} {
+		t.Run(imageURL, func(t *testing.T) {
+			ts := newTestServer(t, nil)
+			body := fmt.Sprintf(`[{"artist": "Air", "title": "Moon Safari"}, {"image_url": %q, "artist": "Air", "title": "Talkie Walkie"}]`, imageURL)
+
+			w := ts.do(http.MethodPost, "/albums/batch", body, nil)
+			var resp struct {
+				Message string       `json:"message"`
+				Details []FieldError `json:"details"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != http.StatusBadRequest || len(resp.Details) != 1 || resp.Details[0].Field != "image_url" {
+				t.Fatalf("status = %d, body %s; want 400 on image_url", w.Code, w.Body)
+			}
+			if !strings.Contains(resp.Message, "index 1") {
+				t.Errorf("message = %q, want it to name index 1", resp.Message)
+			}
+		})
+	}
+
+	t.Run("hosted and empty", func(t *testing.T) {
+		ts := newTestServer(t, nil)
+		m := ts.mock
+		m.ExpectBegin()
+		insert := m.ExpectPrepare(regexp.QuoteMeta("INSERT INTO albums (image_url, " + metadataColumns + ")"))
+		insert.ExpectExec().WithArgs("", sqlmock.AnyArg(), nil, nil, nil).WillReturnResult(sqlmock.NewResult(1, 1))
+		insert.ExpectExec().WithArgs("https://cdn.example.com/a.jpg", sqlmock.AnyArg(), nil, nil, nil).WillReturnResult(sqlmock.NewResult(2, 1))
+		m.ExpectExec(regexp.QuoteMeta("INSERT INTO album_images")).WithArgs(2, "https://cdn.example.com/a.jpg", nil, nil, nil, nil).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		m.ExpectCommit()
+
+		body := `[{"image_url": "", "artist": "Air", "title": "Moon Safari"}, {"image_url": " https://cdn.example.com/a.jpg ", "artist": "Air", "title": "Talkie Walkie"}]`
+		if w := ts.do(http.MethodPost, "/albums/batch", body, nil); w.Code != http.StatusOK {
+			t.Fatalf("status = %d, body %s", w.Code, w.Body)
+		}
+	})
+}
+
+// failingDeleteStorage is a memStorage whose deletes of the listed URLs fail
+type failingDeleteStorage struct {
+	*memStorage
+	fail map[string]bool
+}
+
+func (s failingDeleteStorage) Delete(ctx context.Context, url string) error {
+	s.memStorage.Delete(ctx, url)
+	if s.fail[url] {
+		return errors.New("delete failed")
+	}
+	return nil
+}
+
+// TestRemoveStoredFiles checks that cleanup after a failed insert tries every
+// stored file even when one of the deletes fails
+func TestRemoveStoredFiles(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ts.Server.storage = failingDeleteStorage{ts.storage, map[string]bool{"mem/a.jpg": true}}
+
+	ts.removeStoredFiles(context.Background(), "mem/a.jpg", "mem/a_thumb.jpg")
+	if want := []string{"mem/a.jpg", "mem/a_thumb.jpg"}; !slices.Equal(ts.storage.deleted, want) {
+		t.Errorf("deleted %v, want %v", ts.storage.deleted, want)
+	}
+}
+
+func TestParseAlbumID(t *testing.T) {
+	tests := []struct {
+		param  string
+		wantID int
+	}{
+		{"1", 1},
+		{"42", 42},
+		{"0", 0},
+		{"-4", 0},
+		{"abc", 0},
+		{"1.5", 0},
+		{"0x10", 0},
+		{"99999999999999999999", 0},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.param, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Params = gin.Params{{Key: "albumID", Value: tt.param}}
+
+			id, ok := parseAlbumID(c)
+			if tt.wantID > 0 {
+				if !ok || id != tt.wantID {
+					t.Errorf("parseAlbumID = %d, %v; want %d", id, ok, tt.wantID)
+				}
+				return
+			}
+			if ok || w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "albumID must be a positive integer") {
+				t.Errorf("parseAlbumID = %d, %v, status %d, body %s; want a 400", id, ok, w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestAlbumIDMustBePositive(t *testing.T) {
+	routes := []struct{ method, path, body string }{
+		{http.MethodGet, "/albums/%s", ""},
+		{http.MethodGet, "/albums/%s/image", ""},
+		{http.MethodPut, "/albums/%s", `{"artist":"A","title":"T"}`},
+		{http.MethodDelete, "/albums/%s", ""},
+		{http.MethodPost, "/albums/%s/restore", ""},
+	}
+	for _, route := range routes {
+		for _, id := range []string{"0", "-4", "abc", "1.5", "0x10", "99999999999999999999"} {
+			t.Run(route.method+" "+fmt.Sprintf(route.path, id), func(t *testing.T) {
+				ts := newTestServer(t, nil)
+				w := ts.do(route.method, fmt.Sprintf(route.path, id), route.body, nil)
+				if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "albumID must be a positive integer") {
+					t.Errorf("status = %d, body %s", w.Code, w.Body)
+				}
+			})
+		}
+	}
+}
+
+func TestUpdateAlbumIfMatch(t *testing.T) {
+	const body = `{"artist":"Air","title":"Talkie Walkie","year":"2004"}`
+	update := regexp.QuoteMeta("UPDATE albums SET metadata = ?, artist = ?, title = ?, year = ?, version = version + 1 WHERE id = ? AND deleted_at IS NULL")
+	any4 := []driver.Value{sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()}
+
+	tests := []struct {
+		name        string
+		ifMatch     string
+		updateSQL   string
+		updateArgs  []driver.Value
+		affected    int64
+		readVersion int // 0: the album is gone
+		wantStatus  int
+		wantETag    string
+	}{
+		{name: "missing", wantStatus: http.StatusPreconditionRequired},
+		{name: "not a version", ifMatch: `"latest"`, wantStatus: http.StatusBadRequest},
+		{name: "zero", ifMatch: `"0"`, wantStatus: http.StatusBadRequest},
+		{
+			name: "current version", ifMatch: `"3"`,
+			updateSQL: update + " AND version = \\?$", updateArgs: append(any4, 1, 3),
+			affected: 1, readVersion: 4, wantStatus: http.StatusOK, wantETag: `"4"`,
+		},
+		{
+			name: "weak tag", ifMatch: `W/"3"`,
+			updateSQL: update + " AND version = \\?$", updateArgs: append(any4, 1, 3),
+			affected: 1, readVersion: 4, wantStatus: http.StatusOK, wantETag: `"4"`,
+		},
+		{
+			name: "stale version", ifMatch: `"3"`,
+			updateSQL: update + " AND version = \\?$", updateArgs: append(any4, 1, 3),
+			affected: 0, readVersion: 5, wantStatus: http.StatusConflict,
+		},
+		{
+			name: "any version", ifMatch: "*",
+			updateSQL: update + "$", updateArgs: append(any4, 1),
+			affected: 1, readVersion: 6, wantStatus: http.StatusOK, wantETag: `"6"`,
+		},
+		{
+			name: "deleted album", ifMatch: `"3"`,
+			updateSQL: update + " AND version = \\?$", updateArgs: append(any4, 1, 3),
+			affected: 0, wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, nil)
+			m := ts.mock
+			if tt.updateSQL != "" {
+				m.ExpectExec(tt.updateSQL).WithArgs(tt.updateArgs...).WillReturnResult(sqlmock.NewResult(0, tt.affected))
+				read := m.ExpectQuery(regexp.QuoteMeta("SELECT " + albumColumns + " FROM albums WHERE id = ? AND deleted_at IS NULL")).WithArgs(1)
+				if tt.readVersion == 0 {
+					read.WillReturnError(sql.ErrNoRows)
+				} else {
+					now := time.Now()
+					read.WillReturnRows(albumRows().AddRow(1, nil, "mem/a.png", nil, nil, nil, nil, nil, nil,
+						`{"artist":"Air","title":"Talkie Walkie","year":"2004"}`, nil, nil, nil, tt.readVersion, now, now, nil))
+					m.ExpectQuery(regexp.QuoteMeta("FROM album_images WHERE album_id IN (?)")).
+						WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"album_id", "id", "image_url", "position", "width", "height", "size_bytes", "created_at"}))
+				}
+			}
+
+			header := http.Header{}
+			if tt.ifMatch != "" {
+				header.Set("If-Match", tt.ifMatch)
+			}
+			w := ts.do(http.MethodPut, "/albums/1", body, header)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if got := w.Header().Get("ETag"); got != tt.wantETag {
+				t.Errorf("ETag = %q, want %q", got, tt.wantETag)
+			}
+			if tt.wantStatus == http.StatusConflict && !strings.Contains(w.Body.String(), `"version":5`) {
+				t.Errorf("conflict body = %s, want the current version", w.Body)
+			}
+		})
+	}
+}
+
+func TestDeleteAlbums(t *testing.T) {
+	tooMany := make([]string, maxBulkDeleteIDs+1)
+	for i := range tooMany {
+		tooMany[i] = strconv.Itoa(i + 1)
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		queryArgs  []driver.Value
+		live       []int
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name: "some missing", body: `{"ids":[4,2,9,2]}`,
+			queryArgs: []driver.Value{4, 2, 9}, live: []int{2, 4},
+			wantStatus: http.StatusOK, wantBody: `{"deleted":[2,4],"not_found":[9]}`,
+		},
+		{
+			name: "none live", body: `{"ids":[7]}`,
+			queryArgs:  []driver.Value{7},
+			wantStatus: http.StatusOK, wantBody: `{"deleted":[],"not_found":[7]}`,
+		},
+		{name: "empty list", body: `{"ids":[]}`, wantStatus: http.StatusBadRequest},
+		{name: "too many", body: `{"ids":[` + strings.Join(tooMany, ",") + `]}`, wantStatus: http.StatusBadRequest},
+		{name: "non-positive id", body: `{"ids":[1,0]}`, wantStatus: http.StatusBadRequest},
+		{name: "not an object", body: `[1,2]`, wantStatus: http.StatusBadRequest},
+		{name: "ids not numbers", body: `{"ids":["1"]}`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, nil)
+			m := ts.mock
+			if tt.queryArgs != nil {
+				rows := sqlmock.NewRows([]string{"id"})
+				updateArgs := make([]driver.Value, len(tt.live))
+				for i, id := range tt.live {
+					rows.AddRow(id)
+					updateArgs[i] = id
+				}
+				m.ExpectBegin()
+				m.ExpectQuery(regexp.QuoteMeta("SELECT id FROM albums WHERE id IN (" + placeholders(len(tt.queryArgs)) + ") AND deleted_at IS NULL ORDER BY id FOR UPDATE")).
+					WithArgs(tt.queryArgs...).WillReturnRows(rows)
+				if len(tt.live) > 0 {
+					m.ExpectExec(regexp.QuoteMeta("UPDATE albums SET deleted_at = CURRENT_TIMESTAMP WHERE id IN (" + placeholders(len(tt.live)) + ")")).
+						WithArgs(updateArgs...).WillReturnResult(sqlmock.NewResult(0, int64(len(tt.live))))
+					m.ExpectCommit()
+				} else {
+					m.ExpectRollback()
+				}
+			}
+
+			w := ts.do(http.MethodDelete, "/albums", tt.body, nil)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("body = %s, want %s", w.Body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestGetAlbumImageInfo(t *testing.T) {
+	tests := []struct {
+		name                string
+		width, height, size any
+		want                map[string]any
+	}{
+		{"recorded", 640, 480, 51234, map[string]any{"width": 640.0, "height": 480.0, "size_bytes": 51234.0}},
+		{"hosted image", nil, nil, nil, map[string]any{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, nil)
+			now := time.Now()
+			ts.mock.ExpectQuery(regexp.QuoteMeta("SELECT " + albumColumns + " FROM albums WHERE id = ?")).
+				WithArgs(1).
+				WillReturnRows(albumRows().AddRow(1, nil, "mem/a.png", nil, nil, nil, tt.width, tt.height, tt.size,
+					`{"artist":"A","title":"T","year":"2001"}`, nil, nil, nil, 1, now, now, nil))
+			ts.mock.ExpectQuery(regexp.QuoteMeta("FROM album_images WHERE album_id IN (?)")).
+				WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"album_id", "id", "image_url", "position", "width", "height", "size_bytes", "created_at"}))
+
+			w := ts.do(http.MethodGet, "/albums/1", "", nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", w.Code, w.Body)
+			}
+			var body map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			for _, field := range []string{"width", "height", "size_bytes"} {
+				want, wantSet := tt.want[field]
+				if got, set := body[field]; set != wantSet || got != want {
+					t.Errorf("%s = %v (set %v), want %v (set %v)", field, got, set, want, wantSet)
+				}
+			}
+		})
+	}
+}
+
+func TestUploadedImageStored(t *testing.T) {
+	got := (&uploadedImage{data: make([]byte, 300), checksum: "abc", width: 20, height: 10}).stored()
+	if got.width.Int64 != 20 || got.height.Int64 != 10 || got.sizeBytes.Int64 != 300 || !got.width.Valid || !got.sizeBytes.Valid {
+		t.Errorf("stored = %+v, want 20x10 and 300 bytes", got)
+	}
+	// An image whose header could not be read keeps its size but no dimensions
+	got = (&uploadedImage{data: make([]byte, 300), checksum: "abc"}).stored()
+	if got.width.Valid || got.height.Valid || got.sizeBytes.Int64 != 300 {
+		t.Errorf("stored = %+v, want NULL dimensions and 300 bytes", got)
+	}
+}
+
+// metadataArg matches the metadata JSON written for want
+type metadataArg struct{ want AlbumMetadata }
+
+func (a metadataArg) Match(v driver.Value) bool {
+	b, ok := v.([]byte)
+	var got AlbumMetadata
+	return ok && json.Unmarshal(b, &got) == nil && reflect.DeepEqual(got, a.want)
+}
+
+func TestPatchAlbum(t *testing.T) {
+	const current = `{"artist":"Air","title":"Moon Safari","year":"1998","tags":["electronic"]}`
+	lock := regexp.QuoteMeta("SELECT metadata, artist, title, year, version FROM albums WHERE id = ? AND deleted_at IS NULL FOR UPDATE")
+
+	tests := []struct {
+		name       string
+		body       string
+		ifMatch    string
+		locked     bool // the row lock is reached
+		missing    bool
+		want       *AlbumMetadata // the merged metadata written, if any
+		wantStatus int
+	}{
+		{name: "one field", body: `{"title":"Talkie Walkie"}`, locked: true,
+			want:       &AlbumMetadata{Artist: "Air", Title: "Talkie Walkie", Year: "1998", Tags: []string{"electronic"}},
+			wantStatus: http.StatusOK},
+		{name: "null clears", body: `{"year":null,"tags":null}`, locked: true,
+			want:       &AlbumMetadata{Artist: "Air", Title: "Moon Safari"},
+			wantStatus: http.StatusOK},
+		{name: "matching If-Match", body: `{"year":"2004"}`, ifMatch: `"3"`, locked: true,
+			want:       &AlbumMetadata{Artist: "Air", Title: "Moon Safari", Year: "2004", Tags: []string{"electronic"}},
+			wantStatus: http.StatusOK},
+		{name: "stale If-Match", body: `{"year":"2004"}`, ifMatch: `"2"`, locked: true, wantStatus: http.StatusConflict},
+		{name: "wrong type", body: `{"title":7}`, locked: true, wantStatus: http.StatusBadRequest},
+		{name: "invalid value", body: `{"year":"98"}`, locked: true, wantStatus: http.StatusBadRequest},
+		{name: "missing album", body: `{"title":"T"}`, locked: true, missing: true, wantStatus: http.StatusNotFound},
+		{name: "unknown field", body: `{"titel":"T"}`, wantStatus: http.StatusBadRequest},
+		{name: "empty patch", body: `{}`, wantStatus: http.StatusBadRequest},
+		{name: "not an object", body: `["title"]`, wantStatus: http.StatusBadRequest},
+		{name: "bad If-Match", body: `{"title":"T"}`, ifMatch: "soon", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, nil)
+			m := ts.mock
+			if tt.locked {
+				m.ExpectBegin()
+				rows := sqlmock.NewRows([]string{"metadata", "artist", "title", "year", "version"})
+				if !tt.missing {
+					rows.AddRow(current, nil, nil, nil, 3)
+				}
+				m.ExpectQuery(lock).WithArgs(1).WillReturnRows(rows)
+				if tt.want == nil {
+					m.ExpectRollback()
+				}
+			}
+			if tt.want != nil {
+				m.ExpectExec(regexp.QuoteMeta("UPDATE albums SET metadata = ?, artist = ?, title = ?, year = ?, version = version + 1 WHERE id = ?")).
+					WithArgs(metadataArg{*tt.want}, nil, nil, nil, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectCommit()
+				now := time.Now()
+				m.ExpectQuery(regexp.QuoteMeta("SELECT " + albumColumns + " FROM albums WHERE id = ? AND deleted_at IS NULL")).
+					WithArgs(1).
+					WillReturnRows(albumRows().AddRow(1, nil, "mem/a.png", nil, nil, nil, nil, nil, nil, current, nil, nil, nil, 4, now, now, nil))
+				m.ExpectQuery(regexp.QuoteMeta("FROM album_images WHERE album_id IN (?)")).
+					WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"album_id", "id", "image_url", "position", "width", "height", "size_bytes", "created_at"}))
+			}
+
+			header := http.Header{}
+			if tt.ifMatch != "" {
+				header.Set("If-Match", tt.ifMatch)
+			}
+			w := ts.do(http.MethodPatch, "/albums/1", tt.body, header)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.want != nil && w.Header().Get("ETag") != `"4"` {
+				t.Errorf("ETag = %q, want \"4\"", w.Header().Get("ETag"))
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// healthCheckTimeout bounds each readiness probe so a hung DB fails fast
+const healthCheckTimeout = 2 * time.Second
+
+// healthLive reports that the process is up and serving requests
+func healthLive(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// healthReady reports whether the service can reach its dependencies
+func (s *Server) healthReady(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), healthCheckTimeout)
+	defer cancel()
+
+	if dependency, err := s.checkDependencies(ctx); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unhealthy", dependency: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "storage": "ok"})
+}
+
+// checkDependencies pings the DB and probes storage, returning the first
+// failure and which dependency, "database" or "storage", it belongs to
+func (s *Server) checkDependencies(ctx context.Context) (string, error) {
+	if err := s.db.PingContext(ctx); err != nil {
+		return "database", err
+	}
+
+	if err := s.storageProbe.check(ctx, s.storage); err != nil {
+		return "storage", err
+	}
+
+	// Pull the instance out of rotation before a full disk starts failing uploads
+	if local, ok := s.storage.(*LocalStorage); ok {
+		if err := checkFreeSpace(local.Dir, s.minFreeBytes); err != nil {
+			return "storage", err
+		}
+	}
+	return "", nil
+}
+
+// storageProbeInterval is how long a storage probe result is reused, so
+// frequent readiness checks don't write to the bucket on every call
+const storageProbeInterval = 30 * time.Second
+
+// storageProbe runs Storage.StorageHealth at most once per
+// storageProbeInterval and reports the last result in between
+type storageProbe struct {
+	mu      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+// check returns the cached result, probing again once it is stale.
+// Concurrent callers wait for a single probe rather than each running one.
+func (p *storageProbe) check(ctx context.Context, storage Storage) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.checked.IsZero() && time.Since(p.checked) < storageProbeInterval {
+		return p.err
+	}
+	err := storage.StorageHealth(ctx)
+	// A probe cut short by this caller says nothing about the backend
+	if ctx.Err() == nil {
+		p.checked, p.err = time.Now(), err
+	}
+	return err
+}
+
+var errDiskStatUnsupported = errors.New("free space check not supported on this platform")
+
+// checkFreeSpace verifies, when minFree is set, that the file system of dir
+// has at least minFree bytes available
+func checkFreeSpace(dir string, minFree int64) error {
+	if minFree <= 0 {
+		return nil
+	}
+	free, err := freeDiskBytes(dir)
+	if errors.Is(err, errDiskStatUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if free < uint64(minFree) {
+		return fmt.Errorf("only %d bytes free, need %d", free, minFree)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// failingStorage is a memStorage whose health probe fails
+type failingStorage struct {
+	*memStorage
+}
+
+func (failingStorage) StorageHealth(ctx context.Context) error {
+	return errors.New("bucket unreachable")
+}
+
+// TestHealthReadyUsesInjectedDependencies checks that readiness reports on
+// the DB and storage handed to newServer
+func TestHealthReadyUsesInjectedDependencies(t *testing.T) {
+	tests := []struct {
+		name       string
+		pingErr    error
+		storage    Storage
+		wantStatus int
+		wantBody   map[string]string
+	}{
+		{"healthy", nil, newMemStorage(), http.StatusOK, map[string]string{"status": "ok", "storage": "ok"}},
+		{"database down", errors.New("connection refused"), newMemStorage(), http.StatusServiceUnavailable,
+			map[string]string{"status": "unhealthy", "database": "connection refused"}},
+		{"storage down", nil, failingStorage{newMemStorage()}, http.StatusServiceUnavailable,
+			map[string]string{"status": "unhealthy", "storage": "bucket unreachable"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			mock.ExpectPing().WillReturnError(tt.pingErr)
+
+			cfg := testConfig(t, nil)
+			r, err := newServer(db, tt.storage, cfg).router(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+
+			var body map[string]string
+			json.Unmarshal(w.Body.Bytes(), &body)
+			if w.Code != tt.wantStatus || len(body) != len(tt.wantBody) {
+				t.Fatalf("status = %d, body %s", w.Code, w.Body)
+			}
+			for k, v := range tt.wantBody {
+				if body[k] != v {
+					t.Errorf("%s = %q, want %q", k, body[k], v)
+				}
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestCheckDependencies covers the probes a --selftest run reports on
+func TestCheckDependencies(t *testing.T) {
+	tests := []struct {
+		name           string
+		pingErr        error
+		storage        func(t *testing.T) Storage
+		minFreeBytes   int64
+		wantDependency string
+	}{
+		{"all healthy", nil, func(t *testing.T) Storage { return NewLocalStorage(t.TempDir(), 0) }, 1, ""},
+		{"database down", errors.New("connection refused"), func(t *testing.T) Storage { return newMemStorage() }, 0, "database"},
+		{"storage down", nil, func(t *testing.T) Storage { return failingStorage{newMemStorage()} }, 0, "storage"},
+		{"disk full", nil, func(t *testing.T) Storage { return NewLocalStorage(t.TempDir(), 0) }, 1 << 62, "storage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := freeDiskBytes(t.TempDir()); tt.minFreeBytes > 1 && errors.Is(err, errDiskStatUnsupported) {
+				t.Skip("free space is not checked on this platform")
+			}
+			db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			mock.ExpectPing().WillReturnError(tt.pingErr)
+
+			cfg := testConfig(t, func(cfg *Config) { cfg.StorageMinFreeBytes = tt.minFreeBytes })
+			dependency, err := newServer(db, tt.storage(t), cfg).checkDependencies(context.Background())
+			if dependency != tt.wantDependency || (err == nil) != (tt.wantDependency == "") {
+				t.Errorf("checkDependencies = %q, %v; want %q", dependency, err, tt.wantDependency)
+			}
+		})
+	}
+}
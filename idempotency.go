@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxIdempotencyKeyLength matches the idempotency_keys primary key column
+const maxIdempotencyKeyLength = 255
+
+// idempotencyClaimTimeout is how long a key can stay claimed without a
+// result before it is treated as abandoned, e.g. by a crashed instance
+const idempotencyClaimTimeout = 5 * time.Minute
+
+// createdAlbumIDKey is the gin context key under which the create handlers
+// record the ID of the album they created
+const createdAlbumIDKey = "createdAlbumID"
+
+// errIdempotencyKeyReused reports a key presented with a different request
+// than the one that claimed it
+var errIdempotencyKeyReused = errors.New("idempotency key reused for a different request")
+
+// idempotent makes POST /albums safe to retry: a request carrying an
+// Idempotency-Key that already created an album within idempotencyTTL gets
+// that album back with Idempotent-Replayed: true instead of creating
+// another. Keys are scoped to the API key that sent them, and a key reused
+// with a different method, path or body is refused with 422. The key is
+// claimed before the handler runs, so a concurrent retry is refused with 409
+// rather than racing it, and released again when the request fails so it can
+// be retried.
+func (s *Server) idempotent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Idempotency-Key must be at most 255 characters")
+			return
+		}
+
+		hash, ok := s.requestFingerprint(c)
+		if !ok {
+			return
+		}
+
+		// Requests not authenticated by API key share the '' caller
+		ctx := c.Request.Context()
+		var caller string
+		if apiKey := apiKeyFromContext(ctx); apiKey != "" {
+			caller = apiKeyHash(apiKey)
+		}
+		albumID, claimed, err := s.claimIdempotencyKey(ctx, caller, key, hash)
+		if errors.Is(err, errIdempotencyKeyReused) {
+			respondError(c, http.StatusUnprocessableEntity, ErrCodeIdempotencyKeyReused, "Idempotency-Key was already used for a different request")
+			return
+		}
+		if err != nil {
+			respondInternalError(c, err)
+			return
+		}
+		if !claimed {
+			if albumID == 0 {
+				respondError(c, http.StatusConflict, ErrCodeConflict, "A request with this Idempotency-Key is still in progress")
+				return
+			}
+			s.replayCreatedAlbum(c, albumID)
+			return
+		}
+
+		c.Next()
+
+		// Store the outcome even if the client has gone away, since that is
+		// exactly when it will retry
+		ctx, cancel := s.queryContext(context.WithoutCancel(ctx))
+		defer cancel()
+		if id := c.GetInt64(createdAlbumIDKey); id > 0 && c.Writer.Status() == http.StatusCreated {
+			_, err = s.db.ExecContext(ctx, "UPDATE idempotency_keys SET album_id = ? WHERE caller = ? AND idempotency_key = ?", id, caller, key)
+		} else {
+			_, err = s.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE caller = ? AND idempotency_key = ?", caller, key)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to record idempotency key", "error", err)
+		}
+	}
+}
+
+// requestFingerprint hashes the method, path and body of the request and
+// puts the body back for the handler, responding with 413 or 400 and
+// returning false when it can't be read. Multipart boundaries are left out,
+// as a client picks a new one for every retry.
+func (s *Server) requestFingerprint(c *gin.Context) (string, bool) {
+	// limitJSON already caps JSON bodies; formImage caps multipart ones
+	// tighter when the form carries no audio
+	multipart := c.ContentType() == "multipart/form-data"
+	body := c.Request.Body
+	if multipart {
+		body = http.MaxBytesReader(c.Writer, body, s.maxUploadBytes+s.maxAudioBytes+multipartOverheadBytes)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if multipart && errors.As(err, &maxBytesErr) {
+			respondError(c, http.StatusRequestEntityTooLarge, ErrCodePayloadTooLarge, "Image file too large")
+			return "", false
+		}
+		respondJSONBodyError(c, err, "Request body could not be read")
+		return "", false
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(data))
+
+	if multipart {
+		if _, params, err := mime.ParseMediaType(c.GetHeader("Content-Type")); err == nil && params["boundary"] != "" {
+			data = bytes.ReplaceAll(data, []byte(params["boundary"]), nil)
+		}
+	}
+	h := sha256.New()
+	io.WriteString(h, c.Request.Method+" "+c.Request.URL.Path+"\n")
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// claimIdempotencyKey records caller's key as in progress for the request
+// hashed to hash and reports true, or returns the album a live earlier
+// request created with it (0 while that request is still running).
+// Expired and abandoned keys are replaced; a key claimed by a different
+// request gives errIdempotencyKeyReused.
+func (s *Server) claimIdempotencyKey(ctx context.Context, caller, key, hash string) (int, bool, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	// Compare in SQL so the DB clock decides, as it set created_at
+	_, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE caller = ? AND idempotency_key = ?
+		AND (created_at < CURRENT_TIMESTAMP - INTERVAL ? SECOND
+			OR (album_id IS NULL AND created_at < CURRENT_TIMESTAMP - INTERVAL ? SECOND))`,
+		caller, key, int(s.idempotencyTTL.Seconds()), int(idempotencyClaimTimeout.Seconds()))
+	if err != nil {
+		return 0, false, err
+	}
+
+	_, err = s.db.ExecContext(ctx, "INSERT INTO idempotency_keys (caller, idempotency_key, request_hash) VALUES (?, ?, ?)", caller, key, hash)
+	if err == nil {
+		return 0, true, nil
+	}
+	if !isDuplicateKey(err) {
+		return 0, false, err
+	}
+
+	var albumID sql.NullInt64
+	var storedHash sql.NullString
+	err = s.db.QueryRowContext(ctx, "SELECT album_id, request_hash FROM idempotency_keys WHERE caller = ? AND idempotency_key = ?", caller, key).
+		Scan(&albumID, &storedHash)
+	if err == sql.ErrNoRows {
+		// The other request failed and released the key in between; treat
+		// it as in progress and let the client retry
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	// Keys claimed before request_hash existed have none and match anything
+	if storedHash.Valid && storedHash.String != hash {
+		return 0, false, errIdempotencyKeyReused
+	}
+	return int(albumID.Int64), false, nil
+}
+
+// replayCreatedAlbum answers a repeated request with the album the first one
+// created, as it is now
+func (s *Server) replayCreatedAlbum(c *gin.Context, albumID int) {
+	album, err := s.fetchAlbum(c.Request.Context(), albumID, true)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	s.presentAlbum(&album)
+	c.Header("Idempotent-Replayed", "true")
+	c.Header("Location", "/albums/"+strconv.Itoa(albumID))
+	c.JSON(http.StatusCreated, album)
+}
+
+// sweepIdempotencyKeys periodically deletes keys older than idempotencyTTL,
+// until ctx is cancelled
+func (s *Server) sweepIdempotencyKeys(ctx context.Context) {
+	ticker := time.NewTicker(pendingSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			queryCtx, cancel := s.queryContext(ctx)
+			_, err := s.db.ExecContext(queryCtx, "DELETE FROM idempotency_keys WHERE created_at < CURRENT_TIMESTAMP - INTERVAL ? SECOND LIMIT 1000", int(s.idempotencyTTL.Seconds()))
+			cancel()
+			if err != nil {
+				slog.Warn("Failed to sweep expired idempotency keys", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestIdempotentCreate(t *testing.T) {
+	const key = "retry-7f3a"
+	const body = `{"image_url":"https://cdn.example.com/a.jpg","artist":"Air","title":"Moon Safari","year":"1998"}`
+	imageColumns := []string{"album_id", "id", "image_url", "position", "width", "height", "size_bytes", "created_at"}
+
+	sum := sha256.Sum256([]byte("POST /albums\n" + body))
+	hash := hex.EncodeToString(sum[:])
+	hashColumns := []string{"album_id", "request_hash"}
+	const lookup = "SELECT album_id, request_hash FROM idempotency_keys WHERE caller = ? AND idempotency_key = ?"
+
+	// expectClaim expects caller's key to be claimed, or found already taken
+	expectClaim := func(m sqlmock.Sqlmock, caller string, taken bool) {
+		m.ExpectExec(regexp.QuoteMeta("DELETE FROM idempotency_keys WHERE caller = ? AND idempotency_key = ?")).
+			WithArgs(caller, key, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+		insert := m.ExpectExec(regexp.QuoteMeta("INSERT INTO idempotency_keys (caller, idempotency_key, request_hash) VALUES (?, ?, ?)")).
+			WithArgs(caller, key, sqlmock.AnyArg())
+		if taken {
+			insert.WillReturnError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"})
+		} else {
+			insert.WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+	}
+	// expectAlbum expects album 7 to be read back
+	expectAlbum := func(m sqlmock.Sqlmock) {
+		now := time.Now()
+		m.ExpectQuery(regexp.QuoteMeta("SELECT " + albumColumns + " FROM albums WHERE id = ?")).
+			WithArgs(7).
+			WillReturnRows(albumRows().AddRow(7, nil, "https://cdn.example.com/a.jpg", nil, nil, nil, nil, nil, nil,
+				`{"artist":"Air","title":"Moon Safari","year":"1998"}`, nil, nil, nil, 1, now, now, nil))
+		m.ExpectQuery(regexp.QuoteMeta("FROM album_images WHERE album_id IN (?)")).
+			WithArgs(7).WillReturnRows(sqlmock.NewRows(imageColumns))
+	}
+
+	tests := []struct {
+		name         string
+		apiKey       string
+		key          string
+		body         string
+		expect       func(m sqlmock.Sqlmock)
+		wantStatus   int
+		wantReplayed bool
+	}{
+		{
+			name: "first request records the album",
+			key:  key,
+			body: body,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta("DELETE FROM idempotency_keys WHERE caller = ? AND idempotency_key = ?")).
+					WithArgs("", key, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+				m.ExpectExec(regexp.QuoteMeta("INSERT INTO idempotency_keys (caller, idempotency_key, request_hash) VALUES (?, ?, ?)")).
+					WithArgs("", key, hash).WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectBegin()
+				m.ExpectExec(regexp.QuoteMeta("INSERT INTO albums (")).WillReturnResult(sqlmock.NewResult(7, 1))
+				m.ExpectExec(regexp.QuoteMeta("INSERT INTO album_images")).WillReturnResult(sqlmock.NewResult(1, 1))
+				m.ExpectCommit()
+				expectAlbum(m)
+				m.ExpectExec(regexp.QuoteMeta("UPDATE idempotency_keys SET album_id = ? WHERE caller = ? AND idempotency_key = ?")).
+					WithArgs(7, "", key).WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:   "keys are scoped to the API key",
+			apiKey: "key-a",
+			key:    key,
+			body:   body,
+			expect: func(m sqlmock.Sqlmock) {
+				expectClaim(m, apiKeyHash("key-a"), true)
+				m.ExpectQuery(regexp.QuoteMeta(lookup)).
+					WithArgs(apiKeyHash("key-a"), key).WillReturnRows(sqlmock.NewRows(hashColumns).AddRow(7, hash))
+				expectAlbum(m)
+			},
+			wantStatus:   http.StatusCreated,
+			wantReplayed: true,
+		},
+		{
+			name: "retry replays the album",
+			key:  key,
+			body: body,
+			expect: func(m sqlmock.Sqlmock) {
+				expectClaim(m, "", true)
+				m.ExpectQuery(regexp.QuoteMeta(lookup)).
+					WithArgs("", key).WillReturnRows(sqlmock.NewRows(hashColumns).AddRow(7, hash))
+				expectAlbum(m)
+			},
+			wantStatus:   http.StatusCreated,
+			wantReplayed: true,
+		},
+		{
+			name: "key claimed before request hashes replays",
+			key:  key,
+			body: body,
+			expect: func(m sqlmock.Sqlmock) {
+				expectClaim(m, "", true)
+				m.ExpectQuery(regexp.QuoteMeta(lookup)).
+					WithArgs("", key).WillReturnRows(sqlmock.NewRows(hashColumns).AddRow(7, nil))
+				expectAlbum(m)
+			},
+			wantStatus:   http.StatusCreated,
+			wantReplayed: true,
+		},
+		{
+			name: "key reused with a different body",
+			key:  key,
+			body: `{"image_url":"https://cdn.example.com/b.jpg","artist":"Air","title":"Talkie Walkie"}`,
+			expect: func(m sqlmock.Sqlmock) {
+				expectClaim(m, "", true)
+				m.ExpectQuery(regexp.QuoteMeta(lookup)).
+					WithArgs("", key).WillReturnRows(sqlmock.NewRows(hashColumns).AddRow(7, hash))
+			},
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "retry while in progress",
+			key:  key,
+			body: body,
+			expect: func(m sqlmock.Sqlmock) {
+				expectClaim(m, "", true)
+				m.ExpectQuery(regexp.QuoteMeta(lookup)).
+					WithArgs("", key).WillReturnRows(sqlmock.NewRows(hashColumns).AddRow(nil, hash))
+			},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name: "key released in between",
+			key:  key,
+			body: body,
+			expect: func(m sqlmock.Sqlmock) {
+				expectClaim(m, "", true)
+				m.ExpectQuery(regexp.QuoteMeta(lookup)).
+					WithArgs("", key).WillReturnRows(sqlmock.NewRows(hashColumns))
+			},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name: "failed request releases the key",
+			key:  key,
+			body: `{"image_url":`,
+			expect: func(m sqlmock.Sqlmock) {
+				expectClaim(m, "", false)
+				m.ExpectExec(regexp.QuoteMeta("DELETE FROM idempotency_keys WHERE caller = ? AND idempotency_key = ?")).
+					WithArgs("", key).WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "key too long",
+			key:        strings.Repeat("k", maxIdempotencyKeyLength+1),
+			body:       body,
+			expect:     func(m sqlmock.Sqlmock) {},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, func(cfg *Config) {
+				if tt.apiKey != "" {
+					cfg.AuthMode, cfg.APIKeys = "apikey", tt.apiKey
+				}
+			})
+			tt.expect(ts.mock)
+
+			header := http.Header{"Idempotency-Key": {tt.key}}
+			if tt.apiKey != "" {
+				header.Set("X-API-Key", tt.apiKey)
+			}
+			w := ts.do(http.MethodPost, "/albums", tt.body, header)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if replayed := w.Header().Get("Idempotent-Replayed") == "true"; replayed != tt.wantReplayed {
+				t.Errorf("Idempotent-Replayed = %q", w.Header().Get("Idempotent-Replayed"))
+			}
+			if tt.wantStatus == http.StatusCreated && w.Header().Get("Location") != "/albums/7" {
+				t.Errorf("Location = %q, want /albums/7", w.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func TestRequestFingerprintIgnoresMultipartBoundary(t *testing.T) {
+	ts := newTestServer(t, nil)
+	fingerprint := func(boundary, artist string) string {
+		body := "--" + boundary + "\r\nContent-Disposition: form-data; name=\"artist\"\r\n\r\n" + artist + "\r\n--" + boundary + "--\r\n"
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/albums", strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "multipart/form-data; boundary="+boundary)
+		hash, ok := ts.requestFingerprint(c)
+		if !ok {
+			t.Fatal("body not read")
+		}
+		if rest, _ := io.ReadAll(c.Request.Body); string(rest) != body {
+			t.Errorf("body not put back: %q", rest)
+		}
+		return hash
+	}
+
+	if fingerprint("retry1", "Air") != fingerprint("retry2", "Air") {
+		t.Error("a new boundary changed the fingerprint")
+	}
+	if fingerprint("retry1", "Air") == fingerprint("retry1", "Daft Punk") {
+		t.Error("a different form kept the fingerprint")
+	}
+}
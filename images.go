@@ -0,0 +1,598 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxAlbumImages caps how many images one album may hold
+const maxAlbumImages = 20
+
+// AlbumImage is one of an album's images. The image with the lowest position
+// is the album's primary image, mirrored in AlbumInfo.ImageURL.
+type AlbumImage struct {
+	ImageID   int       `json:"imageID" xml:"imageID"`
+	ImageURL  string    `json:"image_url" xml:"image_url"`
+	Position  int       `json:"position" xml:"position"`
+	Width     *int      `json:"width,omitempty" xml:"width,omitempty"`
+	Height    *int      `json:"height,omitempty" xml:"height,omitempty"`
+	SizeBytes *int64    `json:"size_bytes,omitempty" xml:"size_bytes,omitempty"`
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
+}
+
+// storedImage describes a stored image file; NULL fields are unknown, e.g.
+// for hosted images this service never read
+type storedImage struct {
+	checksum  sql.NullString
+	width     sql.NullInt64
+	height    sql.NullInt64
+	sizeBytes sql.NullInt64
+}
+
+// imageColumns is the album_images column list expected by scanImage
+const imageColumns = "id, image_url, position, width, height, size_bytes, created_at"
+
+// scanImage reads an album_images row
+func scanImage(row rowScanner, dest ...any) (AlbumImage, error) {
+	var img AlbumImage
+	var width, height, sizeBytes sql.NullInt64
+	dest = append(dest, &img.ImageID, &img.ImageURL, &img.Position, &width, &height, &sizeBytes, &img.CreatedAt)
+	if err := row.Scan(dest...); err != nil {
+		return img, err
+	}
+	if width.Valid && height.Valid {
+		w, h := int(width.Int64), int(height.Int64)
+		img.Width, img.Height = &w, &h
+	}
+	if sizeBytes.Valid {
+		img.SizeBytes = &sizeBytes.Int64
+	}
+	return img, nil
+}
+
+// insertAlbum creates an album together with its primary image row and
+// charges uploadedBytes to the caller's upload quota
+func (s *Server) insertAlbum(ctx context.Context, imageURL string, info storedImage, thumbnailURL, audioURL, dedupKey, externalID sql.NullString, metadata storedMetadata, uploadedBytes int64) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	args := append([]any{imageURL, thumbnailURL, audioURL, info.checksum, info.width, info.height, info.sizeBytes, dedupKey, externalID}, metadata.args()...)
+	res, err := tx.ExecContext(ctx, "INSERT INTO albums (image_url, thumbnail_url, audio_url, checksum, width, height, size_bytes, dedup_key, external_id, "+metadataColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", args...)
+	if err != nil {
+		return 0, err
+	}
+
+	id, _ := res.LastInsertId()
+	if err := insertPrimaryImage(ctx, tx, id, imageURL, info); err != nil {
+		return 0, err
+	}
+	if err := s.chargeUpload(ctx, tx, uploadedBytes); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// insertPrimaryImage records a new album's image at position 0. Albums
+// created without an image get no image row.
+func insertPrimaryImage(ctx context.Context, tx *sql.Tx, albumID int64, imageURL string, info storedImage) error {
+	if imageURL == "" {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, "INSERT INTO album_images (album_id, image_url, checksum, width, height, size_bytes, position) VALUES (?, ?, ?, ?, ?, ?, 0)",
+		albumID, imageURL, info.checksum, info.width, info.height, info.sizeBytes)
+	return err
+}
+
+// attachImages loads the images of every album in albums with one query
+func (s *Server) attachImages(ctx context.Context, albums []AlbumInfo) error {
+	if len(albums) == 0 {
+		return nil
+	}
+
+	index := make(map[int]int, len(albums))
+	args := make([]any, len(albums))
+	for i := range albums {
+		albums[i].Images = []AlbumImage{}
+		index[albums[i].AlbumID] = i
+		args[i] = albums[i].AlbumID
+	}
+
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, "SELECT album_id, "+imageColumns+" FROM album_images WHERE album_id IN ("+placeholders(len(args))+") ORDER BY position, id", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var albumID int
+		img, err := scanImage(rows, &albumID)
+		if err != nil {
+			return err
+		}
+		if i, ok := index[albumID]; ok {
+			albums[i].Images = append(albums[i].Images, img)
+		}
+	}
+	return rows.Err()
+}
+
+// parseImageID reads the :imageID path parameter, responding with 400 and
+// returning false unless it is a positive integer
+func parseImageID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("imageID"))
+	if err != nil || id <= 0 {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "imageID must be a positive integer")
+		return 0, false
+	}
+	return id, true
+}
+
+// albumExists reports whether a non-deleted album exists, responding with 404
+// or 500 and returning false otherwise
+func (s *Server) albumExists(c *gin.Context, albumID int) bool {
+	ctx, cancel := s.queryContext(c.Request.Context())
+	defer cancel()
+
+	var id int
+	err := s.db.QueryRowContext(ctx, "SELECT id FROM albums WHERE id = ? AND deleted_at IS NULL", albumID).Scan(&id)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Album not found")
+		return false
+	}
+	if err != nil {
+		respondInternalError(c, err)
+		return false
+	}
+	return true
+}
+
+// GET /albums/{albumID}/images -> lists the album's images in position order
+func (s *Server) listAlbumImages(c *gin.Context) {
+	albumID, ok := parseAlbumID(c)
+	if !ok {
+		return
+	}
+
+	album, err := s.fetchAlbum(c.Request.Context(), albumID, false)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusNotFound, ErrCodeNotFound, "Album not found")
+			return
+		}
+		respondInternalError(c, err)
+		return
+	}
+
+	s.presentAlbum(&album)
+	c.JSON(200, gin.H{"images": album.Images})
+}
+
+// POST /albums/{albumID}/images -> uploads another image for the album. The
+// optional position field defaults to after the album's last image.
+func (s *Server) addAlbumImage(c *gin.Context) {
+	albumID, ok := parseAlbumID(c)
+	if !ok {
+		return
+	}
+
+	imageFile, ok := s.formImage(c, false)
+	if !ok {
+		return
+	}
+
+	position := -1
+	if v := c.PostForm("position"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			respondError(c, http.StatusBadRequest, ErrCodeValidation, "position must be a non-negative integer")
+			return
+		}
+		position = n
+	}
+
+	if !s.albumExists(c, albumID) {
+		return
+	}
+
+	img, err := s.readUpload(c.Request.Context(), imageFile)
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+
+	imagePath, err := s.storeImage(c.Request.Context(), img)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	uploadedBytesTotal.Add(float64(imageFile.Size))
+
+	image, oldThumbnail, err := s.insertAlbumImage(c.Request.Context(), albumID, imagePath, img.stored(), position, imageFile.Size)
+	if err != nil {
+		s.removeStoredFiles(c.Request.Context(), imagePath)
+		if errors.Is(err, errTooManyImages) {
+			respondError(c, http.StatusBadRequest, ErrCodeValidation, err.Error())
+			return
+		}
+		if errors.Is(err, errQuotaExceeded) {
+			respondQuotaExceeded(c)
+			return
+		}
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusNotFound, ErrCodeNotFound, "Album not found")
+			return
+		}
+		respondInternalError(c, err)
+		return
+	}
+	s.albums.invalidate(albumID)
+	if oldThumbnail != "" {
+		s.removeStoredFiles(c.Request.Context(), oldThumbnail)
+	}
+
+	s.presentImage(albumID, &image)
+	c.Header("Location", fmt.Sprintf("/albums/%d/images/%d", albumID, image.ImageID))
+	c.JSON(http.StatusCreated, image)
+}
+
+var errTooManyImages = fmt.Errorf("an album can hold at most %d images", maxAlbumImages)
+
+// insertAlbumImage adds an image row and re-syncs the album's primary image,
+// returning the thumbnail that no longer matches the primary, if any.
+// A negative position appends the image after the album's last one.
+func (s *Server) insertAlbumImage(ctx context.Context, albumID int, imageURL string, info storedImage, position int, uploadedBytes int64) (AlbumImage, string, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return AlbumImage{}, "", err
+	}
+	defer tx.Rollback()
+
+	// Lock the album so concurrent adds can't exceed the limit or race the sync
+	var id int
+	if err := tx.QueryRowContext(ctx, "SELECT id FROM albums WHERE id = ? AND deleted_at IS NULL FOR UPDATE", albumID).Scan(&id); err != nil {
+		return AlbumImage{}, "", err
+	}
+
+	var count, maxPosition int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*), COALESCE(MAX(position), -1) FROM album_images WHERE album_id = ?", albumID).Scan(&count, &maxPosition); err != nil {
+		return AlbumImage{}, "", err
+	}
+	if count >= maxAlbumImages {
+		return AlbumImage{}, "", errTooManyImages
+	}
+	if position < 0 {
+		position = maxPosition + 1
+	}
+
+	res, err := tx.ExecContext(ctx, "INSERT INTO album_images (album_id, image_url, checksum, width, height, size_bytes, position) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		albumID, imageURL, info.checksum, info.width, info.height, info.sizeBytes, position)
+	if err != nil {
+		return AlbumImage{}, "", err
+	}
+	imageID, _ := res.LastInsertId()
+
+	oldThumbnail, err := syncPrimaryImage(ctx, tx, albumID)
+	if err != nil {
+		return AlbumImage{}, "", err
+	}
+
+	image, err := scanImage(tx.QueryRowContext(ctx, "SELECT "+imageColumns+" FROM album_images WHERE id = ?", imageID))
+	if err != nil {
+		return AlbumImage{}, "", err
+	}
+
+	if err := s.chargeUpload(ctx, tx, uploadedBytes); err != nil {
+		return AlbumImage{}, "", err
+	}
+	return image, oldThumbnail, tx.Commit()
+}
+
+// GET /albums/{albumID}/images/{imageID} -> serves one of the album's images
+func (s *Server) getAlbumImageFile(c *gin.Context) {
+	albumID, ok := parseAlbumID(c)
+	if !ok {
+		return
+	}
+	imageID, ok := parseImageID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := s.queryContext(c.Request.Context())
+	defer cancel()
+
+	var imageURL string
+	var checksum sql.NullString
+	err := s.db.QueryRowContext(ctx, "SELECT i.image_url, i.checksum FROM album_images i JOIN albums a ON a.id = i.album_id WHERE i.id = ? AND i.album_id = ? AND a.deleted_at IS NULL", imageID, albumID).
+		Scan(&imageURL, &checksum)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Image not found")
+		return
+	}
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	s.serveImage(c, imageURL, checksum.String, "")
+}
+
+// DELETE /albums/{albumID}/images/{imageID} -> removes one of the album's
+// images. Deleting the primary image promotes the next one.
+func (s *Server) deleteAlbumImage(c *gin.Context) {
+	albumID, ok := parseAlbumID(c)
+	if !ok {
+		return
+	}
+	imageID, ok := parseImageID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := s.queryContext(c.Request.Context())
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var id int
+	if err := tx.QueryRowContext(ctx, "SELECT id FROM albums WHERE id = ? AND deleted_at IS NULL FOR UPDATE", albumID).Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusNotFound, ErrCodeNotFound, "Album not found")
+			return
+		}
+		respondInternalError(c, err)
+		return
+	}
+
+	var imageURL string
+	var checksum sql.NullString
+	err = tx.QueryRowContext(ctx, "SELECT image_url, checksum FROM album_images WHERE id = ? AND album_id = ?", imageID, albumID).Scan(&imageURL, &checksum)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Image not found")
+		return
+	}
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM album_images WHERE id = ?", imageID); err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	// The album no longer holds this image, so it stops blocking re-uploads of it
+	if checksum.Valid {
+		if _, err := tx.ExecContext(ctx, "UPDATE albums SET dedup_key = NULL WHERE id = ? AND dedup_key = ?", albumID, checksum.String); err != nil {
+			respondInternalError(c, err)
+			return
+		}
+	}
+
+	oldThumbnail, err := syncPrimaryImage(ctx, tx, albumID)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	s.albums.invalidate(albumID)
+
+	// Only files this service stored carry a checksum; hosted URLs are left alone
+	if checksum.Valid {
+		s.removeStoredFiles(c.Request.Context(), imageURL)
+	}
+	if oldThumbnail != "" {
+		s.removeStoredFiles(c.Request.Context(), oldThumbnail)
+	}
+
+	c.JSON(200, gin.H{"imageID": imageID})
+}
+
+// PUT /albums/{albumID}/image -> replaces the album's primary image with an
+// uploaded file, keeping its metadata and other images. The replaced file is
+// no longer referenced: local files are left for DELETE
+// /admin/orphaned-files to collect and remote objects are moved under
+// S3_PREFIX/trash, so the change can still be undone by hand until they are
+// removed. If-Match is optional and, when sent, must match the current version.
+func (s *Server) replaceAlbumImage(c *gin.Context) {
+	albumID, ok := parseAlbumID(c)
+	if !ok {
+		return
+	}
+
+	expectedVersion := 0
+	if c.GetHeader("If-Match") != "" {
+		if expectedVersion, ok = parseIfMatch(c); !ok {
+			return
+		}
+	}
+
+	imageFile, ok := s.formImage(c, false)
+	if !ok {
+		return
+	}
+	if !s.albumExists(c, albumID) {
+		return
+	}
+
+	img, err := s.readUpload(c.Request.Context(), imageFile)
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+
+	// Re-uploading the album's own image is allowed; another album's is not
+	if s.dedupUploads {
+		existingID, found, err := s.findDuplicate(c.Request.Context(), img.checksum)
+		if err != nil {
+			respondInternalError(c, err)
+			return
+		}
+		if found && existingID != albumID {
+			respondDuplicate(c, existingID)
+			return
+		}
+	}
+
+	imagePath, err := s.storeImage(c.Request.Context(), img)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	uploadedBytesTotal.Add(float64(imageFile.Size))
+	thumbnailURL := s.saveThumbnail(c.Request.Context(), img.data)
+
+	// Nothing references the new files until the transaction commits
+	committed := false
+	defer func() {
+		if !committed {
+			s.removeStoredFiles(c.Request.Context(), imagePath, thumbnailURL)
+		}
+	}()
+
+	ctx, cancel := s.queryContext(c.Request.Context())
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var oldImageURL, oldThumbnailURL, oldChecksum sql.NullString
+	var version int
+	err = tx.QueryRowContext(ctx, "SELECT image_url, thumbnail_url, checksum, version FROM albums WHERE id = ? AND deleted_at IS NULL FOR UPDATE", albumID).
+		Scan(&oldImageURL, &oldThumbnailURL, &oldChecksum, &version)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Album not found")
+		return
+	}
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	if expectedVersion > 0 && version != expectedVersion {
+		respondErrorDetails(c, http.StatusConflict, ErrCodeConflict, "Album was modified by another request", gin.H{"version": version})
+		return
+	}
+
+	// Metadata-only albums have no image row yet
+	info := img.stored()
+	var primaryID int
+	err = tx.QueryRowContext(ctx, "SELECT id FROM album_images WHERE album_id = ? ORDER BY position, id LIMIT 1", albumID).Scan(&primaryID)
+	switch {
+	case err == sql.ErrNoRows:
+		err = insertPrimaryImage(ctx, tx, int64(albumID), imagePath, info)
+	case err == nil:
+		_, err = tx.ExecContext(ctx, "UPDATE album_images SET image_url = ?, checksum = ?, width = ?, height = ?, size_bytes = ? WHERE id = ?",
+			imagePath, info.checksum, info.width, info.height, info.sizeBytes, primaryID)
+	}
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE albums SET image_url = ?, thumbnail_url = ?, checksum = ?, width = ?, height = ?, size_bytes = ?, dedup_key = ?, version = version + 1 WHERE id = ?",
+		imagePath, sql.NullString{String: thumbnailURL, Valid: thumbnailURL != ""}, info.checksum, info.width, info.height, info.sizeBytes,
+		sql.NullString{String: img.checksum, Valid: s.dedupUploads}, albumID)
+	if err != nil {
+		// Another album took this image between the check and the update
+		if s.dedupUploads && isDuplicateKey(err) {
+			if existingID, found, lookupErr := s.findDuplicate(c.Request.Context(), img.checksum); lookupErr == nil && found {
+				respondDuplicate(c, existingID)
+				return
+			}
+		}
+		respondInternalError(c, err)
+		return
+	}
+
+	if err := s.chargeUpload(ctx, tx, imageFile.Size); err != nil {
+		if errors.Is(err, errQuotaExceeded) {
+			respondQuotaExceeded(c)
+			return
+		}
+		respondInternalError(c, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	committed = true
+	s.albums.invalidate(albumID)
+
+	// Only files this service stored carry a checksum; hosted URLs are left alone
+	if oldChecksum.Valid {
+		s.trashReplacedFiles(c.Request.Context(), oldImageURL.String)
+	}
+	s.trashReplacedFiles(c.Request.Context(), oldThumbnailURL.String)
+
+	album, err := s.fetchAlbum(c.Request.Context(), albumID, false)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	s.presentAlbum(&album)
+	c.Header("ETag", albumETag(album.Version))
+	c.JSON(200, album)
+}
+
+// syncPrimaryImage points albums.image_url at the album's lowest-positioned
+// image, or at "" once it has none, like an album created without one. When
+// the primary changes, the album's thumbnail, checksum and dimensions no
+// longer describe it, so they are replaced and the old thumbnail URL is
+// returned for cleanup.
+func syncPrimaryImage(ctx context.Context, tx *sql.Tx, albumID int) (string, error) {
+	var currentURL, thumbnailURL sql.NullString
+	if err := tx.QueryRowContext(ctx, "SELECT image_url, thumbnail_url FROM albums WHERE id = ?", albumID).Scan(&currentURL, &thumbnailURL); err != nil {
+		return "", err
+	}
+
+	var primaryURL sql.NullString
+	var info storedImage
+	err := tx.QueryRowContext(ctx, "SELECT image_url, checksum, width, height, size_bytes FROM album_images WHERE album_id = ? ORDER BY position, id LIMIT 1", albumID).
+		Scan(&primaryURL, &info.checksum, &info.width, &info.height, &info.sizeBytes)
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+
+	if primaryURL.String == currentURL.String {
+		return "", nil
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE albums SET image_url = ?, thumbnail_url = NULL, checksum = ?, width = ?, height = ?, size_bytes = ? WHERE id = ?",
+		primaryURL.String, info.checksum, info.width, info.height, info.sizeBytes, albumID)
+	if err != nil {
+		return "", err
+	}
+	return thumbnailURL.String, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestReplaceAlbumImageTrashesOldFiles(t *testing.T) {
+	const (
+		s3Image = "https://bucket.s3.amazonaws.com/old.jpg"
+		s3Thumb = "https://bucket.s3.amazonaws.com/old_thumb.jpg"
+	)
+	tests := []struct {
+		name               string
+		oldImage, oldThumb any
+		oldChecksum        any
+		wantTrashed        []string
+	}{
+		{"stored in S3", s3Image, s3Thumb, "old", []string{s3Image, s3Thumb}},
+		{"hosted elsewhere", "https://cdn.example.com/a.jpg", nil, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, nil)
+			m := ts.mock
+
+			m.ExpectQuery(regexp.QuoteMeta("SELECT id FROM albums WHERE id = ? AND deleted_at IS NULL")).
+				WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+			m.ExpectQuery(regexp.QuoteMeta("SELECT id FROM albums WHERE dedup_key = ?")).WillReturnError(sql.ErrNoRows)
+			m.ExpectBegin()
+			m.ExpectQuery(regexp.QuoteMeta("SELECT image_url, thumbnail_url, checksum, version FROM albums WHERE id = ? AND deleted_at IS NULL FOR UPDATE")).
+				WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"image_url", "thumbnail_url", "checksum", "version"}).AddRow(tt.oldImage, tt.oldThumb, tt.oldChecksum, 3))
+			m.ExpectQuery(regexp.QuoteMeta("SELECT id FROM album_images WHERE album_id = ?")).
+				WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+			m.ExpectExec(regexp.QuoteMeta("UPDATE album_images SET image_url = ?")).
+				WithArgs(newURL{}, sqlmock.AnyArg(), 8, 8, sqlmock.AnyArg(), 7).WillReturnResult(sqlmock.NewResult(0, 1))
+			m.ExpectExec(regexp.QuoteMeta("UPDATE albums SET image_url = ?, thumbnail_url = ?")).
+				WithArgs(newURL{}, newURL{}, sqlmock.AnyArg(), 8, 8, sqlmock.AnyArg(), sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+			m.ExpectCommit()
+			now := time.Now()
+			m.ExpectQuery(regexp.QuoteMeta("SELECT " + albumColumns + " FROM albums WHERE id = ?")).
+				WithArgs(1).
+				WillReturnRows(albumRows().AddRow(1, nil, "mem/new.png", "mem/new_thumb.jpg", nil, "new", 8, 8, 100,
+					`{"artist":"A","title":"T","year":"2001"}`, nil, nil, nil, 4, now, now, nil))
+			m.ExpectQuery(regexp.QuoteMeta("FROM album_images WHERE album_id IN (?)")).
+				WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"album_id", "id", "image_url", "position", "width", "height", "size_bytes", "created_at"}))
+
+			var form bytes.Buffer
+			mw := multipart.NewWriter(&form)
+			part, _ := mw.CreateFormFile("image", "cover.png")
+			png.Encode(part, image.NewRGBA(image.Rect(0, 0, 8, 8)))
+			mw.Close()
+			req := httptest.NewRequest(http.MethodPut, "/albums/1/image", &form)
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+			w := httptest.NewRecorder()
+			ts.router.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("PUT status = %d, body %s", w.Code, w.Body)
+			}
+			if w.Header().Get("ETag") != `"4"` {
+				t.Errorf("ETag = %q, want \"4\"", w.Header().Get("ETag"))
+			}
+			// The old objects are moved aside, never deleted outright
+			if !slices.Equal(ts.storage.trashed, tt.wantTrashed) {
+				t.Errorf("trashed = %v, want %v", ts.storage.trashed, tt.wantTrashed)
+			}
+			if len(ts.storage.deleted) != 0 {
+				t.Errorf("deleted = %v, want nothing", ts.storage.deleted)
+			}
+		})
+	}
+}
+
+func TestDeleteLastImageClearsImageURL(t *testing.T) {
+	ts := newTestServer(t, nil)
+	m := ts.mock
+
+	m.ExpectBegin()
+	m.ExpectQuery(regexp.QuoteMeta("SELECT id FROM albums WHERE id = ? AND deleted_at IS NULL FOR UPDATE")).
+		WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	m.ExpectQuery(regexp.QuoteMeta("SELECT image_url, checksum FROM album_images WHERE id = ? AND album_id = ?")).
+		WithArgs(5, 1).WillReturnRows(sqlmock.NewRows([]string{"image_url", "checksum"}).AddRow("mem/a.jpg", "abc"))
+	m.ExpectExec(regexp.QuoteMeta("DELETE FROM album_images WHERE id = ?")).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+	m.ExpectExec(regexp.QuoteMeta("UPDATE albums SET dedup_key = NULL WHERE id = ? AND dedup_key = ?")).
+		WithArgs(1, "abc").WillReturnResult(sqlmock.NewResult(0, 1))
+	m.ExpectQuery(regexp.QuoteMeta("SELECT image_url, thumbnail_url FROM albums WHERE id = ?")).
+		WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"image_url", "thumbnail_url"}).AddRow("mem/a.jpg", "mem/a_thumb.jpg"))
+	m.ExpectQuery(regexp.QuoteMeta("FROM album_images WHERE album_id = ? ORDER BY position, id LIMIT 1")).
+		WithArgs(1).WillReturnError(sql.ErrNoRows)
+	// The album is left without an image, stored as "" rather than NULL
+	m.ExpectExec(regexp.QuoteMeta("UPDATE albums SET image_url = ?, thumbnail_url = NULL")).
+		WithArgs("", nil, nil, nil, nil, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	m.ExpectCommit()
+
+	if w := ts.do(http.MethodDelete, "/albums/1/images/5", "", nil); w.Code != http.StatusOK {
+		t.Fatalf("DELETE status = %d, body %s", w.Code, w.Body)
+	}
+}
+
+func TestGetAlbumWithoutImage(t *testing.T) {
+	// NULL is what deleting the last image used to leave behind
+	for name, imageURL := range map[string]any{"empty": "", "null": nil} {
+		t.Run(name, func(t *testing.T) {
+			ts := newTestServer(t, nil)
+			now := time.Now()
+			ts.mock.ExpectQuery(regexp.QuoteMeta("SELECT " + albumColumns + " FROM albums WHERE id = ?")).
+				WithArgs(1).
+				WillReturnRows(albumRows().AddRow(1, nil, imageURL, nil, nil, nil, nil, nil, nil,
+					`{"artist":"A","title":"T","year":"2001"}`, nil, nil, nil, 1, now, now, nil))
+			ts.mock.ExpectQuery(regexp.QuoteMeta("FROM album_images WHERE album_id IN (?)")).
+				WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"album_id", "id", "image_url", "position", "width", "height", "size_bytes", "created_at"}))
+
+			w := ts.do(http.MethodGet, "/albums/1", "", nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("GET status = %d, body %s", w.Code, w.Body)
+			}
+			var album AlbumInfo
+			if err := json.Unmarshal(w.Body.Bytes(), &album); err != nil {
+				t.Fatal(err)
+			}
+			if album.ImageURL != "" || album.Metadata.Title != "T" {
+				t.Errorf("album = %+v, want no image_url and title T", album)
+			}
+		})
+	}
+}
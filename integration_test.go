@@ -0,0 +1,167 @@
+//go:build integration
+
+// The integration suite runs against a real MySQL started by testcontainers
+// and needs Docker:
+//
+//	go test -tags integration ./...
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/testcontainers/testcontainers-go"
+	tcmysql "github.com/testcontainers/testcontainers-go/modules/mysql"
+)
+
+// startMySQL runs a MySQL container for the test and returns a pool on it
+// with every migration applied
+func startMySQL(t *testing.T) (*sql.DB, string) {
+	t.Helper()
+	ctx := context.Background()
+
+	container, err := tcmysql.Run(ctx, "mysql:8.0",
+		tcmysql.WithDatabase("albums"),
+		tcmysql.WithUsername("albums"),
+		tcmysql.WithPassword("albums"),
+	)
+	testcontainers.CleanupContainer(t, container)
+	if err != nil {
+		t.Fatalf("start MySQL: %v", err)
+	}
+	dsn, err := container.ConnectionString(ctx)
+	if err != nil {
+		t.Fatalf("MySQL DSN: %v", err)
+	}
+
+	cfg := testConfig(t, func(cfg *Config) { cfg.DBDSN = dsn })
+	db, err := openDB(cfg)
+	if err != nil {
+		t.Fatalf("open DB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := runMigrations(ctx, db); err != nil {
+		t.Fatalf("migrations: %v", err)
+	}
+	return db, dsn
+}
+
+// integrationServer builds the router over db with local storage in a temp dir
+func integrationServer(t *testing.T, db *sql.DB, dsn string, adjust func(*Config)) *gin.Engine {
+	t.Helper()
+	cfg := testConfig(t, func(cfg *Config) {
+		cfg.DBDSN = dsn
+		if adjust != nil {
+			adjust(cfg)
+		}
+	})
+	s := newServer(db, NewLocalStorage(t.TempDir(), 0), cfg)
+	r, err := s.router(cfg)
+	if err != nil {
+		t.Fatalf("router: %v", err)
+	}
+	return r
+}
+
+func decodeAlbum(t *testing.T, w *httptest.ResponseRecorder, wantStatus int) AlbumInfo {
+	t.Helper()
+	if w.Code != wantStatus {
+		t.Fatalf("status = %d, want %d, body %s", w.Code, wantStatus, w.Body)
+	}
+	var album AlbumInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &album); err != nil {
+		t.Fatalf("decode album: %v", err)
+	}
+	return album
+}
+
+func getAlbumJSON(t *testing.T, r *gin.Engine, id int) AlbumInfo {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/albums/"+strconv.Itoa(id), nil))
+	return decodeAlbum(t, w, http.StatusOK)
+}
+
+func TestIntegrationAlbumRoundTrip(t *testing.T) {
+	db, dsn := startMySQL(t)
+
+	want := AlbumMetadata{
+		Artist: "Sigur Rós",
+		Title:  "( ) — \"Svigi\"",
+		Year:   "2002",
+		Tags:   []string{"post-rock", "ambient"},
+		Titles: LocalizedTitles{"is": {Title: "Svigi"}, "ja": {Title: "括弧"}},
+	}
+
+	// Both layouts must give back exactly what was stored
+	for _, mode := range []string{"json", "normalized"} {
+		t.Run(mode, func(t *testing.T) {
+			r := integrationServer(t, db, dsn, func(cfg *Config) { cfg.MetadataStorage = mode })
+
+			t.Run("hosted JSON", func(t *testing.T) {
+				body, _ := json.Marshal(HostedAlbum{
+					ImageURL: "https://images.example.com/" + mode + ".jpg",
+					Artist:   want.Artist, Title: want.Title, Year: want.Year,
+					Tags: want.Tags, Titles: want.Titles,
+				})
+				req := httptest.NewRequest(http.MethodPost, "/albums", bytes.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				created := decodeAlbum(t, w, http.StatusCreated)
+
+				got := getAlbumJSON(t, r, created.AlbumID)
+				if !reflect.DeepEqual(got.Metadata, want) {
+					t.Errorf("metadata = %+v, want %+v", got.Metadata, want)
+				}
+			})
+
+			t.Run("multipart upload", func(t *testing.T) {
+				var form bytes.Buffer
+				mw := multipart.NewWriter(&form)
+				part, _ := mw.CreateFormFile("image", "cover.png")
+				img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+				img.Set(int(mode[0])%8, 1, color.RGBA{R: 255, A: 255}) // distinct per mode, so dedup keeps both
+				png.Encode(part, img)
+				mw.WriteField("artist", want.Artist)
+				mw.WriteField("title", want.Title)
+				mw.WriteField("year", want.Year)
+				for _, tag := range want.Tags {
+					mw.WriteField("tag", tag)
+				}
+				for locale, title := range want.Titles {
+					mw.WriteField("titles["+locale+"]", title.Title)
+				}
+				mw.Close()
+
+				req := httptest.NewRequest(http.MethodPost, "/albums", &form)
+				req.Header.Set("Content-Type", mw.FormDataContentType())
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				created := decodeAlbum(t, w, http.StatusCreated)
+
+				got := getAlbumJSON(t, r, created.AlbumID)
+				if !reflect.DeepEqual(got.Metadata, want) {
+					t.Errorf("metadata = %+v, want %+v", got.Metadata, want)
+				}
+				if got.Width == nil || *got.Width != 8 {
+					t.Errorf("width = %v, want 8", got.Width)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// limitJSONBody caps request bodies at maxBytes, separately from
+// MAX_UPLOAD_BYTES, so a huge JSON document can't exhaust memory while it is
+// decoded. Multipart forms are left to formImage, which applies the upload
+// limit instead.
+func limitJSONBody(maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.ContentType() == "multipart/form-data" {
+			c.Next()
+			return
+		}
+		// A declared length over the cap is refused without reading
+		if c.Request.ContentLength > maxBytes {
+			respondJSONBodyTooLarge(c)
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		c.Next()
+	}
+}
+
+// respondJSONBodyError answers a JSON body that could not be read or
+// decoded: 413 when it ran past MAX_JSON_BODY_BYTES, otherwise 400 with message
+func respondJSONBodyError(c *gin.Context, err error, message string) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		respondJSONBodyTooLarge(c)
+		return
+	}
+	respondError(c, http.StatusBadRequest, ErrCodeBadRequest, message)
+}
+
+func respondJSONBodyTooLarge(c *gin.Context) {
+	respondError(c, http.StatusRequestEntityTooLarge, ErrCodePayloadTooLarge, "Request body too large")
+}
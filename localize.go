@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/language"
+)
+
+// maxTitleLocales caps how many localized titles an album may carry
+const maxTitleLocales = 50
+
+// LocalizedTitle is an album's title in one locale
+type LocalizedTitle struct {
+	Title string `json:"title"`
+}
+
+// LocalizedTitles maps BCP 47 locale codes, such as "en" or "pt-BR", to an
+// album's title in that locale. The plain title is in DEFAULT_LOCALE.
+type LocalizedTitles map[string]LocalizedTitle
+
+// MarshalXML writes the titles as <title lang="ja">…</title> elements
+// sorted by locale, since encoding/xml can't marshal maps
+func (t LocalizedTitles) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, locale := range slices.Sorted(maps.Keys(t)) {
+		elem := xml.StartElement{Name: xml.Name{Local: "title"}, Attr: []xml.Attr{{Name: xml.Name{Local: "lang"}, Value: locale}}}
+		if err := e.EncodeElement(t[locale].Title, elem); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// normalizeTitles trims localized titles and rewrites each locale code in
+// its canonical form, so "EN-us" is stored as "en-US". Codes that don't
+// parse, blank titles and locales that collide once canonical are errors.
+func normalizeTitles(titles LocalizedTitles) (LocalizedTitles, []FieldError) {
+	if len(titles) == 0 {
+		return nil, nil
+	}
+	if len(titles) > maxTitleLocales {
+		return titles, []FieldError{{Field: "titles", Message: fmt.Sprintf("titles may hold at most %d locales", maxTitleLocales)}}
+	}
+
+	var problems []string
+	out := make(LocalizedTitles, len(titles))
+	for _, code := range slices.Sorted(maps.Keys(titles)) {
+		tag, err := language.Parse(code)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%q is not a valid locale code", code))
+			continue
+		}
+		locale := tag.String()
+		if _, dup := out[locale]; dup {
+			problems = append(problems, fmt.Sprintf("%q duplicates locale %s", code, locale))
+			continue
+		}
+
+		title := strings.TrimSpace(titles[code].Title)
+		switch {
+		case title == "":
+			problems = append(problems, fmt.Sprintf("title for %s is required", locale))
+		case utf8.RuneCountInString(title) > maxFieldLength:
+			problems = append(problems, fmt.Sprintf("title for %s must be at most %d characters", locale, maxFieldLength))
+		}
+		out[locale] = LocalizedTitle{Title: title}
+	}
+	if len(problems) > 0 {
+		return titles, []FieldError{{Field: "titles", Message: strings.Join(problems, "; ")}}
+	}
+	return out, nil
+}
+
+// acceptLanguage returns the request's Accept-Language preferences, best
+// first, and marks the response as varying by them. A missing or malformed
+// header yields none.
+func acceptLanguage(c *gin.Context) []language.Tag {
+	// Add rather than set, so the Vary values of CORS and negotiateFormat stay
+	c.Writer.Header().Add("Vary", "Accept-Language")
+	prefs, _, err := language.ParseAcceptLanguage(c.GetHeader("Accept-Language"))
+	if err != nil {
+		return nil
+	}
+	return prefs
+}
+
+// localizeTitle sets LocalizedTitle and TitleLocale of album to the title
+// that best matches prefs. The plain title stands for DEFAULT_LOCALE and is
+// the fallback when nothing matches. With no prefs album is left as is.
+func (s *Server) localizeTitle(album *AlbumInfo, prefs []language.Tag) {
+	if len(prefs) == 0 {
+		return
+	}
+
+	m := album.Metadata
+	locales := []string{s.defaultLocale.String()}
+	titles := []string{m.Title}
+	if t, ok := m.Titles[locales[0]]; ok {
+		titles[0] = t.Title
+	}
+	for _, locale := range slices.Sorted(maps.Keys(m.Titles)) {
+		if locale != locales[0] {
+			locales = append(locales, locale)
+			titles = append(titles, m.Titles[locale].Title)
+		}
+	}
+
+	supported := make([]language.Tag, len(locales))
+	for i, locale := range locales {
+		supported[i] = language.Make(locale)
+	}
+	i := 0
+	if _, idx, conf := language.NewMatcher(supported).Match(prefs...); conf != language.No {
+		i = idx
+	}
+	album.LocalizedTitle = titles[i]
+	album.TitleLocale = locales[i]
+}
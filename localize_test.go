@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func TestNormalizeTitles(t *testing.T) {
+	tests := []struct {
+		name    string
+		titles  LocalizedTitles
+		want    LocalizedTitles
+		wantErr string
+	}{
+		{"none", nil, nil, ""},
+		{"canonical codes", LocalizedTitles{"EN-us": {Title: " Moon Safari "}, "ja": {Title: "月のサファリ"}},
+			LocalizedTitles{"en-US": {Title: "Moon Safari"}, "ja": {Title: "月のサファリ"}}, ""},
+		{"bad code", LocalizedTitles{"not a locale": {Title: "T"}}, nil, `"not a locale" is not a valid locale code`},
+		{"collision", LocalizedTitles{"en-US": {Title: "A"}, "en-us": {Title: "B"}}, nil, "duplicates locale en-US"},
+		{"blank title", LocalizedTitles{"fr": {Title: "  "}}, nil, "title for fr is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, errs := normalizeTitles(tt.titles)
+			if tt.wantErr != "" {
+				if len(errs) != 1 || !strings.Contains(errs[0].Message, tt.wantErr) {
+					t.Errorf("errors = %v, want %q", errs, tt.wantErr)
+				}
+				return
+			}
+			if len(errs) > 0 || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("normalizeTitles = %v, %v; want %v", got, errs, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalizeTitle(t *testing.T) {
+	s := &Server{defaultLocale: language.English}
+	metadata := AlbumMetadata{
+		Title:  "Brackets",
+		Titles: LocalizedTitles{"is": {Title: "Svigi"}, "pt-BR": {Title: "Parênteses"}},
+	}
+
+	tests := []struct {
+		acceptLanguage string
+		wantTitle      string
+		wantLocale     string
+	}{
+		{"is", "Svigi", "is"},
+		{"pt", "Parênteses", "pt-BR"},
+		{"de, is;q=0.5", "Svigi", "is"},
+		{"fr", "Brackets", "en"},
+		{"en-GB", "Brackets", "en"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptLanguage, func(t *testing.T) {
+			prefs, _, err := language.ParseAcceptLanguage(tt.acceptLanguage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			album := AlbumInfo{Metadata: metadata}
+			s.localizeTitle(&album, prefs)
+			if album.LocalizedTitle != tt.wantTitle || album.TitleLocale != tt.wantLocale {
+				t.Errorf("localized = %q (%s), want %q (%s)", album.LocalizedTitle, album.TitleLocale, tt.wantTitle, tt.wantLocale)
+			}
+		})
+	}
+
+	// Without preferences the response carries only the stored titles
+	album := AlbumInfo{Metadata: metadata}
+	s.localizeTitle(&album, nil)
+	if album.LocalizedTitle != "" || album.TitleLocale != "" {
+		t.Errorf("localized without Accept-Language: %+v", album)
+	}
+}
@@ -1,173 +1,192 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"database/sql"
-	"encoding/json"
+	"flag"
 	"fmt"
-	"io"
-	"log"
-	"mime/multipart"
+	"log/slog"
 	"net/http"
-	"os"
-	"path/filepath"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"github.com/gin-gonic/gin"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/XSAM/otelsql"
+	"github.com/go-sql-driver/mysql"
 )
 
-var db *sql.DB
+// shutdownTimeout bounds how long in-flight requests may run after a stop signal
+const shutdownTimeout = 30 * time.Second
 
-// AlbumMetadata represents the metadata of an album
-type AlbumMetadata struct {
-	Artist string `json:"artist"`
-	Title  string `json:"title"`
-	Year   string `json:"year"`
-}
-
-// AlbumInfo represents the information returned by the GET endpoint
-type AlbumInfo struct {
-	AlbumID  int           `json:"albumID"`
-	ImageURL string        `json:"image_url"`
-	Metadata AlbumMetadata `json:"metadata"`
-}
+// selfTestTimeout bounds the dependency checks of a self-test run
+const selfTestTimeout = 30 * time.Second
 
 func main() {
-	dsn := os.Getenv("DB_DSN")
-	if dsn == "" {
-		log.Fatal("DB_DSN environment variable not set")
-	}
+	selfTest := flag.Bool("selftest", false, "check the DB, migrations and storage, then exit (or set SELFTEST=true)")
+	flag.Parse()
 
-	var err error
-	db, err = sql.Open("mysql", dsn)
+	cfg, err := loadConfig()
+	slog.SetDefault(newLogger(cfg.LogLevel))
 	if err != nil {
-		log.Fatalf("Failed to open DB: %v", err)
+		fatal("Failed to load configuration", "error", err)
 	}
 
-	err = db.Ping()
+	// "migrate up|down|status" manages the schema and exits
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrateCommand(cfg, flag.Args()[1:]); err != nil {
+			fatal("Migration command failed", "error", err)
+		}
+		return
+	}
+
+	shutdownTracing, err := setupTracing(context.Background(), cfg)
 	if err != nil {
-		log.Fatalf("Failed to connect to DB: %v", err)
+		fatal("Failed to set up tracing", "error", err)
 	}
 
-	// Create the albums table if not exists
-	_, err = db.Exec(`
-	CREATE TABLE IF NOT EXISTS albums (
-		id INT AUTO_INCREMENT PRIMARY KEY,
-		image_url VARCHAR(255),
-		metadata JSON
-	) ENGINE=InnoDB;
-	`)
+	db, err := openDB(cfg)
 	if err != nil {
-		log.Fatalf("Failed to create table: %v", err)
+		fatal("Failed to connect to DB", "error", err)
 	}
 
-	// Setup Gin engine
-	r := gin.Default()
+	// Bring the schema up to date before serving traffic
+	if err := runMigrations(context.Background(), db); err != nil {
+		fatal("Failed to run migrations", "error", err)
+	}
 
-	// Health check route
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{"status": "ok"})
-	})
+	storage, err := newStorage(cfg)
+	if err != nil {
+		fatal("Failed to set up storage", "error", err)
+	}
 
-	// POST /albums -> uploads image and stores metadata
-	r.POST("/albums", func(c *gin.Context) {
-		// Parse the image file and metadata
-		imageFile, err := c.FormFile("image")
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image file"})
-			return
+	registerAlbumsGauge(db)
+
+	server := newServer(db, storage, cfg)
+	if cfg.KeepOriginals {
+		if server.originals, err = newOriginalsStorage(cfg); err != nil {
+			fatal("Failed to set up storage for originals", "error", err)
 		}
+	}
+	if cfg.PlaceholderImage != "" {
+		if server.placeholder, err = loadPlaceholder(cfg.PlaceholderImage, cfg.MaxUploadBytes); err != nil {
+			fatal("Failed to load placeholder image", "error", err)
+		}
+	}
+	r, err := server.router(cfg)
+	if err != nil {
+		fatal("Failed to set up authentication", "error", err)
+	}
 
-		artist := c.PostForm("artist")
-		title := c.PostForm("title")
-		year := c.PostForm("year")
+	srv := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           r,
+		ReadHeaderTimeout: cfg.HTTPReadHeaderTimeout,
+		ReadTimeout:       cfg.HTTPReadTimeout,
+		WriteTimeout:      cfg.HTTPWriteTimeout,
+		IdleTimeout:       cfg.HTTPIdleTimeout,
+	}
 
-		// Save the image locally
-		imagePath, err := saveImageLocally(imageFile)
+	// Load the key pair now so a bad path or key fails startup rather than
+	// the first TLS handshake
+	useTLS := cfg.TLSCertFile != ""
+	if useTLS {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+			fatal("Failed to load TLS certificate", "error", err)
 		}
-
-		// Prepare metadata as JSON
-		metadata := AlbumMetadata{
-			Artist: artist,
-			Title:  title,
-			Year:   year,
+		srv.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
 		}
+	}
 
-		metadataJSON, err := json.Marshal(metadata)
+	// Startup above already connected, migrated and loaded everything the
+	// server needs; a self-test only adds a live probe of each dependency
+	if *selfTest || cfg.SelfTest {
+		ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+		dependency, err := server.checkDependencies(ctx)
+		cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode metadata"})
-			return
+			fatal("Self-test failed", "dependency", dependency, "error", err)
 		}
+		slog.Info("Self-test passed")
+		shutdownTracing(context.Background())
+		db.Close()
+		return
+	}
 
-		// Store image URL and metadata in the database
-		res, err := db.Exec("INSERT INTO albums (image_url, metadata) VALUES (?, ?)", imagePath, metadataJSON)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Expire direct and resumable uploads that were never finished
+	go server.sweepPendingUploads(ctx)
+	go server.sweepUploadSessions(ctx)
+	go server.sweepIdempotencyKeys(ctx)
+
+	go func() {
+		slog.Info("Server starting", "port", cfg.Port, "tls", useTLS)
+		var err error
+		if useTLS {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
 		}
-
-		id, _ := res.LastInsertId()
-		c.JSON(200, gin.H{"albumID": id, "imagePath": imagePath})
-	})
-
-	// GET /albums/{albumID} -> retrieves album info
-	r.GET("/albums/:albumID", func(c *gin.Context) {
-		albumID := c.Param("albumID")
-		var album AlbumInfo
-		var metadataJSON string
-
-		row := db.QueryRow("SELECT id, image_url, metadata FROM albums WHERE id = ?", albumID)
-		if err := row.Scan(&album.AlbumID, &album.ImageURL, &metadataJSON); err != nil {
-			if err == sql.ErrNoRows {
-				c.JSON(http.StatusNotFound, gin.H{"error": "Album not found"})
-				return
-			}
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+		if err != nil && err != http.ErrServerClosed {
+			fatal("Server failed", "error", err)
 		}
+	}()
 
-		if err := json.Unmarshal([]byte(metadataJSON), &album.Metadata); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode metadata"})
-			return
-		}
+	<-ctx.Done()
+	stop()
+	slog.Info("Shutdown signal received, draining in-flight requests")
 
-		c.JSON(200, album)
-	})
+	// Give in-flight uploads a chance to finish before the DB goes away
+	start := time.Now()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Server shutdown did not complete cleanly", "error", err)
 	}
+	// Let queued webhooks go out while the DB is still open
+	server.waitForWebhooks(shutdownCtx)
+	slog.Info("Server stopped", "waited_seconds", time.Since(start).Seconds())
 
-	log.Printf("Server starting on port %s ...", port)
-	r.Run(":" + port)
-}
+	// Export the spans of the requests that just drained
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
+	}
 
-// saveImageLocally saves the uploaded image to the local file system
-func saveImageLocally(imageFile *multipart.FileHeader) (string, error) {
-	imageDir := "./images"
-	if err := os.MkdirAll(imageDir, os.ModePerm); err != nil {
-		return "", fmt.Errorf("failed to create image directory: %v", err)
+	if err := db.Close(); err != nil {
+		slog.Error("Failed to close DB", "error", err)
 	}
+}
 
-	filePath := filepath.Join(imageDir, imageFile.Filename)
-	file, err := imageFile.Open()
+// openDB opens the MySQL pool described by cfg and checks that it is reachable
+func openDB(cfg Config) (*sql.DB, error) {
+	// Timestamp columns are scanned into time.Time, which needs parseTime
+	dbCfg, err := mysql.ParseDSN(cfg.DBDSN)
 	if err != nil {
-		return "", fmt.Errorf("failed to open uploaded image: %v", err)
+		return nil, fmt.Errorf("invalid DB_DSN: %v", err)
 	}
-	defer file.Close()
+	dbCfg.ParseTime = true
 
-	out, err := os.Create(filePath)
+	connector, err := mysql.NewConnector(dbCfg)
 	if err != nil {
-		return "", fmt.Errorf("failed to save image: %v", err)
+		return nil, err
 	}
-	defer out.Close()
+	// Every statement gets a span, a no-op unless tracing is enabled, and is
+	// timed for the query histogram and slow query warnings
+	db := otelsql.OpenDB(timedConnector{Connector: connector, timer: queryTimer{threshold: cfg.DBSlowThreshold}}, otelSQLOptions...)
 
-	if _, err = io.Copy(out, file); err != nil {
-		return "", fmt.Errorf("failed to write image file: %v", err)
-	}
+	db.SetMaxOpenConns(cfg.DBMaxOpenConns)
+	db.SetMaxIdleConns(cfg.DBMaxIdleConns)
+	db.SetConnMaxLifetime(cfg.DBConnMaxLifetime)
 
-	return filePath, nil
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
 }
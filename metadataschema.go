@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// metadataSchemaVersion is the version of metadataSchema. Bump it when a
+// field is added or changes type, note the change below, and keep accepting
+// metadata already stored under earlier versions.
+//
+//	1: artist, title and year are strings; tags is an array of strings
+//	2: adds titles, an object mapping locale codes to {"title": string}
+const metadataSchemaVersion = 2
+
+// jsonType is the JSON type a schema field must have, worded for messages
+type jsonType string
+
+const (
+	jsonString      jsonType = "a string"
+	jsonStringArray jsonType = "an array of strings"
+	jsonTitleMap    jsonType = `an object mapping locale codes to {"title": string}`
+)
+
+// metadataSchema lists every field album metadata may contain. It checks
+// shape only; validateMetadata checks the values afterwards.
+var metadataSchema = map[string]jsonType{
+	"artist": jsonString,
+	"title":  jsonString,
+	"year":   jsonString,
+	"tags":   jsonStringArray,
+	"titles": jsonTitleMap,
+}
+
+// imageURLField is the extra field of album bodies that name a hosted image
+var imageURLField = map[string]jsonType{"image_url": jsonString}
+
+// hostedAlbumFields are the extra fields of a POST /albums JSON body
+var hostedAlbumFields = map[string]jsonType{"image_url": jsonString, "source_url": jsonString, "external_id": jsonString}
+
+// errNotObject means a body or batch item is not a JSON object
+var errNotObject = errors.New("not a JSON object")
+
+// checkMetadataSchema reports, sorted by field, each field of the JSON object
+// raw that neither metadataSchema nor extra defines and each value of the
+// wrong type. null is a wrong type too.
+func checkMetadataSchema(raw json.RawMessage, extra map[string]jsonType) ([]FieldError, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &obj); err != nil || obj == nil {
+		return nil, errNotObject
+	}
+
+	var errs []FieldError
+	for field, value := range obj {
+		want, ok := metadataSchema[field]
+		if !ok {
+			want, ok = extra[field]
+		}
+		if !ok {
+			errs = append(errs, FieldError{Field: field, Message: "unknown field"})
+			continue
+		}
+		if !hasJSONType(value, want) {
+			errs = append(errs, FieldError{Field: field, Message: field + " must be " + string(want)})
+		}
+	}
+	slices.SortFunc(errs, func(a, b FieldError) int { return strings.Compare(a.Field, b.Field) })
+	return errs, nil
+}
+
+// hasJSONType reports whether a JSON value is of type want
+func hasJSONType(value json.RawMessage, want jsonType) bool {
+	isString := func(v json.RawMessage) bool {
+		var s string
+		return bytes.HasPrefix(bytes.TrimSpace(v), []byte(`"`)) && json.Unmarshal(v, &s) == nil
+	}
+
+	switch want {
+	case jsonString:
+		return isString(value)
+	case jsonStringArray:
+		var items []json.RawMessage
+		if !bytes.HasPrefix(bytes.TrimSpace(value), []byte("[")) || json.Unmarshal(value, &items) != nil {
+			return false
+		}
+		for _, item := range items {
+			if !isString(item) {
+				return false
+			}
+		}
+		return true
+	case jsonTitleMap:
+		var titles map[string]map[string]json.RawMessage
+		if !bytes.HasPrefix(bytes.TrimSpace(value), []byte("{")) || json.Unmarshal(value, &titles) != nil {
+			return false
+		}
+		for _, t := range titles {
+			if len(t) != 1 || !isString(t["title"]) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// respondSchemaErrors aborts with a 400 listing each field that doesn't match
+// the metadata schema; prefix locates the object, e.g. a batch index
+func respondSchemaErrors(c *gin.Context, prefix string, errs []FieldError) {
+	msg := fmt.Sprintf("%sFields do not match metadata schema v%d: %s", prefix, metadataSchemaVersion, fieldNames(errs))
+	respondErrorDetails(c, http.StatusBadRequest, ErrCodeBadRequest, msg, errs)
+}
+
+// bindMetadataJSON decodes a JSON object body into dst after checking it
+// against the metadata schema plus extra. On failure it responds, with
+// invalidMessage when the body is not an object, and returns false.
+func bindMetadataJSON(c *gin.Context, dst any, extra map[string]jsonType, invalidMessage string) bool {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		respondJSONBodyError(c, err, invalidMessage)
+		return false
+	}
+
+	errs, err := checkMetadataSchema(body, extra)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, invalidMessage)
+		return false
+	}
+	if len(errs) > 0 {
+		respondSchemaErrors(c, "", errs)
+		return false
+	}
+
+	if err := json.Unmarshal(body, dst); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, invalidMessage)
+		return false
+	}
+	return true
+}
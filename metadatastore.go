@@ -0,0 +1,100 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// metadataColumns are the albums columns that hold AlbumMetadata, in the
+// order of storedMetadata.args
+const metadataColumns = "metadata, artist, title, year"
+
+// metadataAssignments sets metadataColumns in an UPDATE
+const metadataAssignments = "metadata = ?, artist = ?, title = ?, year = ?"
+
+// storedMetadata is AlbumMetadata laid out for the albums table. The typed
+// artist, title and year columns take precedence over the same keys in the
+// metadata JSON whenever they are not NULL.
+type storedMetadata struct {
+	json   []byte
+	artist sql.NullString
+	title  sql.NullString
+	year   sql.NullInt64
+}
+
+func (m storedMetadata) args() []any {
+	return []any{m.json, m.artist, m.title, m.year}
+}
+
+// storeMetadata lays m out for the configured METADATA_STORAGE. In json mode
+// everything is in the JSON and the typed columns are cleared, so values
+// written before a switch back from normalized mode can't shadow it. In
+// normalized mode only tags, localized titles and any value a typed column
+// can't hold, such as a legacy year like "1970s", stay in the JSON.
+func (s *Server) storeMetadata(m AlbumMetadata) (storedMetadata, error) {
+	if !s.normalizedMetadata {
+		b, err := json.Marshal(m)
+		return storedMetadata{json: b}, err
+	}
+
+	var rest struct {
+		Artist string          `json:"artist,omitempty"`
+		Title  string          `json:"title,omitempty"`
+		Year   string          `json:"year,omitempty"`
+		Tags   []string        `json:"tags,omitempty"`
+		Titles LocalizedTitles `json:"titles,omitempty"`
+	}
+	var stored storedMetadata
+	if fitsColumn(m.Artist) {
+		stored.artist = sql.NullString{String: m.Artist, Valid: true}
+	} else {
+		rest.Artist = m.Artist
+	}
+	if fitsColumn(m.Title) {
+		stored.title = sql.NullString{String: m.Title, Valid: true}
+	} else {
+		rest.Title = m.Title
+	}
+	// Only years that read back the same, so "0999" or "+999" stay strings
+	if n, err := strconv.Atoi(m.Year); err == nil && len(m.Year) == 4 && strconv.Itoa(n) == m.Year {
+		stored.year = sql.NullInt64{Int64: int64(n), Valid: true}
+	} else {
+		rest.Year = m.Year
+	}
+	rest.Tags = m.Tags
+	rest.Titles = m.Titles
+
+	b, err := json.Marshal(rest)
+	stored.json = b
+	return stored, err
+}
+
+// fitsColumn reports whether s fits a VARCHAR(maxFieldLength) column
+func fitsColumn(s string) bool {
+	return utf8.RuneCountInString(s) <= maxFieldLength
+}
+
+// decodeMetadata rebuilds AlbumMetadata from the metadata JSON and the typed
+// columns, preferring the columns. A NULL metadata column, which the original
+// schema allowed, reads as empty metadata.
+func decodeMetadata(metadataJSON, artist, title sql.NullString, year sql.NullInt64) (AlbumMetadata, error) {
+	var m AlbumMetadata
+	if metadataJSON.Valid {
+		if err := json.Unmarshal([]byte(metadataJSON.String), &m); err != nil {
+			return AlbumMetadata{}, fmt.Errorf("failed to decode metadata: %v", err)
+		}
+	}
+	if artist.Valid {
+		m.Artist = artist.String
+	}
+	if title.Valid {
+		m.Title = title.String
+	}
+	if year.Valid {
+		m.Year = strconv.FormatInt(year.Int64, 10)
+	}
+	return m, nil
+}
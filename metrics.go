@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "albumstore_http_requests_total",
+		Help: "HTTP requests handled, by route, method and status.",
+	}, []string{"route", "method", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "albumstore_http_request_duration_seconds",
+		Help:    "HTTP request latency, by route, method and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	uploadedBytesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "albumstore_uploaded_bytes_total",
+		Help: "Bytes of image data accepted by POST /albums.",
+	})
+
+	albumCacheHitsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "albumstore_album_cache_hits_total",
+		Help: "Single-album reads answered from the in-memory cache.",
+	})
+
+	albumCacheMissesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "albumstore_album_cache_misses_total",
+		Help: "Single-album reads that went to the database.",
+	})
+
+	requestTimeoutsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "albumstore_request_timeouts_total",
+		Help: "Requests that ran past REQUEST_TIMEOUT, by route.",
+	}, []string{"route"})
+)
+
+// registerAlbumsGauge exposes the number of stored albums, counted at scrape time
+func registerAlbumsGauge(db *sql.DB) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "albumstore_albums_stored",
+		Help: "Albums currently stored.",
+	}, func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		var n int
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM albums WHERE deleted_at IS NULL").Scan(&n); err != nil {
+			slog.Warn("Failed to count albums for metrics", "error", err)
+			return 0
+		}
+		return float64(n)
+	})
+}
+
+// metricsMiddleware records request count and latency for every route
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		// Use the route template so IDs don't explode label cardinality
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+
+		httpRequestsTotal.WithLabelValues(route, c.Request.Method, status).Inc()
+		httpRequestDuration.WithLabelValues(route, c.Request.Method, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// metricsHandler serves the Prometheus scrape endpoint
+func metricsHandler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
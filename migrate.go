@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// migrationFS holds the versioned schema migrations, named NNNN_description.sql
+//
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// migrationLockName serializes migrations across instances starting together
+const migrationLockName = "album_store_migrations"
+
+// migration is one versioned schema change. down reverts it and is empty
+// when the migration has no NNNN_description.down.sql.
+type migration struct {
+	version    int
+	name       string
+	statements []string
+	down       []string
+}
+
+// downSuffix marks the file that reverts the migration of the same version
+const downSuffix = ".down.sql"
+
+// loadMigrations reads the embedded migrations in version order
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFS, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %v", err)
+	}
+
+	byVersion := make(map[int]*migration)
+	downs := make(map[int]string)
+	for _, e := range entries {
+		name := e.Name()
+		prefix, _, ok := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration filename %q", name)
+		}
+
+		body, err := migrationFS.ReadFile(path.Join("migrations", name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %q: %v", name, err)
+		}
+
+		if strings.HasSuffix(name, downSuffix) {
+			if other, dup := downs[version]; dup {
+				return nil, fmt.Errorf("down migrations %q and %q share version %d", other, name, version)
+			}
+			downs[version] = string(body)
+			continue
+		}
+		if other, dup := byVersion[version]; dup {
+			return nil, fmt.Errorf("migrations %q and %q share version %d", other.name, name, version)
+		}
+		byVersion[version] = &migration{
+			version:    version,
+			name:       name,
+			statements: splitStatements(string(body)),
+		}
+	}
+
+	for version, body := range downs {
+		m, ok := byVersion[version]
+		if !ok {
+			return nil, fmt.Errorf("down migration for version %d has no up migration", version)
+		}
+		m.down = splitStatements(body)
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations, nil
+}
+
+// splitStatements breaks a migration file into statements, since the driver
+// runs one statement per Exec. Statements must end with ";" at end of line.
+func splitStatements(body string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(body, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSpace(current.String()))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
+
+// migrator applies and reverts migrations over a single connection that
+// holds the migration lock
+type migrator struct {
+	conn       *sql.Conn
+	migrations []migration
+}
+
+// openMigrator loads the migrations, takes the migration lock and makes sure
+// schema_migrations exists. release gives up the lock and the connection.
+func openMigrator(ctx context.Context, db *sql.DB) (m *migrator, release func(), err error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// GET_LOCK is per connection, so hold one connection for the whole run
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get DB connection: %v", err)
+	}
+
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 60)", migrationLockName).Scan(&locked); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to acquire migration lock: %v", err)
+	}
+	if locked.Int64 != 1 {
+		conn.Close()
+		return nil, nil, fmt.Errorf("timed out waiting for migration lock")
+	}
+	release = func() {
+		conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", migrationLockName)
+		conn.Close()
+	}
+
+	// dirty marks a migration whose statements were started but never
+	// confirmed. MySQL commits DDL implicitly, so such a migration may be
+	// half applied and is never run again automatically.
+	_, err = conn.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		dirty BOOLEAN NOT NULL DEFAULT FALSE
+	) ENGINE=InnoDB`)
+	if err == nil {
+		// Tables created before dirty existed
+		_, err = conn.ExecContext(ctx, "ALTER TABLE schema_migrations ADD COLUMN dirty BOOLEAN NOT NULL DEFAULT FALSE")
+		if isAlreadyApplied(err) {
+			err = nil
+		}
+	}
+	if err != nil {
+		release()
+		return nil, nil, fmt.Errorf("failed to create schema_migrations table: %v", err)
+	}
+	return &migrator{conn: conn, migrations: migrations}, release, nil
+}
+
+// runMigrations applies every migration newer than the recorded version
+func runMigrations(ctx context.Context, db *sql.DB) error {
+	m, release, err := openMigrator(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return m.up(ctx)
+}
+
+// checkClean refuses to go on while a migration is marked dirty
+func (m *migrator) checkClean(ctx context.Context) error {
+	var version int
+	var name string
+	err := m.conn.QueryRowContext(ctx, "SELECT version, name FROM schema_migrations WHERE dirty LIMIT 1").Scan(&version, &name)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+	return fmt.Errorf("migration %s did not finish and may be partly applied; repair the schema by hand, then "+
+		"run UPDATE schema_migrations SET dirty = FALSE WHERE version = %d if it is fully applied, "+
+		"or DELETE FROM schema_migrations WHERE version = %d if it is fully reverted", name, version, version)
+}
+
+// up applies every migration newer than the recorded version. Each is
+// marked dirty before its first statement and clean after its last, so an
+// interrupted one stops later runs instead of being run again.
+func (m *migrator) up(ctx context.Context) error {
+	if err := m.checkClean(ctx); err != nil {
+		return err
+	}
+
+	var current int
+	if err := m.conn.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+
+	for _, mig := range m.migrations {
+		if mig.version <= current {
+			continue
+		}
+
+		if _, err := m.conn.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, dirty) VALUES (?, ?, TRUE)", mig.version, mig.name); err != nil {
+			return fmt.Errorf("failed to record migration %s: %v", mig.name, err)
+		}
+		for _, stmt := range mig.statements {
+			if _, err := m.conn.ExecContext(ctx, stmt); err != nil && !isAlreadyApplied(err) {
+				return fmt.Errorf("migration %s failed: %v", mig.name, err)
+			}
+		}
+		if _, err := m.conn.ExecContext(ctx, "UPDATE schema_migrations SET dirty = FALSE WHERE version = ?", mig.version); err != nil {
+			return fmt.Errorf("failed to record migration %s: %v", mig.name, err)
+		}
+		slog.Info("Applied migration", "version", mig.version, "name", mig.name)
+	}
+	return nil
+}
+
+// down reverts the steps most recently applied migrations, newest first.
+// It stops before touching anything if one of them has no down migration.
+func (m *migrator) down(ctx context.Context, steps int) error {
+	if err := m.checkClean(ctx); err != nil {
+		return err
+	}
+
+	rows, err := m.conn.QueryContext(ctx, "SELECT version FROM schema_migrations ORDER BY version DESC LIMIT ?", steps)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+	var versions []int
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read schema version: %v", err)
+		}
+		versions = append(versions, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+
+	byVersion := make(map[int]migration, len(m.migrations))
+	for _, mig := range m.migrations {
+		byVersion[mig.version] = mig
+	}
+	for _, v := range versions {
+		mig, ok := byVersion[v]
+		if !ok {
+			return fmt.Errorf("migration %d is applied but unknown to this build", v)
+		}
+		if len(mig.down) == 0 {
+			return fmt.Errorf("migration %s has no down migration", mig.name)
+		}
+	}
+
+	for _, v := range versions {
+		mig := byVersion[v]
+		if _, err := m.conn.ExecContext(ctx, "UPDATE schema_migrations SET dirty = TRUE WHERE version = ?", v); err != nil {
+			return fmt.Errorf("failed to record migration %s: %v", mig.name, err)
+		}
+		for _, stmt := range mig.down {
+			if _, err := m.conn.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("reverting migration %s failed: %v", mig.name, err)
+			}
+		}
+		if _, err := m.conn.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", v); err != nil {
+			return fmt.Errorf("failed to record migration %s: %v", mig.name, err)
+		}
+		slog.Info("Reverted migration", "version", mig.version, "name", mig.name)
+	}
+	return nil
+}
+
+// migrationStatus is one line of "migrate status"
+type migrationStatus struct {
+	migration
+	appliedAt *time.Time
+	dirty     bool
+}
+
+// status lists every known migration with when it was applied
+func (m *migrator) status(ctx context.Context) ([]migrationStatus, error) {
+	rows, err := m.conn.QueryContext(ctx, "SELECT version, applied_at, dirty FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema version: %v", err)
+	}
+	defer rows.Close()
+
+	type applied struct {
+		at    time.Time
+		dirty bool
+	}
+	seen := make(map[int]applied)
+	for rows.Next() {
+		var v int
+		var a applied
+		if err := rows.Scan(&v, &a.at, &a.dirty); err != nil {
+			return nil, fmt.Errorf("failed to read schema version: %v", err)
+		}
+		seen[v] = a
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read schema version: %v", err)
+	}
+
+	statuses := make([]migrationStatus, len(m.migrations))
+	for i, mig := range m.migrations {
+		statuses[i].migration = mig
+		if a, ok := seen[mig.version]; ok {
+			statuses[i].appliedAt, statuses[i].dirty = &a.at, a.dirty
+		}
+	}
+	return statuses, nil
+}
+
+// isDuplicateKey reports a unique index violation
+func isDuplicateKey(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 // ER_DUP_ENTRY
+}
+
+// isAlreadyApplied reports errors caused by a change that is already present.
+// Databases created before migrations existed got their columns from the old
+// inline CREATE TABLE, so adding those columns again is not a failure.
+func isAlreadyApplied(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return false
+	}
+	switch mysqlErr.Number {
+	case 1060, // ER_DUP_FIELDNAME
+		1061: // ER_DUP_KEYNAME
+		return true
+	}
+	return false
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"empty", "", nil},
+		{"comments only", "-- nothing here\n\n  -- still nothing\n", nil},
+		{"one statement", "ALTER TABLE albums ADD COLUMN x INT;\n", []string{"ALTER TABLE albums ADD COLUMN x INT;"}},
+		{
+			"multi-line statements",
+			"-- two changes\nCREATE TABLE t (\n  id INT\n);\n\nCREATE INDEX idx ON t (id);\n",
+			[]string{"CREATE TABLE t (\n  id INT\n);", "CREATE INDEX idx ON t (id);"},
+		},
+		{"comment inside a statement", "ALTER TABLE t\n  -- the new column\n  ADD COLUMN y INT;", []string{"ALTER TABLE t\n  ADD COLUMN y INT;"}},
+		{"no trailing semicolon", "DROP TABLE a;\nDROP TABLE b", []string{"DROP TABLE a;", "DROP TABLE b"}},
+		{"windows line endings", "DROP TABLE a;\r\nDROP TABLE b;\r\n", []string{"DROP TABLE a;", "DROP TABLE b;"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitStatements(tt.body); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitStatements = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatalf("loadMigrations: %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	for i, m := range migrations {
+		// Versions start at 1 and leave no gaps
+		if m.version != i+1 {
+			t.Errorf("migration %d is %s, version %d", i, m.name, m.version)
+		}
+		if strings.HasSuffix(m.name, downSuffix) {
+			t.Errorf("%s loaded as an up migration", m.name)
+		}
+		if len(m.statements) == 0 {
+			t.Errorf("%s has no statements", m.name)
+		}
+		if len(m.down) == 0 {
+			t.Errorf("%s has no down migration", m.name)
+		}
+	}
+}
+
+func TestIsAlreadyApplied(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("Duplicate column name 'x'"), false},
+		{&mysql.MySQLError{Number: 1060}, true},
+		{&mysql.MySQLError{Number: 1061}, true},
+		{fmt.Errorf("exec: %w", &mysql.MySQLError{Number: 1060}), true},
+		{&mysql.MySQLError{Number: 1062}, false},
+		{&mysql.MySQLError{Number: 1146}, false},
+	}
+	for _, tt := range tests {
+		if got := isAlreadyApplied(tt.err); got != tt.want {
+			t.Errorf("isAlreadyApplied(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+// testMigrations are two reversible migrations followed by one that is not
+var testMigrations = []migration{
+	{version: 1, name: "0001_create_a.sql", statements: []string{"CREATE TABLE a (id INT);"}, down: []string{"DROP TABLE a;"}},
+	{version: 2, name: "0002_add_b.sql", statements: []string{"ALTER TABLE a ADD COLUMN b INT;", "CREATE INDEX idx_b ON a (b);"}, down: []string{"ALTER TABLE a DROP COLUMN b;"}},
+	{version: 3, name: "0003_backfill.sql", statements: []string{"UPDATE a SET b = 0;"}},
+}
+
+// newTestMigrator returns a migrator over testMigrations on a mock DB
+// connection, with the dirty check already expected to pass
+func newTestMigrator(t *testing.T) (*migrator, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		db.Close()
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+	return &migrator{conn: conn, migrations: testMigrations}, mock
+}
+
+func expectClean(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT version, name FROM schema_migrations WHERE dirty LIMIT 1")).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "name"}))
+}
+
+func TestMigratorUp(t *testing.T) {
+	m, mock := newTestMigrator(t)
+	expectClean(mock)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0) FROM schema_migrations")).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(1))
+
+	// Version 1 is applied, so 2 and 3 run, each marked dirty until done.
+	// A column left behind by the old inline schema does not fail the run.
+	for _, mig := range testMigrations[1:] {
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO schema_migrations (version, name, dirty) VALUES (?, ?, TRUE)")).
+			WithArgs(mig.version, mig.name).WillReturnResult(sqlmock.NewResult(0, 1))
+		for i, stmt := range mig.statements {
+			e := mock.ExpectExec(regexp.QuoteMeta(stmt))
+			if mig.version == 2 && i == 0 {
+				e.WillReturnError(&mysql.MySQLError{Number: 1060, Message: "Duplicate column name 'b'"})
+			} else {
+				e.WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+		}
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE schema_migrations SET dirty = FALSE WHERE version = ?")).
+			WithArgs(mig.version).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	if err := m.up(context.Background()); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+}
+
+func TestMigratorUpFailureLeavesDirty(t *testing.T) {
+	m, mock := newTestMigrator(t)
+	expectClean(mock)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0) FROM schema_migrations")).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO schema_migrations")).
+		WithArgs(2, "0002_add_b.sql").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE a ADD COLUMN b INT;")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("CREATE INDEX idx_b ON a (b);")).
+		WillReturnError(&mysql.MySQLError{Number: 1072, Message: "Key column 'b' doesn't exist in table"})
+
+	// Neither the clean mark nor migration 3 may follow the failure
+	err := m.up(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "migration 0002_add_b.sql failed") {
+		t.Fatalf("up err = %v, want migration 0002_add_b.sql to fail", err)
+	}
+}
+
+func TestMigratorRefusesDirty(t *testing.T) {
+	for _, run := range []struct {
+		name string
+		fn   func(*migrator) error
+	}{
+		{"up", func(m *migrator) error { return m.up(context.Background()) }},
+		{"down", func(m *migrator) error { return m.down(context.Background(), 1) }},
+	} {
+		t.Run(run.name, func(t *testing.T) {
+			m, mock := newTestMigrator(t)
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT version, name FROM schema_migrations WHERE dirty LIMIT 1")).
+				WillReturnRows(sqlmock.NewRows([]string{"version", "name"}).AddRow(2, "0002_add_b.sql"))
+
+			err := run.fn(m)
+			if err == nil || !strings.Contains(err.Error(), "migration 0002_add_b.sql did not finish") ||
+				!strings.Contains(err.Error(), "WHERE version = 2") {
+				t.Errorf("err = %v, want the dirty migration reported with its repair", err)
+			}
+		})
+	}
+}
+
+func TestMigratorDown(t *testing.T) {
+	m, mock := newTestMigrator(t)
+	expectClean(mock)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT version FROM schema_migrations ORDER BY version DESC LIMIT ?")).
+		WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2).AddRow(1))
+	for _, mig := range []migration{testMigrations[1], testMigrations[0]} {
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE schema_migrations SET dirty = TRUE WHERE version = ?")).
+			WithArgs(mig.version).WillReturnResult(sqlmock.NewResult(0, 1))
+		for _, stmt := range mig.down {
+			mock.ExpectExec(regexp.QuoteMeta(stmt)).WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM schema_migrations WHERE version = ?")).
+			WithArgs(mig.version).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	if err := m.down(context.Background(), 2); err != nil {
+		t.Fatalf("down: %v", err)
+	}
+}
+
+// TestMigratorDownChecksFirst checks that down refuses before reverting
+// anything when one of the steps cannot be reverted
+func TestMigratorDownChecksFirst(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions []int
+		wantErr  string
+	}{
+		{"no down migration", []int{3, 2}, "migration 0003_backfill.sql has no down migration"},
+		{"unknown version", []int{4, 3}, "migration 4 is applied but unknown to this build"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, mock := newTestMigrator(t)
+			expectClean(mock)
+			rows := sqlmock.NewRows([]string{"version"})
+			for _, v := range tt.versions {
+				rows.AddRow(v)
+			}
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT version FROM schema_migrations ORDER BY version DESC LIMIT ?")).
+				WithArgs(len(tt.versions)).WillReturnRows(rows)
+
+			err := m.down(context.Background(), len(tt.versions))
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("down err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMigratorStatus(t *testing.T) {
+	m, mock := newTestMigrator(t)
+	applied := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT version, applied_at, dirty FROM schema_migrations")).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at", "dirty"}).
+			AddRow(1, applied, false).
+			AddRow(2, applied, true))
+
+	statuses, err := m.status(context.Background())
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if len(statuses) != len(testMigrations) {
+		t.Fatalf("%d statuses, want %d", len(statuses), len(testMigrations))
+	}
+	for i, want := range []struct {
+		applied bool
+		dirty   bool
+	}{{true, false}, {true, true}, {false, false}} {
+		st := statuses[i]
+		if st.version != testMigrations[i].version || (st.appliedAt != nil) != want.applied || st.dirty != want.dirty {
+			t.Errorf("status %d = version %d applied %v dirty %v, want applied %v dirty %v",
+				i, st.version, st.appliedAt, st.dirty, want.applied, want.dirty)
+		}
+		if st.appliedAt != nil && !st.appliedAt.Equal(applied) {
+			t.Errorf("status %d applied at %v, want %v", i, st.appliedAt, applied)
+		}
+	}
+}
+
+func TestRunMigrateCommandArgs(t *testing.T) {
+	tests := []struct {
+		args    []string
+		wantErr string
+	}{
+		{nil, migrateUsage},
+		{[]string{"up", "2"}, migrateUsage},
+		{[]string{"status", "now"}, migrateUsage},
+		{[]string{"down", "1", "2"}, migrateUsage},
+		{[]string{"down", "0"}, `steps must be a positive integer, got "0"`},
+		{[]string{"down", "-1"}, `steps must be a positive integer, got "-1"`},
+		{[]string{"down", "two"}, `steps must be a positive integer, got "two"`},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			// Bad arguments are refused before any DB connection is made
+			err := runMigrateCommand(Config{}, tt.args)
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
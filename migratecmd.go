@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// migrateUsage describes the migrate subcommand
+const migrateUsage = "usage: album-store-server migrate up | down [steps] | status"
+
+// runMigrateCommand implements "migrate up", "migrate down [steps]" (one
+// step by default) and "migrate status" with the same configuration and DB
+// connection as the server, without starting it
+func runMigrateCommand(cfg Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	steps := 1
+	switch {
+	case args[0] == "down" && len(args) == 2:
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("steps must be a positive integer, got %q", args[1])
+		}
+		steps = n
+	case len(args) != 1:
+		return errors.New(migrateUsage)
+	}
+
+	db, err := openDB(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to DB: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	m, release, err := openMigrator(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	switch args[0] {
+	case "up":
+		return m.up(ctx)
+	case "down":
+		return m.down(ctx, steps)
+	case "status":
+		statuses, err := m.status(ctx)
+		if err != nil {
+			return err
+		}
+		printMigrationStatus(statuses)
+		return nil
+	}
+	return errors.New(migrateUsage)
+}
+
+// printMigrationStatus writes one row per migration to stdout
+func printMigrationStatus(statuses []migrationStatus) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tAPPLIED AT\tDOWN")
+	for _, st := range statuses {
+		state, appliedAt := "pending", "-"
+		if st.appliedAt != nil {
+			state, appliedAt = "applied", st.appliedAt.UTC().Format(time.RFC3339)
+		}
+		if st.dirty {
+			state = "dirty"
+		}
+		down := "no"
+		if len(st.down) > 0 {
+			down = "yes"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", st.version, st.name, state, appliedAt, down)
+	}
+	w.Flush()
+}
@@ -0,0 +1,2 @@
+-- Drops every album; only for tearing down a fresh install
+DROP TABLE IF EXISTS albums;
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS albums (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	image_url VARCHAR(255),
+	metadata JSON
+) ENGINE=InnoDB;
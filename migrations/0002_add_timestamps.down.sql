@@ -0,0 +1,3 @@
+ALTER TABLE albums
+	DROP COLUMN created_at,
+	DROP COLUMN updated_at;
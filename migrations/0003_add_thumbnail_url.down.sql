@@ -0,0 +1,2 @@
+-- Thumbnail files stay in storage; DELETE /admin/orphaned-files reclaims them
+ALTER TABLE albums DROP COLUMN thumbnail_url;
@@ -0,0 +1 @@
+ALTER TABLE albums ADD COLUMN thumbnail_url VARCHAR(255) AFTER image_url;
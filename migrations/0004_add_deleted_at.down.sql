@@ -0,0 +1,2 @@
+-- Soft-deleted albums become live again
+ALTER TABLE albums DROP COLUMN deleted_at;
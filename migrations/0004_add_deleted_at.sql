@@ -0,0 +1 @@
+ALTER TABLE albums ADD COLUMN deleted_at TIMESTAMP NULL;
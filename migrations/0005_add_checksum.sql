@@ -0,0 +1 @@
+ALTER TABLE albums ADD COLUMN checksum CHAR(64) NULL AFTER thumbnail_url;
@@ -0,0 +1,2 @@
+DROP INDEX idx_albums_dedup_key ON albums;
+ALTER TABLE albums DROP COLUMN dedup_key;
@@ -0,0 +1,4 @@
+-- dedup_key mirrors checksum only for albums uploaded with deduplication on,
+-- so the unique index doesn't block duplicates when DEDUP_UPLOADS=false
+ALTER TABLE albums ADD COLUMN dedup_key CHAR(64) NULL AFTER checksum;
+CREATE UNIQUE INDEX idx_albums_dedup_key ON albums (dedup_key);
@@ -0,0 +1,2 @@
+-- Unconfirmed direct uploads are forgotten; their objects become orphans
+DROP TABLE IF EXISTS pending_uploads;
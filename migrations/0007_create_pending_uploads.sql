@@ -0,0 +1,10 @@
+-- Direct uploads handed out by POST /albums/upload-url that have not been
+-- confirmed yet; rows past expires_at are swept along with their objects
+CREATE TABLE IF NOT EXISTS pending_uploads (
+	id CHAR(36) PRIMARY KEY,
+	object_key VARCHAR(255) NOT NULL,
+	content_type VARCHAR(64) NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP NOT NULL,
+	INDEX idx_pending_uploads_expires_at (expires_at)
+) ENGINE=InnoDB;
@@ -0,0 +1,2 @@
+-- version is bumped on every metadata update so PUT can reject stale writes
+ALTER TABLE albums ADD COLUMN version INT NOT NULL DEFAULT 1 AFTER metadata;
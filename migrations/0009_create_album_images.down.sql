@@ -0,0 +1,3 @@
+-- albums.image_url still holds each album's primary image; the others are
+-- lost and their files become orphans
+DROP TABLE IF EXISTS album_images;
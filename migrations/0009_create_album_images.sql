@@ -0,0 +1,16 @@
+-- An album can hold several images (front cover, back cover, sleeve...).
+-- albums.image_url keeps mirroring the lowest-positioned one.
+CREATE TABLE IF NOT EXISTS album_images (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	album_id INT NOT NULL,
+	image_url VARCHAR(255) NOT NULL,
+	checksum CHAR(64) NULL,
+	position INT NOT NULL DEFAULT 0,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	INDEX idx_album_images_album (album_id, position),
+	CONSTRAINT fk_album_images_album FOREIGN KEY (album_id) REFERENCES albums (id) ON DELETE CASCADE
+) ENGINE=InnoDB;
+
+-- Every existing album's image becomes its primary image
+INSERT INTO album_images (album_id, image_url, checksum, position)
+	SELECT id, image_url, checksum, 0 FROM albums WHERE image_url IS NOT NULL AND image_url <> '';
@@ -0,0 +1,8 @@
+ALTER TABLE album_images
+	DROP COLUMN width,
+	DROP COLUMN height,
+	DROP COLUMN size_bytes;
+ALTER TABLE albums
+	DROP COLUMN width,
+	DROP COLUMN height,
+	DROP COLUMN size_bytes;
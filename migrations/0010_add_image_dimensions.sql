@@ -0,0 +1,10 @@
+-- Dimensions and byte size of the stored image; NULL when the image could
+-- not be decoded or was uploaded before these were recorded
+ALTER TABLE albums
+	ADD COLUMN width INT NULL AFTER checksum,
+	ADD COLUMN height INT NULL AFTER width,
+	ADD COLUMN size_bytes BIGINT NULL AFTER height;
+ALTER TABLE album_images
+	ADD COLUMN width INT NULL AFTER checksum,
+	ADD COLUMN height INT NULL AFTER width,
+	ADD COLUMN size_bytes BIGINT NULL AFTER height;
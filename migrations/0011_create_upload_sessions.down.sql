@@ -0,0 +1,2 @@
+-- Open resumable uploads are abandoned; remove UPLOAD_SESSION_DIR by hand
+DROP TABLE IF EXISTS upload_sessions;
@@ -0,0 +1,11 @@
+-- Resumable uploads opened by POST /albums/resumable; received_bytes of
+-- size_bytes have been staged on disk, and idle rows past expires_at are
+-- swept with their staged data
+CREATE TABLE IF NOT EXISTS upload_sessions (
+	id CHAR(36) PRIMARY KEY,
+	size_bytes BIGINT NOT NULL,
+	received_bytes BIGINT NOT NULL DEFAULT 0,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP NOT NULL,
+	INDEX idx_upload_sessions_expires_at (expires_at)
+) ENGINE=InnoDB;
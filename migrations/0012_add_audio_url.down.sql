@@ -0,0 +1,2 @@
+-- Audio files stay in storage; DELETE /admin/orphaned-files reclaims them
+ALTER TABLE albums DROP COLUMN audio_url;
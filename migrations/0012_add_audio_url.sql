@@ -0,0 +1,2 @@
+-- Optional MP3 preview clip served by GET /albums/{albumID}/audio
+ALTER TABLE albums ADD COLUMN audio_url VARCHAR(255) NULL AFTER thumbnail_url;
@@ -0,0 +1,7 @@
+DROP INDEX idx_albums_artist_search ON albums;
+DROP INDEX idx_albums_title_search ON albums;
+DROP INDEX idx_albums_year_num ON albums;
+ALTER TABLE albums
+	DROP COLUMN artist_search,
+	DROP COLUMN title_search,
+	DROP COLUMN year_num;
@@ -0,0 +1,23 @@
+-- Indexed copies of the searchable metadata fields, so filters and sorts on
+-- them stop evaluating JSON for every row. artist_search and title_search
+-- are lowercased with a binary collation, which keeps the case-insensitive
+-- but accent-sensitive matching and ordering of LOWER(JSON_UNQUOTE(...)).
+-- year_num is NULL unless the year is four digits, so a blank year can't
+-- fail the insert under strict mode.
+--
+-- Expected EXPLAIN change for the list queries (check against real data):
+--   ?yearFrom=1970&yearTo=1979   type ALL            -> type range on idx_albums_year_num
+--   ?sort=artist&limit=20        type ALL + filesort -> type index on idx_albums_artist_search
+--   ?sort=year&limit=20          type ALL + filesort -> type index on idx_albums_year_num
+-- A substring ?artist= or ?title= match (LIKE '%x%') can't seek an index and
+-- still reads every row, but compares one column instead of parsing JSON.
+ALTER TABLE albums
+	ADD COLUMN artist_search VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin
+		GENERATED ALWAYS AS (LOWER(JSON_UNQUOTE(JSON_EXTRACT(metadata, '$.artist')))) VIRTUAL,
+	ADD COLUMN title_search VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin
+		GENERATED ALWAYS AS (LOWER(JSON_UNQUOTE(JSON_EXTRACT(metadata, '$.title')))) VIRTUAL,
+	ADD COLUMN year_num SMALLINT UNSIGNED
+		GENERATED ALWAYS AS (IF(JSON_UNQUOTE(JSON_EXTRACT(metadata, '$.year')) REGEXP '^[0-9]{4}$', CAST(JSON_UNQUOTE(JSON_EXTRACT(metadata, '$.year')) AS UNSIGNED), NULL)) VIRTUAL;
+CREATE INDEX idx_albums_artist_search ON albums (artist_search);
+CREATE INDEX idx_albums_title_search ON albums (title_search);
+CREATE INDEX idx_albums_year_num ON albums (year_num);
@@ -0,0 +1,8 @@
+-- Idempotency-Key values sent with POST /albums. album_id stays NULL while
+-- the first request is running and is set once it has created the album.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	idempotency_key VARCHAR(255) PRIMARY KEY,
+	album_id INT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	INDEX idx_idempotency_keys_created_at (created_at)
+) ENGINE=InnoDB;
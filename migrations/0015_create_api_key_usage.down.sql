@@ -0,0 +1,2 @@
+-- Recorded upload usage is lost, so every key starts its quota afresh
+DROP TABLE IF EXISTS api_key_usage;
@@ -0,0 +1,7 @@
+-- Bytes uploaded per API key, charged in the same transaction as each
+-- upload. Keys are stored as their SHA-256 hex digest, never in the clear.
+CREATE TABLE IF NOT EXISTS api_key_usage (
+	key_hash CHAR(64) PRIMARY KEY,
+	uploaded_bytes BIGINT NOT NULL DEFAULT 0,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB;
@@ -0,0 +1,18 @@
+-- Copy the typed values back into the metadata JSON first, since albums
+-- written with METADATA_STORAGE=normalized only have them in the columns
+UPDATE albums SET metadata = JSON_SET(COALESCE(metadata, JSON_OBJECT()), '$.artist', artist) WHERE artist IS NOT NULL;
+UPDATE albums SET metadata = JSON_SET(COALESCE(metadata, JSON_OBJECT()), '$.title', title) WHERE title IS NOT NULL;
+UPDATE albums SET metadata = JSON_SET(COALESCE(metadata, JSON_OBJECT()), '$.year', CAST(year AS CHAR)) WHERE year IS NOT NULL;
+-- Restore the search columns of migration 0013, which must stop referring
+-- to the typed columns before those can be dropped
+ALTER TABLE albums
+	MODIFY COLUMN artist_search VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin
+		GENERATED ALWAYS AS (LOWER(JSON_UNQUOTE(JSON_EXTRACT(metadata, '$.artist')))) VIRTUAL,
+	MODIFY COLUMN title_search VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin
+		GENERATED ALWAYS AS (LOWER(JSON_UNQUOTE(JSON_EXTRACT(metadata, '$.title')))) VIRTUAL,
+	MODIFY COLUMN year_num SMALLINT UNSIGNED
+		GENERATED ALWAYS AS (IF(JSON_UNQUOTE(JSON_EXTRACT(metadata, '$.year')) REGEXP '^[0-9]{4}$', CAST(JSON_UNQUOTE(JSON_EXTRACT(metadata, '$.year')) AS UNSIGNED), NULL)) VIRTUAL;
+ALTER TABLE albums
+	DROP COLUMN artist,
+	DROP COLUMN title,
+	DROP COLUMN year;
@@ -0,0 +1,25 @@
+-- Typed artist, title and year columns for METADATA_STORAGE=normalized.
+-- When not NULL they take precedence over the same keys in the metadata
+-- JSON, which json mode keeps authoritative by writing NULL here.
+ALTER TABLE albums
+	ADD COLUMN artist VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_0900_ai_ci NULL,
+	ADD COLUMN title VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_0900_ai_ci NULL,
+	ADD COLUMN year SMALLINT UNSIGNED NULL;
+-- Populate them from the JSON, which is left intact. Values the columns
+-- can't hold, such as a year like "1970s", stay NULL and keep being read
+-- from the JSON.
+UPDATE albums SET
+	artist = IF(JSON_TYPE(JSON_EXTRACT(metadata, '$.artist')) = 'STRING' AND CHAR_LENGTH(JSON_UNQUOTE(JSON_EXTRACT(metadata, '$.artist'))) <= 255,
+		JSON_UNQUOTE(JSON_EXTRACT(metadata, '$.artist')), NULL),
+	title = IF(JSON_TYPE(JSON_EXTRACT(metadata, '$.title')) = 'STRING' AND CHAR_LENGTH(JSON_UNQUOTE(JSON_EXTRACT(metadata, '$.title'))) <= 255,
+		JSON_UNQUOTE(JSON_EXTRACT(metadata, '$.title')), NULL),
+	year = year_num;
+-- Point the search columns of migration 0013 at the typed columns first, so
+-- filters and sorts keep working for albums written in either mode
+ALTER TABLE albums
+	MODIFY COLUMN artist_search VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin
+		GENERATED ALWAYS AS (LOWER(COALESCE(artist, JSON_UNQUOTE(JSON_EXTRACT(metadata, '$.artist')) COLLATE utf8mb4_bin))) VIRTUAL,
+	MODIFY COLUMN title_search VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin
+		GENERATED ALWAYS AS (LOWER(COALESCE(title, JSON_UNQUOTE(JSON_EXTRACT(metadata, '$.title')) COLLATE utf8mb4_bin))) VIRTUAL,
+	MODIFY COLUMN year_num SMALLINT UNSIGNED
+		GENERATED ALWAYS AS (COALESCE(year, IF(JSON_UNQUOTE(JSON_EXTRACT(metadata, '$.year')) REGEXP '^[0-9]{4}$', CAST(JSON_UNQUOTE(JSON_EXTRACT(metadata, '$.year')) AS UNSIGNED), NULL))) VIRTUAL;
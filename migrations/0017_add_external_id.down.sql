@@ -0,0 +1,2 @@
+DROP INDEX idx_albums_external_id ON albums;
+ALTER TABLE albums DROP COLUMN external_id;
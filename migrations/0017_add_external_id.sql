@@ -0,0 +1,4 @@
+-- external_id is an importer's own ID for the album; POST /albums returns
+-- the existing album instead of creating a second one with the same ID
+ALTER TABLE albums ADD COLUMN external_id VARCHAR(255) NULL AFTER id;
+CREATE UNIQUE INDEX idx_albums_external_id ON albums (external_id);
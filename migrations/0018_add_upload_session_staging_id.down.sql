@@ -0,0 +1,2 @@
+DROP INDEX idx_upload_sessions_staging_id ON upload_sessions;
+ALTER TABLE upload_sessions DROP COLUMN staging_id;
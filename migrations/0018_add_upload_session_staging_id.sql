@@ -0,0 +1,5 @@
+-- staging_id names the UPLOAD_SESSION_DIR a session is staged in, so only
+-- instances sharing that directory sweep the session with its staged data.
+-- Sessions from before it have none and are swept once long abandoned.
+ALTER TABLE upload_sessions ADD COLUMN staging_id CHAR(36) NULL AFTER id;
+CREATE INDEX idx_upload_sessions_staging_id ON upload_sessions (staging_id, expires_at);
@@ -0,0 +1,8 @@
+-- Several callers may hold the same key by now, which the old primary key
+-- can't take; keys only live for IDEMPOTENCY_KEY_TTL, so drop them all
+DELETE FROM idempotency_keys;
+ALTER TABLE idempotency_keys
+	DROP PRIMARY KEY,
+	DROP COLUMN caller,
+	DROP COLUMN request_hash,
+	ADD PRIMARY KEY (idempotency_key);
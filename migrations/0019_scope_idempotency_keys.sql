@@ -0,0 +1,10 @@
+-- caller scopes each Idempotency-Key to the API key that sent it, by the
+-- hash api_key_usage uses ('' for requests not authenticated by API key),
+-- so two clients picking the same key can't replay each other's albums.
+-- request_hash fingerprints the request that claimed the key, so the key
+-- can't be reused for a different one; keys stored before it have none.
+ALTER TABLE idempotency_keys
+	ADD COLUMN caller CHAR(64) NOT NULL DEFAULT '' FIRST,
+	ADD COLUMN request_hash CHAR(64) NULL AFTER album_id,
+	DROP PRIMARY KEY,
+	ADD PRIMARY KEY (caller, idempotency_key);
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// negotiateFormat picks the response format of an album read from the
+// Accept header: JSON unless the client asks for XML. It responds with 406
+// and returns false when neither is acceptable. As elsewhere in gin, q-values
+// are ignored and the first listed type that matches wins. Error bodies stay
+// JSON.
+func negotiateFormat(c *gin.Context) (string, bool) {
+	// The body depends on Accept, so caches must key on it; Add keeps the
+	// Vary values set by CORS
+	c.Writer.Header().Add("Vary", "Accept")
+
+	format := c.NegotiateFormat(binding.MIMEJSON, binding.MIMEXML, binding.MIMEXML2)
+	if format == "" {
+		respondError(c, http.StatusNotAcceptable, ErrCodeNotAcceptable, "Responses are available as application/json or application/xml")
+		return "", false
+	}
+	return format, true
+}
+
+// render writes obj with the format chosen by negotiateFormat
+func render(c *gin.Context, status int, format string, obj any) {
+	if format == binding.MIMEJSON {
+		c.JSON(status, obj)
+		return
+	}
+	c.XML(status, obj)
+}
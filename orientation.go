@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+)
+
+// exifOrientationTag is the TIFF tag holding the EXIF orientation (1-8)
+const exifOrientationTag = 0x0112
+
+// autoOrientJPEG applies the EXIF orientation of JPEG data to its pixels and
+// returns the re-encoded image, which carries no EXIF, so viewers that ignore
+// the tag and viewers that honour it show the same thing. Data that is
+// already upright, or has no readable orientation, is returned unchanged.
+// Images of more than maxPixels are refused with errImageTooLarge.
+func autoOrientJPEG(ctx context.Context, data []byte, maxPixels int64) ([]byte, error) {
+	orientation := jpegOrientation(data)
+	if orientation <= 1 || orientation > 8 {
+		return data, nil
+	}
+
+	if _, err := checkImagePixels(data, maxPixels); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errMalformedImage
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, applyOrientation(img, orientation), &jpeg.Options{Quality: 92}); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// jpegOrientation returns the orientation from the EXIF APP1 segment of a
+// JPEG, or 0 when there is none
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 0
+	}
+
+	// Walk the segments before the start of scan looking for APP1 Exif
+	i := 2
+	for i+4 <= len(data) && data[i] == 0xFF {
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			return 0
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return 0
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 0
+}
+
+// tiffOrientation reads the orientation entry of IFD0 in a TIFF structure
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return 0
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < entries; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		// Orientation is a single SHORT stored inline in the value field
+		if order.Uint16(tiff[entry:]) == exifOrientationTag && order.Uint16(tiff[entry+2:]) == 3 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 0
+}
+
+// applyOrientation returns img transformed so that an image tagged with
+// orientation displays upright. Orientations 5-8 swap width and height.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	// src maps a destination pixel back to the source pixel it shows
+	var src func(x, y int) (int, int)
+	dw, dh := w, h
+	switch orientation {
+	case 2: // mirrored horizontally
+		src = func(x, y int) (int, int) { return w - 1 - x, y }
+	case 3: // rotated 180°
+		src = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+	case 4: // mirrored vertically
+		src = func(x, y int) (int, int) { return x, h - 1 - y }
+	case 5: // mirrored along the top-left to bottom-right diagonal
+		src = func(x, y int) (int, int) { return y, x }
+	case 6: // needs a 90° clockwise turn
+		src = func(x, y int) (int, int) { return y, h - 1 - x }
+	case 7: // mirrored along the top-right to bottom-left diagonal
+		src = func(x, y int) (int, int) { return w - 1 - y, h - 1 - x }
+	case 8: // needs a 90° counter-clockwise turn
+		src = func(x, y int) (int, int) { return w - 1 - y, x }
+	default:
+		return img
+	}
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := src(x, y)
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
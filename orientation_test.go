@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+func TestJPEGOrientation(t *testing.T) {
+	littleEndian := []byte("II\x2a\x00\x08\x00\x00\x00\x01\x00\x12\x01\x03\x00\x01\x00\x00\x00\x03\x00\x00\x00\x00\x00\x00\x00")
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"rotated", testJPEG(t, 2, 2, exifSegment(6)), 6},
+		{"upright", testJPEG(t, 2, 2, exifSegment(1)), 1},
+		{"after other segments", testJPEG(t, 2, 2, jpegSegment(0xE2, []byte("ICC_PROFILE\x00")), exifSegment(8)), 8},
+		{"little endian", testJPEG(t, 2, 2, jpegSegment(0xE1, append([]byte("Exif\x00\x00"), littleEndian...))), 3},
+		{"no EXIF", testJPEG(t, 2, 2), 0},
+		{"XMP rather than EXIF", testJPEG(t, 2, 2, jpegSegment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00"))), 0},
+		{"truncated TIFF", testJPEG(t, 2, 2, jpegSegment(0xE1, []byte("Exif\x00\x00MM\x00\x2a"))), 0},
+		{"not a JPEG", []byte("\x89PNG\r\n\x1a\n"), 0},
+	}
+	for _, tt := range tests {
+		if got := jpegOrientation(tt.data); got != tt.want {
+			t.Errorf("%s: jpegOrientation = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// A 2x1 image, red then green, and where each orientation must put them
+	red, green := color.RGBA{R: 255, A: 255}, color.RGBA{G: 255, A: 255}
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, red)
+	src.Set(1, 0, green)
+
+	tests := []struct {
+		orientation int
+		w, h        int
+		want        []color.RGBA // in row order
+	}{
+		{1, 2, 1, []color.RGBA{red, green}},
+		{2, 2, 1, []color.RGBA{green, red}},
+		{3, 2, 1, []color.RGBA{green, red}},
+		{4, 2, 1, []color.RGBA{red, green}},
+		{5, 1, 2, []color.RGBA{red, green}},
+		{6, 1, 2, []color.RGBA{red, green}},
+		{7, 1, 2, []color.RGBA{green, red}},
+		{8, 1, 2, []color.RGBA{green, red}},
+	}
+	for _, tt := range tests {
+		got := applyOrientation(src, tt.orientation)
+		if b := got.Bounds(); b.Dx() != tt.w || b.Dy() != tt.h {
+			t.Errorf("orientation %d: %dx%d, want %dx%d", tt.orientation, b.Dx(), b.Dy(), tt.w, tt.h)
+			continue
+		}
+		i := 0
+		for y := 0; y < tt.h; y++ {
+			for x := 0; x < tt.w; x++ {
+				if c := color.RGBAModel.Convert(got.At(x, y)); c != tt.want[i] {
+					t.Errorf("orientation %d: pixel (%d,%d) = %v, want %v", tt.orientation, x, y, c, tt.want[i])
+				}
+				i++
+			}
+		}
+	}
+}
+
+func TestAutoOrientJPEG(t *testing.T) {
+	rotated := testJPEG(t, 4, 2, exifSegment(6))
+	out, err := autoOrientJPEG(context.Background(), rotated, 1_000_000)
+	if err != nil {
+		t.Fatalf("autoOrientJPEG: %v", err)
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(out))
+	if err != nil || cfg.Width != 2 || cfg.Height != 4 {
+		t.Errorf("oriented image = %dx%d, %v; want 2x4", cfg.Width, cfg.Height, err)
+	}
+	if jpegOrientation(out) != 0 || bytes.Contains(out, []byte("Exif\x00\x00")) {
+		t.Error("oriented image still carries the orientation tag")
+	}
+
+	upright := testJPEG(t, 4, 2, exifSegment(1))
+	if out, err := autoOrientJPEG(context.Background(), upright, 1_000_000); err != nil || !bytes.Equal(out, upright) {
+		t.Errorf("upright image changed: %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setPaginationHeaders mirrors the list envelope in headers for generic HTTP
+// clients: X-Total-Count carries the total and Link (RFC 8288) the first,
+// prev, next and last pages by limit and offset. Links keep the request's
+// other query parameters. A cursor page only links first and, while more
+// rows may follow, next.
+func (s *Server) setPaginationHeaders(c *gin.Context, list AlbumList, cursor bool) {
+	c.Header("X-Total-Count", strconv.Itoa(list.Total))
+	if list.Limit == 0 {
+		return
+	}
+
+	pageLink := func(rel string, set func(q url.Values)) string {
+		q := c.Request.URL.Query()
+		q.Del("cursor")
+		q.Del("offset")
+		q.Set("limit", strconv.Itoa(list.Limit))
+		set(q)
+		return fmt.Sprintf(`<%s%s?%s>; rel="%s"`, s.publicBaseURL, c.Request.URL.Path, q.Encode(), rel)
+	}
+	atOffset := func(offset int) func(url.Values) {
+		return func(q url.Values) {
+			if offset > 0 {
+				q.Set("offset", strconv.Itoa(offset))
+			}
+		}
+	}
+
+	links := []string{pageLink("first", atOffset(0))}
+	if cursor {
+		if list.NextCursor != "" {
+			links = append(links, pageLink("next", func(q url.Values) { q.Set("cursor", list.NextCursor) }))
+		}
+	} else {
+		if list.Offset > 0 {
+			links = append(links, pageLink("prev", atOffset(max(list.Offset-list.Limit, 0))))
+		}
+		if list.Offset+list.Limit < list.Total {
+			links = append(links, pageLink("next", atOffset(list.Offset+list.Limit)))
+		}
+		last := 0
+		if list.Total > 0 {
+			last = (list.Total - 1) / list.Limit * list.Limit
+		}
+		links = append(links, pageLink("last", atOffset(last)))
+	}
+	c.Header("Link", strings.Join(links, ", "))
+}
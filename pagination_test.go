@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSetPaginationHeaders(t *testing.T) {
+	tests := []struct {
+		name      string
+		baseURL   string
+		target    string
+		list      AlbumList
+		cursor    bool
+		wantTotal string
+		wantLink  string
+	}{
+		{
+			name:      "middle page",
+			target:    "/albums?offset=20",
+			list:      AlbumList{Total: 95, Limit: 20, Offset: 20},
+			wantTotal: "95",
+			wantLink: `</albums?limit=20>; rel="first", </albums?limit=20>; rel="prev", ` +
+				`</albums?limit=20&offset=40>; rel="next", </albums?limit=20&offset=80>; rel="last"`,
+		},
+		{
+			name:      "first page",
+			target:    "/albums",
+			list:      AlbumList{Total: 45, Limit: 20},
+			wantTotal: "45",
+			wantLink:  `</albums?limit=20>; rel="first", </albums?limit=20&offset=20>; rel="next", </albums?limit=20&offset=40>; rel="last"`,
+		},
+		{
+			name:      "last page",
+			target:    "/albums?offset=40",
+			list:      AlbumList{Total: 45, Limit: 20, Offset: 40},
+			wantTotal: "45",
+			wantLink:  `</albums?limit=20>; rel="first", </albums?limit=20&offset=20>; rel="prev", </albums?limit=20&offset=40>; rel="last"`,
+		},
+		{
+			name:      "prev clamps to zero",
+			target:    "/albums?offset=5",
+			list:      AlbumList{Total: 30, Limit: 10, Offset: 5},
+			wantTotal: "30",
+			wantLink: `</albums?limit=10>; rel="first", </albums?limit=10>; rel="prev", ` +
+				`</albums?limit=10&offset=15>; rel="next", </albums?limit=10&offset=20>; rel="last"`,
+		},
+		{
+			name:      "no matches",
+			target:    "/albums",
+			list:      AlbumList{Limit: 20},
+			wantTotal: "0",
+			wantLink:  `</albums?limit=20>; rel="first", </albums?limit=20>; rel="last"`,
+		},
+		{
+			name:      "limit zero only counts",
+			target:    "/albums?limit=0",
+			list:      AlbumList{Total: 7},
+			wantTotal: "7",
+		},
+		{
+			name:      "filters kept",
+			baseURL:   "https://api.example.com",
+			target:    "/albums?artist=Air&tag=rock&offset=10&limit=10",
+			list:      AlbumList{Total: 15, Limit: 10, Offset: 10},
+			wantTotal: "15",
+			wantLink: `<https://api.example.com/albums?artist=Air&limit=10&tag=rock>; rel="first", ` +
+				`<https://api.example.com/albums?artist=Air&limit=10&tag=rock>; rel="prev", ` +
+				`<https://api.example.com/albums?artist=Air&limit=10&offset=10&tag=rock>; rel="last"`,
+		},
+		{
+			name:      "cursor page with more",
+			target:    "/albums?cursor=abc",
+			list:      AlbumList{Total: 50, Limit: 20, NextCursor: "def"},
+			cursor:    true,
+			wantTotal: "50",
+			wantLink:  `</albums?limit=20>; rel="first", </albums?cursor=def&limit=20>; rel="next"`,
+		},
+		{
+			name:      "cursor page at the end",
+			target:    "/albums?cursor=abc",
+			list:      AlbumList{Total: 50, Limit: 20},
+			cursor:    true,
+			wantTotal: "50",
+			wantLink:  `</albums?limit=20>; rel="first"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, tt.target, nil)
+			s := &Server{publicBaseURL: tt.baseURL}
+
+			s.setPaginationHeaders(c, tt.list, tt.cursor)
+
+			if got := w.Header().Get("X-Total-Count"); got != tt.wantTotal {
+				t.Errorf("X-Total-Count = %q, want %q", got, tt.wantTotal)
+			}
+			if got := w.Header().Get("Link"); got != tt.wantLink {
+				t.Errorf("Link = %q\nwant %q", got, tt.wantLink)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// placeholderImage is the PLACEHOLDER_IMAGE served for albums without an
+// image, read once at startup
+type placeholderImage struct {
+	data        []byte
+	contentType string
+	etag        string
+	modTime     time.Time
+}
+
+// loadPlaceholder reads the placeholder image at path, which must be a
+// JPEG, PNG or WebP of at most maxBytes
+func loadPlaceholder(path string, maxBytes int64) (*placeholderImage, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxBytes {
+		return nil, fmt.Errorf("%s is larger than MAX_UPLOAD_BYTES", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	contentType := http.DetectContentType(data)
+	if !allowedImageTypes[contentType] {
+		return nil, fmt.Errorf("%s: %w", path, errUnsupportedImageType)
+	}
+
+	sum := sha256.Sum256(data)
+	return &placeholderImage{
+		data:        data,
+		contentType: contentType,
+		etag:        `"placeholder-` + hex.EncodeToString(sum[:8]) + `"`,
+		modTime:     info.ModTime(),
+	}, nil
+}
+
+// servePlaceholder answers GET /albums/{albumID}/image for an album without
+// an image. Without a PLACEHOLDER_IMAGE, or with ?placeholder=false for
+// clients that draw their own, it is a 404 as before.
+func (s *Server) servePlaceholder(c *gin.Context) {
+	if s.placeholder == nil || c.Query("placeholder") == "false" {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Album has no image")
+		return
+	}
+
+	p := s.placeholder
+	c.Header("Content-Type", p.contentType)
+	c.Header("ETag", p.etag)
+	// The album may get a real image at this URL at any time
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Image-Placeholder", "true")
+	http.ServeContent(c.Writer, c.Request, "placeholder"+imageExtensions[p.contentType], p.modTime, bytes.NewReader(p.data))
+}
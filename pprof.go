@@ -0,0 +1,34 @@
+package main
+
+import (
+	"log/slog"
+	"net/http/pprof"
+
+	"github.com/gin-gonic/gin"
+)
+
+// registerPprof mounts the net/http/pprof handlers under /debug/pprof,
+// guarded by auth like any other admin route. With authentication off they
+// are open to anyone who can reach the port, so only enable them there
+// briefly. To grab a heap profile:
+//
+//	curl -H "Authorization: Bearer $TOKEN" -o heap.pprof http://localhost:8080/debug/pprof/heap
+//	go tool pprof -top heap.pprof
+//
+// ?gc=1 runs a collection first so the profile shows live objects only.
+// CPU profiles and traces record for ?seconds=, which must stay below
+// REQUEST_TIMEOUT and HTTP_WRITE_TIMEOUT or the response is cut off.
+func registerPprof(r *gin.Engine, auth gin.HandlerFunc) {
+	slog.Warn("ENABLE_PPROF is set, profiling endpoints are served under /debug/pprof")
+
+	g := r.Group("/debug/pprof", auth)
+	g.GET("/", gin.WrapF(pprof.Index))
+	g.GET("/cmdline", gin.WrapF(pprof.Cmdline))
+	g.GET("/profile", gin.WrapF(pprof.Profile))
+	g.GET("/symbol", gin.WrapF(pprof.Symbol))
+	g.POST("/symbol", gin.WrapF(pprof.Symbol))
+	g.GET("/trace", gin.WrapF(pprof.Trace))
+	for _, name := range []string{"allocs", "block", "goroutine", "heap", "mutex", "threadcreate"} {
+		g.GET("/"+name, gin.WrapH(pprof.Handler(name)))
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// isRemoteURL reports whether a stored image URL points outside local
+// storage, such as a hosted image or an S3 object
+func isRemoteURL(u string) bool {
+	return strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://")
+}
+
+// presentAlbum swaps the local file paths stored for album for the API URLs
+// that serve them, under PUBLIC_BASE_URL, so clients never see server paths
+// and the layout on disk can change freely. Remote URLs are usable as they
+// are and kept. Call it on copies about to be sent, never before serving files.
+func (s *Server) presentAlbum(album *AlbumInfo) {
+	base := s.publicBaseURL + "/albums/" + strconv.Itoa(album.AlbumID)
+	if album.ImageURL == "" && s.placeholder != nil {
+		album.ImageURL, album.Placeholder = base+"/image", true
+	}
+	if album.ImageURL != "" && !isRemoteURL(album.ImageURL) {
+		album.ImageURL = base + "/image"
+	}
+	if album.ThumbnailURL != "" && !isRemoteURL(album.ThumbnailURL) {
+		album.ThumbnailURL = base + "/thumbnail"
+	}
+	if album.AudioURL != "" && !isRemoteURL(album.AudioURL) {
+		album.AudioURL = base + "/audio"
+	}
+	for i := range album.Images {
+		s.presentImage(album.AlbumID, &album.Images[i])
+	}
+}
+
+// presentImage is presentAlbum for a single image of albumID
+func (s *Server) presentImage(albumID int, image *AlbumImage) {
+	if image.ImageURL != "" && !isRemoteURL(image.ImageURL) {
+		image.ImageURL = s.publicBaseURL + "/albums/" + strconv.Itoa(albumID) + "/images/" + strconv.Itoa(image.ImageID)
+	}
+}
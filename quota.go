@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errQuotaExceeded means an upload would take its API key past UPLOAD_QUOTA_BYTES
+var errQuotaExceeded = errors.New("upload quota exceeded")
+
+// UploadUsage is the body of GET /usage
+type UploadUsage struct {
+	UploadedBytes int64 `json:"uploaded_bytes"`
+	// QuotaBytes and RemainingBytes are omitted when uploads are unlimited
+	QuotaBytes     int64  `json:"quota_bytes,omitempty"`
+	RemainingBytes *int64 `json:"remaining_bytes,omitempty"`
+}
+
+// apiKeyHash identifies a key in api_key_usage without storing it
+func apiKeyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// chargeUpload adds n uploaded bytes to the usage of the request's API key
+// inside tx, so the charge commits or rolls back with the upload itself. It
+// returns errQuotaExceeded when the new total is over the quota. Requests not
+// authenticated by API key are not metered.
+func (s *Server) chargeUpload(ctx context.Context, tx *sql.Tx, n int64) error {
+	key := apiKeyFromContext(ctx)
+	if key == "" || n <= 0 {
+		return nil
+	}
+
+	// The upsert locks the row until commit, so concurrent uploads with the
+	// same key are checked one after another
+	hash := apiKeyHash(key)
+	_, err := tx.ExecContext(ctx, "INSERT INTO api_key_usage (key_hash, uploaded_bytes) VALUES (?, ?) ON DUPLICATE KEY UPDATE uploaded_bytes = uploaded_bytes + VALUES(uploaded_bytes)", hash, n)
+	if err != nil || s.uploadQuota == 0 {
+		return err
+	}
+
+	var used int64
+	if err := tx.QueryRowContext(ctx, "SELECT uploaded_bytes FROM api_key_usage WHERE key_hash = ?", hash).Scan(&used); err != nil {
+		return err
+	}
+	if used > s.uploadQuota {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// uploadedBytes returns the bytes key has uploaded so far
+func (s *Server) uploadedBytes(ctx context.Context, key string) (int64, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	var used int64
+	err := s.db.QueryRowContext(ctx, "SELECT uploaded_bytes FROM api_key_usage WHERE key_hash = ?", apiKeyHash(key)).Scan(&used)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return used, err
+}
+
+// respondQuotaExceeded aborts with the 403 for an exhausted upload quota
+func respondQuotaExceeded(c *gin.Context) {
+	respondError(c, http.StatusForbidden, ErrCodeQuotaExceeded, "Upload quota exceeded for this API key")
+}
+
+// requireQuota turns away uploads from API keys that have already used up
+// their quota, before any bytes are read. An upload that starts under the
+// quota and would end over it is refused by chargeUpload instead.
+func (s *Server) requireQuota() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.quotaLeft(c) {
+			c.Next()
+		}
+	}
+}
+
+// quotaLeft reports whether the request's API key may still upload, and
+// responds with 403 when it may not
+func (s *Server) quotaLeft(c *gin.Context) bool {
+	key := apiKeyFromContext(c.Request.Context())
+	if key == "" || s.uploadQuota == 0 {
+		return true
+	}
+
+	used, err := s.uploadedBytes(c.Request.Context(), key)
+	if err != nil {
+		respondInternalError(c, err)
+		return false
+	}
+	if used >= s.uploadQuota {
+		respondQuotaExceeded(c)
+		return false
+	}
+	return true
+}
+
+// GET /usage -> the bytes the calling API key has uploaded and its quota
+func (s *Server) getUsage(c *gin.Context) {
+	key := apiKeyFromContext(c.Request.Context())
+	if key == "" {
+		respondError(c, http.StatusNotImplemented, ErrCodeNotImplemented, "Upload usage is only tracked for API key authentication")
+		return
+	}
+
+	used, err := s.uploadedBytes(c.Request.Context(), key)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	usage := UploadUsage{UploadedBytes: used}
+	if s.uploadQuota > 0 {
+		remaining := max(s.uploadQuota-used, 0)
+		usage.QuotaBytes = s.uploadQuota
+		usage.RemainingBytes = &remaining
+	}
+	c.JSON(http.StatusOK, usage)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// randomAlbumProbes is how many random IDs GET /albums/random tries before
+// falling back to ORDER BY RAND()
+const randomAlbumProbes = 8
+
+// GET /albums/random -> a uniformly chosen live album, or 404 when there
+// are none. Responses are not cacheable, since each request picks anew.
+func (s *Server) randomAlbum(c *gin.Context) {
+	format, ok := negotiateFormat(c)
+	if !ok {
+		return
+	}
+
+	album, err := s.pickRandomAlbum(c.Request.Context())
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusNotFound, ErrCodeNotFound, "No albums")
+			return
+		}
+		respondInternalError(c, err)
+		return
+	}
+
+	s.presentAlbum(&album)
+	s.localizeTitle(&album, acceptLanguage(c))
+	if album.TitleLocale != "" {
+		c.Header("Content-Language", album.TitleLocale)
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Location", "/albums/"+strconv.Itoa(album.AlbumID))
+	render(c, 200, format, album)
+}
+
+// pickRandomAlbum chooses a live album with equal odds for each.
+//
+// ORDER BY RAND() LIMIT 1 is uniform but reads and sorts every live row, so
+// its cost grows with the catalog. Instead, random IDs between the lowest
+// and highest ID are looked up by primary key until one is a live album.
+// Every live album is equally likely to be hit, so the pick stays uniform,
+// and each probe is a single index lookup, answered from the album cache
+// when the album is in it. The catch is the gaps left by deleted and
+// soft-deleted albums: the chance of a probe hitting is the share of live
+// albums in the ID range. When randomAlbumProbes probes all miss, as they
+// mostly will once the gaps outweigh the albums, it falls back to
+// ORDER BY RAND(), which is still correct, just slower on a large table.
+func (s *Server) pickRandomAlbum(ctx context.Context) (AlbumInfo, error) {
+	var minID, maxID sql.NullInt64
+	err := s.withRetry(ctx, func(ctx context.Context) error {
+		queryCtx, cancel := s.queryContext(ctx)
+		defer cancel()
+		return s.db.QueryRowContext(queryCtx, "SELECT MIN(id), MAX(id) FROM albums").Scan(&minID, &maxID)
+	})
+	if err != nil {
+		return AlbumInfo{}, err
+	}
+	if !minID.Valid {
+		return AlbumInfo{}, sql.ErrNoRows
+	}
+
+	for range randomAlbumProbes {
+		id := minID.Int64 + rand.Int64N(maxID.Int64-minID.Int64+1)
+		album, err := s.cachedAlbum(ctx, int(id))
+		if err != sql.ErrNoRows {
+			return album, err
+		}
+	}
+
+	var id int
+	err = s.withRetry(ctx, func(ctx context.Context) error {
+		queryCtx, cancel := s.queryContext(ctx)
+		defer cancel()
+		return s.db.QueryRowContext(queryCtx, "SELECT id FROM albums WHERE deleted_at IS NULL ORDER BY RAND() LIMIT 1").Scan(&id)
+	})
+	if err != nil {
+		return AlbumInfo{}, err
+	}
+	return s.cachedAlbum(ctx, id)
+}
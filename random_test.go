@@ -0,0 +1,77 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestRandomAlbum(t *testing.T) {
+	const bounds = "SELECT MIN(id), MAX(id) FROM albums"
+	lookup := regexp.QuoteMeta("SELECT " + albumColumns + " FROM albums WHERE id = ? AND deleted_at IS NULL")
+	expectAlbum := func(m sqlmock.Sqlmock, id int) {
+		now := time.Now()
+		m.ExpectQuery(lookup).WithArgs(id).WillReturnRows(albumRows().AddRow(id, nil, "mem/a.png", nil, nil, nil, nil, nil, nil,
+			`{"artist":"Air","title":"Talkie Walkie"}`, nil, nil, nil, 1, now, now, nil))
+		m.ExpectQuery(regexp.QuoteMeta("FROM album_images WHERE album_id IN (?)")).WithArgs(id).
+			WillReturnRows(sqlmock.NewRows([]string{"album_id", "id", "image_url", "position", "width", "height", "size_bytes", "created_at"}))
+	}
+
+	tests := []struct {
+		name       string
+		expect     func(m sqlmock.Sqlmock)
+		wantStatus int
+		wantID     string
+	}{
+		{
+			name: "no albums",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(bounds)).WillReturnRows(sqlmock.NewRows([]string{"min", "max"}).AddRow(nil, nil))
+			},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "probe hits",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(bounds)).WillReturnRows(sqlmock.NewRows([]string{"min", "max"}).AddRow(7, 7))
+				expectAlbum(m, 7)
+			},
+			wantStatus: http.StatusOK,
+			wantID:     "/albums/7",
+		},
+		{
+			name: "probes miss, falls back to ORDER BY RAND()",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(bounds)).WillReturnRows(sqlmock.NewRows([]string{"min", "max"}).AddRow(1, 1000))
+				for range randomAlbumProbes {
+					m.ExpectQuery(lookup).WithArgs(sqlmock.AnyArg()).WillReturnError(sql.ErrNoRows)
+				}
+				m.ExpectQuery(regexp.QuoteMeta("ORDER BY RAND() LIMIT 1")).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+				expectAlbum(m, 5)
+			},
+			wantStatus: http.StatusOK,
+			wantID:     "/albums/5",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, nil)
+			tt.expect(ts.mock)
+
+			w := ts.do(http.MethodGet, "/albums/random", "", nil)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if got := w.Header().Get("Content-Location"); got != tt.wantID {
+				t.Errorf("Content-Location = %q, want %q", got, tt.wantID)
+			}
+			if tt.wantStatus == http.StatusOK && w.Header().Get("Cache-Control") != "no-store" {
+				t.Errorf("Cache-Control = %q, want no-store", w.Header().Get("Cache-Control"))
+			}
+		})
+	}
+}
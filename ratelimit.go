@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// ipLimiterTTL is how long an idle client's bucket is kept before eviction
+const ipLimiterTTL = 10 * time.Minute
+
+type ipLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// ipRateLimiter hands out one token bucket per client IP
+type ipRateLimiter struct {
+	mu       sync.Mutex
+	limiters map[string]*ipLimiter
+	rps      rate.Limit
+	burst    int
+}
+
+// newIPRateLimiter creates a limiter and starts evicting idle buckets
+func newIPRateLimiter(rps float64, burst int) *ipRateLimiter {
+	l := &ipRateLimiter{
+		limiters: make(map[string]*ipLimiter),
+		rps:      rate.Limit(rps),
+		burst:    burst,
+	}
+	go l.evictLoop()
+	return l
+}
+
+// get returns the bucket for ip, creating it on first use
+func (l *ipRateLimiter) get(ip string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.limiters[ip]
+	if !ok {
+		entry = &ipLimiter{limiter: rate.NewLimiter(l.rps, l.burst)}
+		l.limiters[ip] = entry
+	}
+	entry.lastSeen = time.Now()
+	return entry.limiter
+}
+
+// evictLoop drops buckets that haven't been used within ipLimiterTTL
+func (l *ipRateLimiter) evictLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		l.mu.Lock()
+		for ip, entry := range l.limiters {
+			if time.Since(entry.lastSeen) > ipLimiterTTL {
+				delete(l.limiters, ip)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// middleware rejects requests over the client's rate with 429 and Retry-After
+func (l *ipRateLimiter) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		res := l.get(c.ClientIP()).Reserve()
+		if delay := res.Delay(); !res.OK() || delay > 0 {
+			res.Cancel()
+			retryAfter := int(math.Ceil(delay.Seconds()))
+			if !res.OK() || retryAfter < 1 {
+				retryAfter = 1
+			}
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			respondError(c, http.StatusTooManyRequests, ErrCodeRateLimited, "Rate limit exceeded")
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestRateLimit(t *testing.T) {
+	const burst = 3
+
+	tests := []struct {
+		name    string
+		proxies []string
+		// forwardedFor is sent with every request, a different address each time
+		forwardedFor bool
+		wantLimited  bool
+	}{
+		{"same client", nil, false, true},
+		// Without trusted proxies a client can't mint itself fresh buckets
+		{"spoofed X-Forwarded-For", nil, true, true},
+		{"clients behind a trusted proxy", []string{"192.0.2.0/24"}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, func(cfg *Config) {
+				cfg.RateLimitRPS = 0.001
+				cfg.RateLimitBurst = burst
+				cfg.TrustedProxies = tt.proxies
+			})
+
+			send := func(i int) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodGet, "/health/live", nil)
+				req.RemoteAddr = "192.0.2.1:1234"
+				if tt.forwardedFor {
+					req.Header.Set("X-Forwarded-For", "198.51.100."+strconv.Itoa(i+1))
+				}
+				w := httptest.NewRecorder()
+				ts.router.ServeHTTP(w, req)
+				return w
+			}
+
+			for i := range burst {
+				if w := send(i); w.Code != http.StatusOK {
+					t.Fatalf("request %d: status = %d, want 200", i+1, w.Code)
+				}
+			}
+			w := send(burst)
+			if !tt.wantLimited {
+				if w.Code != http.StatusOK {
+					t.Errorf("request %d: status = %d, want 200", burst+1, w.Code)
+				}
+				return
+			}
+			if w.Code != http.StatusTooManyRequests {
+				t.Fatalf("request %d: status = %d, want 429", burst+1, w.Code)
+			}
+			if got := w.Header().Get("Retry-After"); got == "" {
+				t.Error("429 without Retry-After")
+			}
+		})
+	}
+}
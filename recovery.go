@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recoveryMiddleware turns a handler panic into a logged error and a generic
+// 500 ErrorResponse, so neither the stack trace nor the panic value reaches
+// the client. It runs inside requestIDMiddleware so the log carries the ID.
+func recoveryMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+
+			// http.ErrAbortHandler is the documented way to abort a response;
+			// let net/http handle it as usual
+			if err, ok := rec.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(rec)
+			}
+
+			// A client that went away isn't a server bug, and there's nobody
+			// left to answer
+			if err, ok := rec.(error); ok && isBrokenConnection(err) {
+				c.Abort()
+				return
+			}
+
+			ctx := c.Request.Context()
+			logAttrs := []any{"method", c.Request.Method, "path", c.Request.URL.Path, "panic", rec, "stack", string(debug.Stack())}
+			if c.Writer.Written() {
+				slog.ErrorContext(ctx, "Handler panicked after writing the response", logAttrs...)
+				c.Abort()
+				return
+			}
+			slog.ErrorContext(ctx, "Handler panicked", logAttrs...)
+			respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Internal server error")
+		}()
+		c.Next()
+	}
+}
+
+// isBrokenConnection reports whether err comes from writing to a client
+// connection that was reset or closed
+func isBrokenConnection(err error) bool {
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		return false
+	}
+	var sysErr *os.SyscallError
+	if errors.As(opErr, &sysErr) {
+		return errors.Is(sysErr.Err, syscall.EPIPE) || errors.Is(sysErr.Err, syscall.ECONNRESET)
+	}
+	return false
+}
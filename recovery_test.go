@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRecoveryMiddleware(t *testing.T) {
+	brokenPipe := &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.EPIPE)}
+	tests := []struct {
+		name       string
+		handler    gin.HandlerFunc
+		wantStatus int
+		wantJSON   bool
+	}{
+		{"panic value", func(c *gin.Context) { panic("secret-detail") }, http.StatusInternalServerError, true},
+		{"panic error", func(c *gin.Context) { panic(errors.New("secret-detail")) }, http.StatusInternalServerError, true},
+		{"nil map write", func(c *gin.Context) {
+			var m map[string]int
+			m["secret-detail"]++
+		}, http.StatusInternalServerError, true},
+		{"after writing", func(c *gin.Context) {
+			c.String(http.StatusAccepted, "partial")
+			panic("secret-detail")
+		}, http.StatusAccepted, false},
+		{"client gone", func(c *gin.Context) { panic(brokenPipe) }, http.StatusOK, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(recoveryMiddleware())
+			r.GET("/", tt.handler)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if strings.Contains(w.Body.String(), "secret-detail") || strings.Contains(w.Body.String(), "goroutine") {
+				t.Errorf("body leaks the panic: %s", w.Body)
+			}
+			if !tt.wantJSON {
+				return
+			}
+			var resp ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Code != ErrCodeInternal {
+				t.Errorf("body = %s, want an %s ErrorResponse", w.Body, ErrCodeInternal)
+			}
+		})
+	}
+}
+
+func TestRecoveryMiddlewareRepanicsAbort(t *testing.T) {
+	r := gin.New()
+	r.Use(recoveryMiddleware())
+	r.GET("/", func(c *gin.Context) { panic(http.ErrAbortHandler) })
+
+	defer func() {
+		if rec := recover(); rec != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler passed on", rec)
+		}
+	}()
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestIsBrokenConnection(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"broken pipe", &net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.EPIPE)}, true},
+		{"reset", &net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.ECONNRESET)}, true},
+		{"other syscall", &net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.EACCES)}, false},
+		{"plain error", errors.New("broken pipe"), false},
+	}
+	for _, tt := range tests {
+		if got := isBrokenConnection(tt.err); got != tt.want {
+			t.Errorf("%s: isBrokenConnection = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
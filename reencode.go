@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"path"
+
+	"github.com/HugoSmits86/nativewebp"
+)
+
+// reencodeFormats maps the REENCODE_FORMAT values to the type they store
+var reencodeFormats = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"webp": "image/webp",
+}
+
+// originalsDir and originalsPrefix are where KEEP_ORIGINALS puts uploads as
+// received, next to rather than inside the image directory so the orphan
+// sweep never mistakes them for unreferenced images
+const (
+	originalsDir    = "./originals"
+	originalsPrefix = "originals"
+)
+
+// newOriginalsStorage returns the storage for originals kept by
+// KEEP_ORIGINALS, on the same backend as the images
+func newOriginalsStorage(cfg Config) (Storage, error) {
+	if cfg.StorageBackend == "s3" {
+		s3cfg := cfg.S3
+		s3cfg.Prefix = path.Join(s3cfg.Prefix, originalsPrefix)
+		return NewS3Storage(s3cfg)
+	}
+	return NewLocalStorage(originalsDir, cfg.StorageShardDepth), nil
+}
+
+// reencodeImage decodes an image and encodes it as contentType, JPEG at the
+// given quality and PNG or WebP losslessly. Metadata does not survive, and
+// transparent pixels are flattened onto white for JPEG, which has no alpha.
+// Images of more than maxPixels are refused with errImageTooLarge.
+func reencodeImage(ctx context.Context, data []byte, contentType string, quality int, maxPixels int64) ([]byte, error) {
+	img, err := decodeImage(ctx, data, maxPixels)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	switch contentType {
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, flatten(img), &jpeg.Options{Quality: quality})
+	case "image/png":
+		err = png.Encode(&buf, img)
+	case "image/webp":
+		err = nativewebp.Encode(&buf, img, nil)
+	default:
+		return nil, fmt.Errorf("cannot encode %s", contentType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to re-encode image as %s: %v", contentType, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// flatten draws an image with transparency over a white background
+func flatten(img image.Image) image.Image {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
+	}
+	out := image.NewRGBA(img.Bounds())
+	draw.Draw(out, out.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(out, out.Bounds(), img, img.Bounds().Min, draw.Over)
+	return out
+}
+
+// isAnimated reports whether a PNG or WebP holds an animation, which
+// re-encoding would reduce to its first frame. An APNG announces itself
+// with an acTL chunk before the image data; an animated WebP sets the
+// animation flag of its VP8X header.
+func isAnimated(contentType string, data []byte) bool {
+	switch contentType {
+	case "image/png":
+		for i := len(pngSignature); i+8 <= len(data); {
+			length := int(binary.BigEndian.Uint32(data[i:]))
+			switch string(data[i+4 : i+8]) {
+			case "acTL":
+				return true
+			case "IDAT":
+				return false
+			}
+			i += 12 + length
+		}
+	case "image/webp":
+		return len(data) > 20 && string(data[12:16]) == "VP8X" && data[20]&0x02 != 0
+	}
+	return false
+}
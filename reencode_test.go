@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReencodeImage(t *testing.T) {
+	for _, contentType := range []string{"image/jpeg", "image/png", "image/webp"} {
+		t.Run(contentType, func(t *testing.T) {
+			data, err := reencodeImage(context.Background(), testPNGData(4, 3), contentType, 85, 1_000_000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := http.DetectContentType(data); got != contentType {
+				t.Errorf("content type = %s", got)
+			}
+			cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Width != 4 || cfg.Height != 3 {
+				t.Errorf("size = %dx%d, want 4x3", cfg.Width, cfg.Height)
+			}
+		})
+	}
+
+	if _, err := reencodeImage(context.Background(), []byte("not an image"), "image/jpeg", 85, 1_000_000); err == nil {
+		t.Error("undecodable data re-encoded")
+	}
+	if _, err := reencodeImage(context.Background(), testPNGData(4, 3), "image/jpeg", 85, 11); !errors.Is(err, errImageTooLarge) {
+		t.Errorf("image over MAX_IMAGE_PIXELS: err = %v, want errImageTooLarge", err)
+	}
+}
+
+func TestReencodeFlattensTransparencyOntoWhite(t *testing.T) {
+	// testPNGData is fully transparent
+	data, err := reencodeImage(context.Background(), testPNGData(2, 2), "image/jpeg", 100, 1_000_000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, g, b, _ := img.At(0, 0).RGBA()
+	if r < 0xf000 || g < 0xf000 || b < 0xf000 {
+		t.Errorf("pixel = %v, want white", color.RGBA64{uint16(r), uint16(g), uint16(b), 0xffff})
+	}
+}
+
+func TestIsAnimated(t *testing.T) {
+	chunk := func(typ string, n int) string {
+		return "\x00\x00\x00" + string(rune(n)) + typ + strings.Repeat("\x00", n) + "CRC_"
+	}
+	still := string(pngSignature) + chunk("IHDR", 13) + chunk("IDAT", 2) + chunk("acTL", 8)
+	apng := string(pngSignature) + chunk("IHDR", 13) + chunk("acTL", 8) + chunk("IDAT", 2)
+	webp := func(flags byte) string {
+		return "RIFF\x00\x00\x00\x00WEBPVP8X\x0a\x00\x00\x00" + string(flags) + "\x00\x00\x00\x00\x00\x00\x00\x00\x00"
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		data        string
+		want        bool
+	}{
+		{"still png", "image/png", still, false},
+		{"apng", "image/png", apng, true},
+		{"still webp", "image/webp", webp(0x10), false},
+		{"animated webp", "image/webp", webp(0x02), true},
+		{"jpeg", "image/jpeg", "\xff\xd8\xff", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isAnimated(tt.contentType, []byte(tt.data)); got != tt.want {
+				t.Errorf("isAnimated = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReencodeUpload(t *testing.T) {
+	ts := newTestServer(t, func(cfg *Config) { cfg.ReencodeFormat = "jpeg" })
+	originals := newMemStorage()
+	ts.originals = originals
+	ctx := context.Background()
+	received := testPNGData(4, 4)
+
+	img, err := ts.prepareUpload(ctx, received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.contentType != "image/jpeg" || img.ext != ".jpg" {
+		t.Errorf("stored as %s %s, want image/jpeg .jpg", img.contentType, img.ext)
+	}
+	if http.DetectContentType(img.data) != "image/jpeg" {
+		t.Error("stored data is not JPEG")
+	}
+
+	url, err := ts.storeImage(ctx, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(url, "mem/"), ".jpg")
+	if got := originals.objects["mem/"+name+".png"]; !bytes.Equal(got, received) {
+		t.Errorf("original under %s.png not kept as received", name)
+	}
+
+	// An upload already in the stored format is kept as it is, without an original
+	ts.reencodeType = "image/png"
+	img, err = ts.prepareUpload(ctx, received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.original != nil || img.contentType != "image/png" {
+		t.Errorf("PNG upload re-encoded to %s for a PNG store", img.contentType)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxFetchRedirects caps how many redirects a source_url download follows
+const maxFetchRedirects = 5
+
+var (
+	errBlockedAddress  = errors.New("source_url points at a private, loopback or otherwise reserved address")
+	errFetchTooLarge   = errors.New("source_url image exceeds the upload size limit")
+	errFetchBadStatus  = errors.New("source_url did not return the image")
+	errFetchNotAnImage = errors.New("source_url is not a JPEG, PNG or WebP image")
+)
+
+// blockedPrefixes are ranges outside what netip's predicates cover that
+// must not be fetched either: shared CGN space, IETF protocol assignments,
+// benchmarking, reserved class E and NAT64, which can be mapped onto
+// internal IPv4 hosts
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+}
+
+// isPublicAddr reports whether addr is a public unicast address.
+// Loopback, link-local (which includes the 169.254.169.254 cloud metadata
+// endpoint), private, ULA (including fd00:ec2::254), multicast and
+// unspecified addresses are not, and IPv4-mapped IPv6 is judged as IPv4.
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, p := range blockedPrefixes {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// newFetchClient returns the HTTP client for source_url downloads. The
+// address check runs in the dialer on the IP actually being connected to,
+// after DNS resolution, so neither a hostname resolving to an internal
+// address nor DNS rebinding between the check and the connection gets
+// through; it applies to every redirect hop as well. Proxies from the
+// environment are ignored, since connecting through one would hide the
+// target address from the check.
+func newFetchClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			ap, err := netip.ParseAddrPort(address)
+			if err != nil || !isPublicAddr(ap.Addr()) {
+				return errBlockedAddress
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxFetchRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxFetchRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// fetchSourceImage downloads the image at sourceURL, refusing anything
+// larger than MAX_UPLOAD_BYTES or served as a type other than those
+// accepted for upload. The bytes are still sniffed by prepareUpload.
+func (s *Server) fetchSourceImage(ctx context.Context, sourceURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "image/jpeg, image/png, image/webp")
+
+	resp, err := s.fetchClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %d", errFetchBadStatus, resp.StatusCode)
+	}
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err != nil || !allowedImageTypes[mediaType] {
+		return nil, errFetchNotAnImage
+	}
+	if resp.ContentLength > s.maxUploadBytes {
+		return nil, errFetchTooLarge
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, s.maxUploadBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > s.maxUploadBytes {
+		return nil, errFetchTooLarge
+	}
+	return data, nil
+}
+
+// respondFetchError maps a failed source_url download to a response. The
+// cause is logged but only summarized for the client, so the endpoint
+// can't be used to probe what is reachable from the server.
+func respondFetchError(c *gin.Context, err error) {
+	slog.WarnContext(c.Request.Context(), "Failed to fetch source_url", "error", err)
+	switch {
+	case errors.Is(err, errBlockedAddress):
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, errBlockedAddress.Error())
+	case errors.Is(err, errFetchTooLarge):
+		respondError(c, http.StatusRequestEntityTooLarge, ErrCodePayloadTooLarge, errFetchTooLarge.Error())
+	case errors.Is(err, errFetchNotAnImage):
+		respondError(c, http.StatusUnsupportedMediaType, ErrCodeUnsupportedMedia, errFetchNotAnImage.Error())
+	case c.Request.Context().Err() != nil:
+		respondInternalError(c, err)
+	default:
+		respondError(c, http.StatusBadGateway, ErrCodeFetchFailed, "Failed to fetch source_url")
+	}
+}
+
+// createFetchedAlbum downloads sourceURL and stores it as if it had been
+// uploaded, so deduplication, quotas and thumbnails apply the same way
+func (s *Server) createFetchedAlbum(c *gin.Context, sourceURL string, metadata AlbumMetadata, externalID string) {
+	if !s.quotaLeft(c) {
+		return
+	}
+	// Skip the download for an import that already ran
+	if s.existingExternalAlbum(c, externalID) {
+		return
+	}
+
+	data, err := s.fetchSourceImage(c.Request.Context(), sourceURL)
+	if err != nil {
+		respondFetchError(c, err)
+		return
+	}
+	img, err := s.prepareUpload(c.Request.Context(), data)
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+
+	s.createUploadedAlbum(c, img, nil, metadata, externalID, int64(len(data)))
+}
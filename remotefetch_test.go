@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIsPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"8.8.8.8", true},
+		{"2606:4700:4700::1111", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00:ec2::254", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"100.64.0.1", false},
+		{"198.18.0.1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"::ffff:93.184.216.34", true},
+		{"64:ff9b::a9fe:a9fe", false},
+	}
+	for _, tt := range tests {
+		if got := isPublicAddr(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("isPublicAddr(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+// TestFetchClientRefusesInternalHosts checks that the dialer refuses the
+// resolved address, whether the URL names it directly or by hostname
+func TestFetchClientRefusesInternalHosts(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hits.Add(1) }))
+	t.Cleanup(srv.Close)
+	port := srv.URL[strings.LastIndex(srv.URL, ":"):]
+
+	client := newFetchClient(5 * time.Second)
+	for _, target := range []string{srv.URL, "http://localhost" + port, "http://[::ffff:127.0.0.1]" + port} {
+		resp, err := client.Get(target)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if !errors.Is(err, errBlockedAddress) {
+			t.Errorf("GET %s: err = %v, want errBlockedAddress", target, err)
+		}
+	}
+	if n := hits.Load(); n != 0 {
+		t.Errorf("internal server received %d requests", n)
+	}
+}
+
+func TestCreateFromSourceURLRefusesInternalHost(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hits.Add(1) }))
+	t.Cleanup(srv.Close)
+	ts := newTestServer(t, nil)
+
+	w := ts.do(http.MethodPost, "/albums", `{"source_url":"`+srv.URL+`/cover.jpg","artist":"A","title":"T","year":"2001"}`, nil)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), errBlockedAddress.Error()) {
+		t.Errorf("status = %d, body %s", w.Code, w.Body)
+	}
+	if n := hits.Load(); n != 0 {
+		t.Errorf("internal server received %d requests", n)
+	}
+}
+
+// TestFetchSourceImage checks the response checks with the address check
+// out of the way, using the test server's own client
+func TestFetchSourceImage(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		wantErr     error
+	}{
+		{"image", http.StatusOK, "image/png", "png bytes", nil},
+		{"not found", http.StatusNotFound, "image/png", "", errFetchBadStatus},
+		{"html", http.StatusOK, "text/html; charset=utf-8", "<html>", errFetchNotAnImage},
+		{"no content type", http.StatusOK, "", "png bytes", errFetchNotAnImage},
+		{"too large", http.StatusOK, "image/jpeg", strings.Repeat("x", 65), errFetchTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header()["Content-Type"] = []string{tt.contentType}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			t.Cleanup(srv.Close)
+			s := &Server{fetchClient: srv.Client(), maxUploadBytes: 64}
+
+			data, err := s.fetchSourceImage(context.Background(), srv.URL)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && string(data) != tt.body {
+				t.Errorf("data = %q, want %q", data, tt.body)
+			}
+		})
+	}
+}
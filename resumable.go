@@ -0,0 +1,475 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Resumable uploads send a large image in chunks, so a dropped connection
+// only costs the chunk in flight:
+//
+//  1. POST /albums/resumable with {"size": N} opens a session and returns its
+//     upload_id. N may not exceed MAX_UPLOAD_BYTES.
+//  2. PATCH /albums/resumable/{upload_id} with an Upload-Offset header and raw
+//     bytes appends a chunk. The response's offset is where the next chunk
+//     starts; after a failure GET /albums/resumable/{upload_id} reports how
+//     much arrived.
+//  3. POST /albums/resumable/{upload_id}/complete with the album metadata
+//     creates the album once offset equals size.
+//
+// Chunks are staged under UPLOAD_SESSION_DIR on the instance that opened the
+// session, so a client must reach the same instance for the whole upload.
+// Every chunk pushes expires_at UPLOAD_SESSION_TTL into the future; sessions
+// left idle past it are swept with their staged data by the instances
+// staging into the same directory, which the session records by its
+// staging ID.
+
+// stagingIDFile holds the staging ID inside UPLOAD_SESSION_DIR
+const stagingIDFile = ".staging-id"
+
+// abandonedSessionGrace is how long after expiring a session is left to the
+// instances that staged it. Past that its directory is taken to be gone, and
+// any instance deletes the row.
+const abandonedSessionGrace = 24 * time.Hour
+
+// UploadSessionRequest is the body of POST /albums/resumable
+type UploadSessionRequest struct {
+	Size int64 `json:"size"`
+}
+
+// UploadSession reports how much of a resumable upload has arrived
+type UploadSession struct {
+	UploadID  string    `json:"upload_id"`
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// chunkLocks marks sessions that are busy, so two requests never write the
+// same staging file at once. Sessions live on one instance, so a local lock
+// is enough.
+type chunkLocks struct {
+	mu   sync.Mutex
+	busy map[string]bool
+}
+
+func newChunkLocks() *chunkLocks {
+	return &chunkLocks{busy: make(map[string]bool)}
+}
+
+// tryLock claims id, returning false if another request holds it
+func (l *chunkLocks) tryLock(id string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.busy[id] {
+		return false
+	}
+	l.busy[id] = true
+	return true
+}
+
+func (l *chunkLocks) unlock(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.busy, id)
+}
+
+// stagingID returns the ID of UPLOAD_SESSION_DIR, creating it on first
+// use. The ID lives in the directory itself, so instances sharing one
+// directory share its ID and each sweeps the others' sessions.
+func (s *Server) stagingID() (string, error) {
+	s.stagingMu.Lock()
+	defer s.stagingMu.Unlock()
+	if s.stagingIDValue != "" {
+		return s.stagingIDValue, nil
+	}
+
+	path := filepath.Join(s.uploadSessionDir, stagingIDFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if data, err = createStagingID(s.uploadSessionDir, path); err != nil {
+			return "", err
+		}
+	} else if err != nil {
+		return "", fmt.Errorf("failed to read staging ID: %v", err)
+	}
+
+	id, err := uuid.Parse(strings.TrimSpace(string(data)))
+	if err != nil {
+		return "", fmt.Errorf("invalid staging ID in %s: %v", path, err)
+	}
+	s.stagingIDValue = id.String()
+	return s.stagingIDValue, nil
+}
+
+// createStagingID writes a new staging ID to path in dir and returns the ID
+// found there, which is another instance's if it got there first. The ID is
+// linked into place whole, so no instance reads a half-written one.
+func createStagingID(dir, path string) ([]byte, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %v", err)
+	}
+	tmp, err := os.CreateTemp(dir, ".staging-id-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to write staging ID: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.WriteString(uuid.NewString() + "\n")
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write staging ID: %v", err)
+	}
+	if err := os.Link(tmp.Name(), path); err != nil && !os.IsExist(err) {
+		return nil, fmt.Errorf("failed to write staging ID: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read staging ID: %v", err)
+	}
+	return data, nil
+}
+
+// sessionPath returns the staging file of a session
+func (s *Server) sessionPath(uploadID string) string {
+	return filepath.Join(s.uploadSessionDir, uploadID)
+}
+
+// parseSessionID reads the :uploadID path parameter, responding with 404
+// unless it is a UUID. IDs name staging files, so nothing else may pass.
+func parseSessionID(c *gin.Context) (string, bool) {
+	id, err := uuid.Parse(c.Param("uploadID"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Upload not found or expired")
+		return "", false
+	}
+	return id.String(), true
+}
+
+// loadSession reads an unexpired session, returning sql.ErrNoRows if there is none
+func (s *Server) loadSession(ctx context.Context, uploadID string) (UploadSession, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	session := UploadSession{UploadID: uploadID}
+	err := s.db.QueryRowContext(ctx, "SELECT size_bytes, received_bytes, expires_at FROM upload_sessions WHERE id = ? AND expires_at > CURRENT_TIMESTAMP", uploadID).
+		Scan(&session.Size, &session.Offset, &session.ExpiresAt)
+	return session, err
+}
+
+// lockedSession claims and loads the session named in the path, responding
+// and returning false when it is busy, missing or expired. Callers must
+// unlock the returned ID.
+func (s *Server) lockedSession(c *gin.Context) (UploadSession, bool) {
+	uploadID, ok := parseSessionID(c)
+	if !ok {
+		return UploadSession{}, false
+	}
+	if !s.chunkLocks.tryLock(uploadID) {
+		respondError(c, http.StatusConflict, ErrCodeConflict, "Upload is busy with another request")
+		return UploadSession{}, false
+	}
+
+	session, err := s.loadSession(c.Request.Context(), uploadID)
+	if err != nil {
+		s.chunkLocks.unlock(uploadID)
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusNotFound, ErrCodeNotFound, "Upload not found or expired")
+			return UploadSession{}, false
+		}
+		respondInternalError(c, err)
+		return UploadSession{}, false
+	}
+	return session, true
+}
+
+// respondSession writes a session with its offset also in Upload-Offset
+func respondSession(c *gin.Context, status int, session UploadSession) {
+	c.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	c.JSON(status, session)
+}
+
+// POST /albums/resumable -> opens a resumable upload session
+func (s *Server) createUploadSession(c *gin.Context) {
+	var req UploadSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondJSONBodyError(c, err, "Invalid upload request")
+		return
+	}
+	if req.Size <= 0 {
+		respondErrorDetails(c, http.StatusBadRequest, ErrCodeValidation, "Invalid fields: size",
+			[]FieldError{{Field: "size", Message: "size must be a positive number of bytes"}})
+		return
+	}
+	if req.Size > s.maxUploadBytes {
+		respondError(c, http.StatusRequestEntityTooLarge, ErrCodePayloadTooLarge, "Image file too large")
+		return
+	}
+
+	uploadID := uuid.NewString()
+	stagingID, err := s.stagingID()
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	if err := os.WriteFile(s.sessionPath(uploadID), nil, 0o600); err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	// The expiry is computed by MySQL so the sweeper compares like with like
+	ctx, cancel := s.queryContext(c.Request.Context())
+	defer cancel()
+	_, err = s.db.ExecContext(ctx, "INSERT INTO upload_sessions (id, staging_id, size_bytes, expires_at) VALUES (?, ?, ?, TIMESTAMPADD(SECOND, ?, CURRENT_TIMESTAMP))",
+		uploadID, stagingID, req.Size, int(s.uploadSessionTTL.Seconds()))
+	if err != nil {
+		os.Remove(s.sessionPath(uploadID))
+		respondInternalError(c, err)
+		return
+	}
+
+	session, err := s.loadSession(c.Request.Context(), uploadID)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	c.Header("Location", "/albums/resumable/"+uploadID)
+	respondSession(c, http.StatusCreated, session)
+}
+
+// GET /albums/resumable/{uploadID} -> reports how many bytes have arrived
+func (s *Server) getUploadSession(c *gin.Context) {
+	uploadID, ok := parseSessionID(c)
+	if !ok {
+		return
+	}
+
+	session, err := s.loadSession(c.Request.Context(), uploadID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusNotFound, ErrCodeNotFound, "Upload not found or expired")
+			return
+		}
+		respondInternalError(c, err)
+		return
+	}
+
+	respondSession(c, http.StatusOK, session)
+}
+
+// PATCH /albums/resumable/{uploadID} -> appends the body at Upload-Offset.
+// Bytes that arrive before a connection drops are kept, so the client resumes
+// from the offset GET reports rather than from the start of the chunk.
+func (s *Server) appendUploadChunk(c *gin.Context) {
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "Upload-Offset must be a non-negative integer")
+		return
+	}
+
+	session, ok := s.lockedSession(c)
+	if !ok {
+		return
+	}
+	defer s.chunkLocks.unlock(session.UploadID)
+
+	if offset != session.Offset {
+		respondErrorDetails(c, http.StatusConflict, ErrCodeConflict, "Upload-Offset does not match the bytes received", gin.H{"offset": session.Offset})
+		return
+	}
+
+	f, err := os.OpenFile(s.sessionPath(session.UploadID), os.O_WRONLY, 0)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	// Drop anything past the recorded offset left by a write that was never
+	// recorded, then append
+	if err := f.Truncate(offset); err != nil {
+		f.Close()
+		respondInternalError(c, err)
+		return
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		respondInternalError(c, err)
+		return
+	}
+
+	remaining := session.Size - offset
+	n, readErr := io.Copy(f, io.LimitReader(c.Request.Body, remaining))
+	if err := f.Close(); err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	tooLarge := readErr == nil && n == remaining && extraByte(c.Request.Body)
+
+	if n > 0 {
+		ctx, cancel := s.queryContext(c.Request.Context())
+		defer cancel()
+		_, err := s.db.ExecContext(ctx, "UPDATE upload_sessions SET received_bytes = ?, expires_at = TIMESTAMPADD(SECOND, ?, CURRENT_TIMESTAMP) WHERE id = ? AND received_bytes = ?",
+			offset+n, int(s.uploadSessionTTL.Seconds()), session.UploadID, offset)
+		if err != nil {
+			respondInternalError(c, err)
+			return
+		}
+		session.Offset += n
+	}
+
+	switch {
+	case readErr != nil:
+		respondErrorDetails(c, http.StatusBadRequest, ErrCodeBadRequest, "Chunk was interrupted", gin.H{"offset": session.Offset})
+	case tooLarge:
+		respondErrorDetails(c, http.StatusRequestEntityTooLarge, ErrCodePayloadTooLarge, "Chunk runs past the declared upload size", gin.H{"offset": session.Offset})
+	default:
+		if updated, err := s.loadSession(c.Request.Context(), session.UploadID); err == nil {
+			session = updated
+		}
+		respondSession(c, http.StatusOK, session)
+	}
+}
+
+// extraByte reports whether r has any data left
+func extraByte(r io.Reader) bool {
+	var b [1]byte
+	n, _ := r.Read(b[:])
+	return n > 0
+}
+
+// POST /albums/resumable/{uploadID}/complete -> creates the album from a
+// fully received upload
+func (s *Server) completeUploadSession(c *gin.Context) {
+	var metadata AlbumMetadata
+	if !bindMetadataJSON(c, &metadata, nil, "Invalid metadata") {
+		return
+	}
+	if errs := validateMetadata(&metadata, true); len(errs) > 0 {
+		respondValidationErrors(c, errs)
+		return
+	}
+
+	session, ok := s.lockedSession(c)
+	if !ok {
+		return
+	}
+	defer s.chunkLocks.unlock(session.UploadID)
+
+	if session.Offset != session.Size {
+		respondErrorDetails(c, http.StatusConflict, ErrCodeConflict, "Upload is incomplete", gin.H{"offset": session.Offset, "size": session.Size})
+		return
+	}
+
+	data, err := os.ReadFile(s.sessionPath(session.UploadID))
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	// The session is used up whatever happens to the image from here on
+	s.discardSession(c.Request.Context(), session.UploadID)
+
+	img, err := s.prepareUpload(c.Request.Context(), data)
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+	s.createUploadedAlbum(c, img, nil, metadata, "", session.Size)
+}
+
+// discardSession deletes a session's row and staging file, logging failures
+func (s *Server) discardSession(ctx context.Context, uploadID string) {
+	queryCtx, cancel := s.queryContext(ctx)
+	defer cancel()
+	if _, err := s.db.ExecContext(queryCtx, "DELETE FROM upload_sessions WHERE id = ?", uploadID); err != nil {
+		slog.WarnContext(ctx, "Failed to delete upload session", "upload_id", uploadID, "error", err)
+		return
+	}
+
+	if err := os.Remove(s.sessionPath(uploadID)); err != nil && !os.IsNotExist(err) {
+		slog.WarnContext(ctx, "Failed to remove staged upload", "upload_id", uploadID, "error", err)
+	}
+}
+
+// sweepUploadSessions periodically discards resumable uploads that expired
+// before completing, until ctx is cancelled
+func (s *Server) sweepUploadSessions(ctx context.Context) {
+	ticker := time.NewTicker(pendingSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.sweepExpiredSessions(ctx); err != nil {
+				slog.Warn("Failed to sweep expired upload sessions", "error", err)
+			}
+		}
+	}
+}
+
+// sweepExpiredSessions discards one batch of expired upload sessions staged
+// in this instance's directory, skipping any that are receiving a chunk
+// right now. Other instances' sessions are left to them, since deleting
+// the row would orphan their staged file, until abandonedSessionGrace.
+func (s *Server) sweepExpiredSessions(ctx context.Context) error {
+	stagingID, err := s.stagingID()
+	if err != nil {
+		return err
+	}
+
+	queryCtx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(queryCtx, "SELECT id FROM upload_sessions WHERE expires_at <= CURRENT_TIMESTAMP "+
+		"AND (staging_id = ? OR expires_at <= TIMESTAMPADD(SECOND, ?, CURRENT_TIMESTAMP)) LIMIT 100",
+		stagingID, -int(abandonedSessionGrace.Seconds()))
+	if err != nil {
+		return err
+	}
+
+	var batch []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		batch = append(batch, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	swept := 0
+	for _, id := range batch {
+		if !s.chunkLocks.tryLock(id) {
+			continue
+		}
+		s.discardSession(ctx, id)
+		s.chunkLocks.unlock(id)
+		swept++
+	}
+	if swept > 0 {
+		slog.Info("Swept expired upload sessions", "count", swept)
+	}
+	return nil
+}
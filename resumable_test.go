@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestStagingID(t *testing.T) {
+	shared, other := t.TempDir(), t.TempDir()
+	ids := map[string]string{}
+	for name, dir := range map[string]string{"first": shared, "second": shared, "other": other} {
+		s := &Server{uploadSessionDir: dir}
+		id, err := s.stagingID()
+		if err != nil {
+			t.Fatalf("%s: stagingID: %v", name, err)
+		}
+		ids[name] = id
+	}
+
+	if ids["first"] != ids["second"] {
+		t.Errorf("instances sharing a directory got %q and %q", ids["first"], ids["second"])
+	}
+	if ids["first"] == ids["other"] {
+		t.Errorf("separate directories share staging ID %q", ids["first"])
+	}
+	if _, err := os.Stat(filepath.Join(shared, stagingIDFile)); err != nil {
+		t.Errorf("staging ID not kept in the directory: %v", err)
+	}
+}
+
+func TestCreateUploadSessionRecordsStagingID(t *testing.T) {
+	ts := newTestServer(t, func(cfg *Config) { cfg.UploadSessionDir = t.TempDir() })
+	stagingID, err := ts.stagingID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts.mock.ExpectExec(regexp.QuoteMeta("INSERT INTO upload_sessions (id, staging_id, size_bytes, expires_at)")).
+		WithArgs(sqlmock.AnyArg(), stagingID, 10, int(ts.uploadSessionTTL.Seconds())).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	ts.mock.ExpectQuery(regexp.QuoteMeta("SELECT size_bytes, received_bytes, expires_at FROM upload_sessions")).
+		WillReturnRows(sqlmock.NewRows([]string{"size_bytes", "received_bytes", "expires_at"}).AddRow(10, 0, time.Now().Add(time.Hour)))
+
+	if w := ts.do(http.MethodPost, "/albums/resumable", `{"size": 10}`, nil); w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+}
+
+// TestSweepExpiredSessionsScoped checks that the sweep only asks for this
+// directory's sessions, or long abandoned ones, and removes what it gets
+func TestSweepExpiredSessionsScoped(t *testing.T) {
+	const expired = "6f1c2a5e-0b8e-4c1d-9d7a-3e2f1b0c9a8d"
+	ts := newTestServer(t, func(cfg *Config) { cfg.UploadSessionDir = t.TempDir() })
+	stagingID, err := ts.stagingID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ts.sessionPath(expired), []byte("chunk"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ts.mock.ExpectQuery(regexp.QuoteMeta("FROM upload_sessions WHERE expires_at <= CURRENT_TIMESTAMP AND (staging_id = ? OR")).
+		WithArgs(stagingID, -int(abandonedSessionGrace.Seconds())).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(expired))
+	ts.mock.ExpectExec(regexp.QuoteMeta("DELETE FROM upload_sessions WHERE id = ?")).
+		WithArgs(expired).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := ts.sweepExpiredSessions(context.Background()); err != nil {
+		t.Fatalf("sweepExpiredSessions: %v", err)
+	}
+	if _, err := os.Stat(ts.sessionPath(expired)); !os.IsNotExist(err) {
+		t.Errorf("staged file of the swept session still exists: %v", err)
+	}
+}
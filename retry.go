@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// retryBaseDelay is the wait before the first retry; it doubles each attempt
+const retryBaseDelay = 50 * time.Millisecond
+
+// isTransient reports whether err is a MySQL error listed in
+// DB_RETRY_ERROR_CODES, such as a lock wait timeout or a deadlock
+func (s *Server) isTransient(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && s.retryCodes[mysqlErr.Number]
+}
+
+// withRetry runs fn until it succeeds, fails with a non-transient error or has
+// been tried DB_RETRY_MAX_ATTEMPTS times, backing off with jitter in between.
+// Only wrap work that is safe to repeat: reads and single statements. A
+// broken connection before a query is sent is already retried by database/sql.
+func (s *Server) withRetry(ctx context.Context, fn func(ctx context.Context) error) error {
+	delay := retryBaseDelay
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || attempt >= s.retryAttempts || !s.isTransient(err) {
+			return err
+		}
+
+		slog.WarnContext(ctx, "Retrying after transient DB error", "attempt", attempt, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay/2 + rand.N(delay/2+1)):
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestWithRetry(t *testing.T) {
+	deadlock := &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}
+	duplicate := &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}
+	boom := errors.New("boom")
+
+	tests := []struct {
+		name      string
+		errs      []error // returned by successive calls, then nil
+		wantCalls int
+		wantErr   error
+	}{
+		{"succeeds first time", nil, 1, nil},
+		{"transient then success", []error{deadlock}, 2, nil},
+		{"wrapped transient", []error{fmt.Errorf("query: %w", deadlock)}, 2, nil},
+		{"gives up after max attempts", []error{deadlock, deadlock, deadlock, deadlock}, 3, deadlock},
+		{"other MySQL error", []error{duplicate}, 1, duplicate},
+		{"not a MySQL error", []error{boom}, 1, boom},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{retryAttempts: 3, retryCodes: map[uint16]bool{1205: true, 1213: true}}
+			calls := 0
+			err := s.withRetry(context.Background(), func(ctx context.Context) error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWithRetryStopsWhenCancelled(t *testing.T) {
+	s := &Server{retryAttempts: 10, retryCodes: map[uint16]bool{1213: true}}
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	start := time.Now()
+	err := s.withRetry(ctx, func(ctx context.Context) error {
+		calls++
+		cancel()
+		return &mysql.MySQLError{Number: 1213}
+	})
+	if calls != 1 || err == nil || time.Since(start) > time.Second {
+		t.Errorf("calls = %d, err = %v after %v; want one call and the error", calls, err, time.Since(start))
+	}
+}
+
+func TestGetAlbumRetriesDeadlock(t *testing.T) {
+	ts := newTestServer(t, nil)
+	query := regexp.QuoteMeta("SELECT " + albumColumns + " FROM albums WHERE id = ?")
+	ts.mock.ExpectQuery(query).WithArgs(1).WillReturnError(&mysql.MySQLError{Number: 1213, Message: "Deadlock found"})
+	now := time.Now()
+	ts.mock.ExpectQuery(query).WithArgs(1).
+		WillReturnRows(albumRows().AddRow(1, nil, "mem/a.png", nil, nil, nil, nil, nil, nil,
+			`{"artist":"A","title":"T","year":"2001"}`, nil, nil, nil, 1, now, now, nil))
+	ts.mock.ExpectQuery(regexp.QuoteMeta("FROM album_images WHERE album_id IN (?)")).
+		WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"album_id", "id", "image_url", "position", "width", "height", "size_bytes", "created_at"}))
+
+	if w := ts.do(http.MethodGet, "/albums/1", "", nil); w.Code != http.StatusOK {
+		t.Errorf("status = %d, body %s", w.Code, w.Body)
+	}
+}
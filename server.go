@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/language"
+)
+
+// Server holds the dependencies shared by the HTTP handlers, so they can be
+// built against any database and storage backend instead of package globals
+type Server struct {
+	db      *sql.DB
+	storage Storage
+
+	// maxUploadBytes is the largest accepted image
+	maxUploadBytes int64
+	// maxAudioBytes is the largest accepted audio preview
+	maxAudioBytes int64
+	// maxImagePixels is the largest width×height decoded, guarding against
+	// decompression bombs
+	maxImagePixels int64
+	// maxFormParts caps the fields and files of a multipart upload form
+	maxFormParts int
+	// stripEXIF removes EXIF and similar metadata from uploads
+	stripEXIF bool
+	// autoOrient rotates JPEG uploads upright according to their EXIF orientation
+	autoOrient bool
+	// dedupUploads rejects uploads whose image is already stored
+	dedupUploads bool
+	// convertWebP serves WebP copies of local images to clients that accept them
+	convertWebP bool
+	// reencodeType is the content type every upload is stored as, with
+	// reencodeQuality for JPEG; empty stores uploads in their own format
+	reencodeType    string
+	reencodeQuality int
+	// originals keeps uploads as received before re-encoding; nil unless
+	// KEEP_ORIGINALS is set
+	originals Storage
+	// minFreeBytes is the free disk space local storage needs to stay ready
+	minFreeBytes int64
+	// directUploadTTL is how long a presigned direct upload stays valid
+	directUploadTTL time.Duration
+	// retryAttempts and retryCodes control retries of transient DB errors
+	retryAttempts int
+	retryCodes    map[uint16]bool
+	// albums caches single-album reads; nil when ALBUM_CACHE_ENABLED=false
+	albums *albumCache
+	// uploadSessionDir stages resumable uploads for uploadSessionTTL after
+	// their last chunk; chunkLocks serializes requests per session
+	uploadSessionDir string
+	uploadSessionTTL time.Duration
+	chunkLocks       *chunkLocks
+	// stagingIDValue caches the staging ID of uploadSessionDir once read
+	stagingMu      sync.Mutex
+	stagingIDValue string
+	// publicBaseURL prefixes the API URLs that replace local file paths in
+	// responses; empty leaves them relative
+	publicBaseURL string
+	// idempotencyTTL is how long an Idempotency-Key of POST /albums is remembered
+	idempotencyTTL time.Duration
+	// normalizedMetadata writes artist, title and year to their typed
+	// columns instead of the metadata JSON
+	normalizedMetadata bool
+	// defaultLocale is the locale of plain album titles, the fallback of
+	// Accept-Language matching
+	defaultLocale language.Tag
+	// uploadQuota caps the bytes each API key may upload; 0 is unlimited
+	uploadQuota int64
+	// placeholder is served for albums without an image; nil when
+	// PLACEHOLDER_IMAGE is unset
+	placeholder *placeholderImage
+	// fetchClient downloads source_url images, refusing internal addresses
+	fetchClient *http.Client
+	// webhooks sends album.created events; nil when WEBHOOK_URL is unset
+	webhooks *webhookNotifier
+	// storageProbe rate-limits the storage check of /health/ready
+	storageProbe storageProbe
+	// queryTimeout bounds each DB call so a hung MySQL can't pin goroutines
+	// and pool connections
+	queryTimeout time.Duration
+	// exportTimeout bounds the streaming query of an export, which
+	// queryTimeout and REQUEST_TIMEOUT would cut short; 0 leaves it unbounded
+	exportTimeout time.Duration
+}
+
+// newServer builds a Server from its dependencies and the upload and query
+// settings in cfg
+func newServer(db *sql.DB, storage Storage, cfg Config) *Server {
+	retryCodes := make(map[uint16]bool, len(cfg.DBRetryErrorCodes))
+	for _, code := range cfg.DBRetryErrorCodes {
+		retryCodes[uint16(code)] = true
+	}
+
+	return &Server{
+		db:                 db,
+		storage:            storage,
+		maxUploadBytes:     cfg.MaxUploadBytes,
+		maxAudioBytes:      cfg.MaxAudioBytes,
+		maxImagePixels:     cfg.MaxImagePixels,
+		maxFormParts:       cfg.MultipartMaxParts,
+		stripEXIF:          cfg.StripEXIF,
+		autoOrient:         cfg.AutoOrient,
+		dedupUploads:       cfg.DedupUploads,
+		convertWebP:        cfg.ConvertWebP,
+		reencodeType:       reencodeFormats[cfg.ReencodeFormat],
+		reencodeQuality:    cfg.ReencodeQuality,
+		queryTimeout:       cfg.DBQueryTimeout,
+		exportTimeout:      cfg.ExportTimeout,
+		retryAttempts:      cfg.DBRetryAttempts,
+		retryCodes:         retryCodes,
+		albums:             newAlbumCache(cfg.AlbumCacheEnabled, cfg.AlbumCacheMaxEntries, cfg.AlbumCacheTTL),
+		directUploadTTL:    cfg.DirectUploadTTL,
+		uploadSessionDir:   cfg.UploadSessionDir,
+		uploadSessionTTL:   cfg.UploadSessionTTL,
+		chunkLocks:         newChunkLocks(),
+		publicBaseURL:      strings.TrimSuffix(cfg.PublicBaseURL, "/"),
+		idempotencyTTL:     cfg.IdempotencyKeyTTL,
+		uploadQuota:        cfg.UploadQuotaBytes,
+		normalizedMetadata: cfg.MetadataStorage == "normalized",
+		defaultLocale:      language.Make(cfg.DefaultLocale),
+		fetchClient:        newFetchClient(cfg.SourceFetchTimeout),
+		webhooks:           newWebhookNotifier(cfg.WebhookURL, cfg.WebhookSecret, cfg.WebhookTimeout),
+		minFreeBytes:       cfg.StorageMinFreeBytes,
+	}
+}
+
+// queryContext derives a context that bounds a single DB call
+func (s *Server) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, s.queryTimeout)
+}
+
+// router builds the Gin engine with the middleware chain and every route
+func (s *Server) router(cfg Config) (*gin.Engine, error) {
+	// Setup Gin engine with structured request logging instead of Gin's text logger
+	if os.Getenv(gin.EnvGinMode) == "" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	r := gin.New()
+	// ClientIP, which keys the rate limiter and the logs, only believes
+	// X-Forwarded-For from TRUSTED_PROXIES; by default it is the peer address
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("TRUSTED_PROXIES: %v", err)
+	}
+	// Multipart data beyond this is spooled to temp files instead of memory
+	r.MaxMultipartMemory = cfg.MultipartMemoryBytes
+	r.Use(requestIDMiddleware(), tracingMiddleware(cfg.ServiceName), requestLogger(), metricsMiddleware(), recoveryMiddleware(), spanAttributes())
+
+	// CORS runs before rate limiting and auth so preflights are answered directly
+	r.Use(corsMiddleware(cfg.CORS))
+
+	// Per-IP token bucket; RATE_LIMIT_RPS=0 disables limiting
+	if cfg.RateLimitRPS > 0 {
+		r.Use(newIPRateLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst).middleware())
+	}
+
+	// Compress JSON and XML responses; GZIP_MIN_SIZE=0 compresses every such body
+	r.Use(gzipMiddleware(cfg.GzipMinSize))
+
+	// Bound each handler as a whole; REQUEST_TIMEOUT=0 disables the deadline
+	if cfg.RequestTimeout > 0 {
+		r.Use(requestTimeout(cfg.RequestTimeout, "/albums/export", "/albums/archive"))
+	}
+
+	// Inside gzip, so the logged response bodies are the uncompressed ones
+	if cfg.DebugLogBodies {
+		r.Use(logBodies(cfg.DebugLogBodyBytes))
+	}
+
+	r.GET("/metrics", metricsHandler())
+
+	// Mutating routes go through the configured authenticator; reads stay
+	// public unless AUTH_PROTECT_READS=true
+	requireWrite, err := newAuthMiddleware(cfg.AuthMode, cfg.JWTSecret, cfg.APIKeys)
+	if err != nil {
+		return nil, err
+	}
+	// JSON bodies get their own cap; multipart uploads are bounded by formImage
+	limitJSON := limitJSONBody(cfg.MaxJSONBytes)
+
+	requireRead := gin.HandlerFunc(noAuth)
+	if cfg.AuthProtectReads {
+		requireRead = requireWrite
+	}
+
+	// Health check routes: /health is kept as an alias of the readiness probe
+	r.GET("/health", s.healthReady)
+	r.GET("/health/live", healthLive)
+	r.GET("/health/ready", s.healthReady)
+	r.GET("/version", versionHandler)
+
+	// API documentation: Swagger UI at /docs, raw spec at /docs/openapi.json
+	r.GET("/docs", docsHandler)
+	r.GET("/docs/openapi.json", openAPIHandler)
+
+	r.POST("/albums", requireWrite, limitJSON, s.idempotent(), s.createAlbum)
+	r.POST("/albums/batch", requireWrite, limitJSON, s.createAlbumBatch)
+	r.POST("/albums/validate", requireWrite, limitJSON, validateAlbum)
+	r.POST("/albums/upload-url", requireWrite, limitJSON, s.requireQuota(), s.createUploadURL)
+	r.POST("/albums/uploads/:uploadID/confirm", requireWrite, limitJSON, s.requireQuota(), s.confirmUpload)
+	r.POST("/albums/resumable", requireWrite, limitJSON, s.requireQuota(), s.createUploadSession)
+	r.GET("/albums/resumable/:uploadID", requireWrite, s.getUploadSession)
+	r.PATCH("/albums/resumable/:uploadID", requireWrite, s.requireQuota(), s.appendUploadChunk)
+	r.POST("/albums/resumable/:uploadID/complete", requireWrite, limitJSON, s.requireQuota(), s.completeUploadSession)
+	r.GET("/albums", requireRead, s.listAlbums)
+	r.GET("/albums/count", requireRead, s.countAlbums)
+	r.GET("/albums/export", requireRead, s.exportAlbums)
+	r.GET("/albums/random", requireRead, s.randomAlbum)
+	r.POST("/albums/archive", requireRead, limitJSON, s.archiveAlbums)
+	r.GET("/albums/:albumID", requireRead, s.getAlbum)
+	r.GET("/albums/:albumID/image", requireRead, s.getAlbumImage)
+	r.PUT("/albums/:albumID/image", requireWrite, s.requireQuota(), s.replaceAlbumImage)
+	r.GET("/albums/:albumID/thumbnail", requireRead, s.getAlbumThumbnail)
+	r.GET("/albums/:albumID/audio", requireRead, s.getAlbumAudio)
+	r.PUT("/albums/:albumID", requireWrite, limitJSON, s.updateAlbum)
+	r.PATCH("/albums/:albumID", requireWrite, limitJSON, s.patchAlbum)
+	r.DELETE("/albums", requireWrite, limitJSON, s.deleteAlbums)
+	r.DELETE("/albums/:albumID", requireWrite, s.deleteAlbum)
+	r.POST("/albums/:albumID/restore", requireWrite, s.restoreAlbum)
+	r.GET("/albums/:albumID/images", requireRead, s.listAlbumImages)
+	r.POST("/albums/:albumID/images", requireWrite, s.requireQuota(), s.addAlbumImage)
+	r.GET("/albums/:albumID/images/:imageID", requireRead, s.getAlbumImageFile)
+	r.DELETE("/albums/:albumID/images/:imageID", requireWrite, s.deleteAlbumImage)
+
+	r.GET("/usage", requireWrite, s.getUsage)
+
+	// Maintenance: reclaim disk space from files left behind by crashes
+	r.DELETE("/admin/orphaned-files", requireWrite, s.deleteOrphanedFiles)
+	// One-time copy of local files to a new STORAGE_BACKEND, in resumable batches
+	r.POST("/admin/storage-migration", requireWrite, s.migrateStorage)
+
+	if cfg.EnablePprof {
+		registerPprof(r, requireWrite)
+	}
+
+	return r, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+// TestMain keeps the warnings that tests provoke on purpose out of the output
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// memStorage is an in-memory Storage and Trasher for handler tests
+type memStorage struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	deleted []string
+	trashed []string
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{objects: map[string][]byte{}}
+}
+
+func (m *memStorage) Save(ctx context.Context, filename, contentType string, r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	url := "mem/" + filename
+	m.objects[url] = data
+	return url, nil
+}
+
+func (m *memStorage) Open(ctx context.Context, url string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.objects[url]
+	if !ok {
+		return nil, errObjectNotFound
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *memStorage) Delete(ctx context.Context, url string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, url)
+	m.deleted = append(m.deleted, url)
+	return nil
+}
+
+func (m *memStorage) Trash(ctx context.Context, url string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.trashed = append(m.trashed, url)
+	return nil
+}
+
+func (m *memStorage) StorageHealth(ctx context.Context) error {
+	return nil
+}
+
+// testConfig loads the default configuration with a placeholder DSN, then
+// lets the test adjust it
+func testConfig(t *testing.T, adjust func(*Config)) Config {
+	t.Helper()
+	t.Setenv("DB_DSN", "test:test@tcp(127.0.0.1:3306)/albums")
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if adjust != nil {
+		adjust(&cfg)
+	}
+	return cfg
+}
+
+// testServer is a Server over sqlmock and memStorage with its router
+type testServer struct {
+	*Server
+	mock    sqlmock.Sqlmock
+	storage *memStorage
+	router  *gin.Engine
+}
+
+// newTestServer builds a testServer from testConfig. Unmet sqlmock
+// expectations fail the test when it ends.
+func newTestServer(t *testing.T, adjust func(*Config)) *testServer {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+
+	cfg := testConfig(t, adjust)
+	storage := newMemStorage()
+	s := newServer(db, storage, cfg)
+	r, err := s.router(cfg)
+	if err != nil {
+		t.Fatalf("router: %v", err)
+	}
+	return &testServer{Server: s, mock: mock, storage: storage, router: r}
+}
+
+// do sends a request through the router and returns the recorded response
+func (ts *testServer) do(method, target, body string, header http.Header) *httptest.ResponseRecorder {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, r)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	ts.router.ServeHTTP(w, req)
+	return w
+}
+
+// albumRows returns the albumColumns of scanAlbum as sqlmock rows
+func albumRows() *sqlmock.Rows {
+	return sqlmock.NewRows(strings.Split(strings.ReplaceAll(albumColumns, " ", ""), ","))
+}
+
+// newURL matches a URL the test's memStorage stored
+type newURL struct{}
+
+func (newURL) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	return ok && strings.HasPrefix(s, "mem/")
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "albumstore_db_query_duration_seconds",
+	Help:    "DB statement latency until the first rows arrive, by query name such as \"select albums\".",
+	Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+}, []string{"query"})
+
+// maxLoggedStatement caps the statement text of a slow query warning
+const maxLoggedStatement = 300
+
+// queryName names a statement by its verb and first table, e.g.
+// "select albums" or "insert album_images", short and bounded enough to be
+// a metric label. Statements without a recognizable table, such as BEGIN,
+// are named by their verb alone.
+func queryName(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "unknown"
+	}
+	verb := strings.ToLower(fields[0])
+
+	// The table follows the first FROM, INTO or, for UPDATE, the verb itself
+	after := map[string]string{"select": "from", "delete": "from", "insert": "into", "replace": "into", "update": "update"}[verb]
+	if after == "" {
+		return verb
+	}
+	for i := 0; i+1 < len(fields); i++ {
+		// A derived table is skipped for the FROM inside it
+		if strings.ToLower(fields[i]) == after && !strings.HasPrefix(fields[i+1], "(") {
+			table := strings.Trim(fields[i+1], "`),;")
+			if table == "" {
+				break
+			}
+			return verb + " " + strings.ToLower(table)
+		}
+	}
+	return verb
+}
+
+// queryTimer records the duration of each statement in dbQueryDuration and
+// warns about those slower than threshold; 0 disables the warning. Only the
+// query name, the statement with its placeholders and the number of
+// arguments are logged, never the argument values.
+type queryTimer struct {
+	threshold time.Duration
+}
+
+func (t queryTimer) observe(ctx context.Context, query string, args int, start time.Time, err error) {
+	// The driver declines some calls so database/sql retries them prepared;
+	// those are timed when the statement runs
+	if errors.Is(err, driver.ErrSkip) {
+		return
+	}
+	elapsed := time.Since(start)
+	name := queryName(query)
+	dbQueryDuration.WithLabelValues(name).Observe(elapsed.Seconds())
+
+	if t.threshold > 0 && elapsed >= t.threshold {
+		statement := strings.Join(strings.Fields(query), " ")
+		if len(statement) > maxLoggedStatement {
+			statement = statement[:maxLoggedStatement] + "..."
+		}
+		slog.WarnContext(ctx, "Slow DB query", "query", name, "statement", statement, "args", args,
+			"duration_ms", elapsed.Milliseconds(), "threshold_ms", t.threshold.Milliseconds())
+	}
+}
+
+// timedConnector wraps a driver.Connector so every statement run on its
+// connections, inside transactions and through prepared statements too, is
+// timed by queryTimer. Whatever the wrapped connection implements is passed
+// straight through, so database/sql takes the same paths as without it.
+type timedConnector struct {
+	driver.Connector
+	timer queryTimer
+}
+
+func (c timedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &timedConn{conn: conn, timer: c.timer}, nil
+}
+
+// timedConn is a connection of timedConnector. It implements the optional
+// interfaces of go-sql-driver/mysql connections by delegating to them.
+type timedConn struct {
+	conn  driver.Conn
+	timer queryTimer
+}
+
+func (c *timedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *timedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if p, ok := c.conn.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &timedStmt{stmt: stmt, query: query, timer: c.timer}, nil
+}
+
+func (c *timedConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *timedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *timedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.conn.Begin()
+}
+
+func (c *timedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := q.QueryContext(ctx, query, args)
+	c.timer.observe(ctx, query, len(args), start, err)
+	return rows, err
+}
+
+func (c *timedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	res, err := e.ExecContext(ctx, query, args)
+	c.timer.observe(ctx, query, len(args), start, err)
+	return res, err
+}
+
+func (c *timedConn) Ping(ctx context.Context) error {
+	if p, ok := c.conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *timedConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *timedConn) IsValid() bool {
+	if v, ok := c.conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *timedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := c.conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// timedStmt is a prepared statement of timedConn
+type timedStmt struct {
+	stmt  driver.Stmt
+	query string
+	timer queryTimer
+}
+
+func (s *timedStmt) Close() error {
+	return s.stmt.Close()
+}
+
+func (s *timedStmt) NumInput() int {
+	return s.stmt.NumInput()
+}
+
+func (s *timedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	start := time.Now()
+	res, err := s.stmt.Exec(args)
+	s.timer.observe(context.Background(), s.query, len(args), start, err)
+	return res, err
+}
+
+func (s *timedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	start := time.Now()
+	rows, err := s.stmt.Query(args)
+	s.timer.observe(context.Background(), s.query, len(args), start, err)
+	return rows, err
+}
+
+func (s *timedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := s.stmt.(driver.StmtExecContext)
+	if !ok {
+		return s.Exec(values(args))
+	}
+	start := time.Now()
+	res, err := e.ExecContext(ctx, args)
+	s.timer.observe(ctx, s.query, len(args), start, err)
+	return res, err
+}
+
+func (s *timedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := s.stmt.(driver.StmtQueryContext)
+	if !ok {
+		return s.Query(values(args))
+	}
+	start := time.Now()
+	rows, err := q.QueryContext(ctx, args)
+	s.timer.observe(ctx, s.query, len(args), start, err)
+	return rows, err
+}
+
+func (s *timedStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := s.stmt.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// values drops the names of statement arguments for the pre-context Stmt API
+func values(args []driver.NamedValue) []driver.Value {
+	out := make([]driver.Value, len(args))
+	for i, arg := range args {
+		out[i] = arg.Value
+	}
+	return out
+}
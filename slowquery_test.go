@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestQueryName(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT " + albumColumns + " FROM albums WHERE id = ?", "select albums"},
+		{"select count(*)\n\tfrom `album_images` where album_id = ?", "select album_images"},
+		{"INSERT INTO album_images (album_id, image_url) VALUES (?, ?)", "insert album_images"},
+		{"REPLACE INTO idempotency_keys (k) VALUES (?)", "replace idempotency_keys"},
+		{"UPDATE albums SET deleted_at = CURRENT_TIMESTAMP WHERE id = ?", "update albums"},
+		{"DELETE FROM pending_uploads WHERE id = ?", "delete pending_uploads"},
+		{"SELECT n FROM (SELECT COUNT(*) AS n FROM albums) t", "select albums"},
+		{"SELECT 1", "select"},
+		{"BEGIN", "begin"},
+		{"   ", "unknown"},
+		{"", "unknown"},
+	}
+	for _, tt := range tests {
+		if got := queryName(tt.query); got != tt.want {
+			t.Errorf("queryName(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Storage persists uploaded images and returns the URL recorded for them
+type Storage interface {
+	// Save writes the content under filename and returns its URL.
+	// contentType is the sniffed type of the content, for backends that
+	// serve objects directly.
+	Save(ctx context.Context, filename, contentType string, r io.Reader) (string, error)
+	// Open reads the object behind a URL returned by Save, or returns
+	// errObjectNotFound if it is gone
+	Open(ctx context.Context, url string) (io.ReadCloser, error)
+	// Delete removes the object previously returned by Save
+	Delete(ctx context.Context, url string) error
+	// StorageHealth writes, reads back and deletes a tiny probe object to
+	// confirm the backend is reachable and writable
+	StorageHealth(ctx context.Context) error
+}
+
+// contextReader fails reads once ctx is done, so a copy stops when its
+// request runs out of time
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// healthProbeData is the content of the StorageHealth probe object
+var healthProbeData = []byte("album-store health probe")
+
+// DirectUploader is implemented by backends that clients can upload to
+// without routing the bytes through this server
+type DirectUploader interface {
+	// PresignUpload returns a URL that accepts a PUT of key's content with the
+	// given Content-Type until ttl elapses
+	PresignUpload(key, contentType string, ttl time.Duration) (string, error)
+	// StatUpload returns the URL and size of an uploaded key, or
+	// errUploadNotFound if nothing has been uploaded there
+	StatUpload(ctx context.Context, key string) (string, int64, error)
+	// DeleteUpload removes an uploaded key, ignoring keys that don't exist
+	DeleteUpload(ctx context.Context, key string) error
+	// ReadUpload returns up to the first n bytes of an uploaded key
+	ReadUpload(ctx context.Context, key string, n int64) ([]byte, error)
+}
+
+var errUploadNotFound = errors.New("upload not found")
+
+// Trasher is implemented by backends the orphan sweep doesn't reach. Objects
+// an album stops referencing are moved aside rather than deleted, so readers
+// still holding the old URL, e.g. from another replica's album cache, aren't
+// cut off and a mistaken replacement can be undone.
+type Trasher interface {
+	// Trash moves the object behind a URL returned by Save under trashPrefix
+	Trash(ctx context.Context, url string) error
+}
+
+// trashPrefix is where Trash moves objects, for a bucket lifecycle rule to
+// expire them
+const trashPrefix = "trash"
+
+var errObjectNotFound = errors.New("stored file not found")
+
+// maxShardDepth caps STORAGE_SHARD_DEPTH; three levels already spread files
+// over 16.7 million directories
+const maxShardDepth = 3
+
+// LocalStorage keeps images on the local file system
+type LocalStorage struct {
+	Dir string
+	// ShardDepth nests files under that many two-character directories taken
+	// from a hash of the filename, e.g. images/ab/cd/<name> for depth 2
+	ShardDepth int
+}
+
+// NewLocalStorage returns a LocalStorage rooted at dir
+func NewLocalStorage(dir string, shardDepth int) *LocalStorage {
+	return &LocalStorage{Dir: dir, ShardDepth: shardDepth}
+}
+
+// shardDir returns the directory filename is saved in. Stored URLs are full
+// paths, so changing the depth only affects new files.
+func (s *LocalStorage) shardDir(filename string) string {
+	sum := sha256.Sum256([]byte(filename))
+	prefix := hex.EncodeToString(sum[:s.ShardDepth])
+
+	dir := s.Dir
+	for i := 0; i < len(prefix); i += 2 {
+		dir = filepath.Join(dir, prefix[i:i+2])
+	}
+	return dir
+}
+
+// errUnsafeFilename rejects names that could resolve outside the storage directory
+var errUnsafeFilename = errors.New("unsafe storage filename")
+
+// Save writes the image into the storage directory and returns its path.
+// filename must be a plain name; callers generate it, but anything with a
+// directory component is refused so it can never escape the directory. The
+// content type is not kept: serveImage sniffs files as it serves them.
+func (s *LocalStorage) Save(ctx context.Context, filename, contentType string, r io.Reader) (string, error) {
+	if !safeFilename(filename) {
+		return "", fmt.Errorf("%w: %q", errUnsafeFilename, filename)
+	}
+	dir := s.shardDir(filename)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("failed to create image directory: %v", err)
+	}
+
+	// Write to a temp file and rename it into place so a failed or interrupted
+	// write never leaves a partial image at the final path
+	filePath := filepath.Join(dir, filename)
+	out, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to save image: %v", err)
+	}
+	tmpPath := out.Name()
+
+	if _, err = io.Copy(out, contextReader{ctx: ctx, r: r}); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to write image file: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to write image file: %v", err)
+	}
+
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to save image: %v", err)
+	}
+
+	return filePath, nil
+}
+
+// safeFilename reports whether name is a single path element that stays in
+// its directory: not empty, "." or "..", and free of separators and NUL
+func safeFilename(name string) bool {
+	return name != "" && name != "." && name != ".." &&
+		!strings.ContainsAny(name, "/\\\x00") && filepath.Base(name) == name
+}
+
+// Open opens a previously saved file
+func (s *LocalStorage) Open(ctx context.Context, url string) (io.ReadCloser, error) {
+	f, err := os.Open(url)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errObjectNotFound
+	}
+	return f, err
+}
+
+// Delete removes a previously saved image, ignoring files that are already gone
+func (s *LocalStorage) Delete(ctx context.Context, url string) error {
+	if url == "" {
+		return nil
+	}
+
+	if err := os.Remove(url); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove image file: %v", err)
+	}
+
+	return nil
+}
+
+// StorageHealth round-trips a probe file through the storage directory
+func (s *LocalStorage) StorageHealth(ctx context.Context) error {
+	if err := os.MkdirAll(s.Dir, os.ModePerm); err != nil {
+		return fmt.Errorf("storage directory unavailable: %v", err)
+	}
+
+	f, err := os.CreateTemp(s.Dir, ".health-*")
+	if err != nil {
+		return fmt.Errorf("storage directory not writable: %v", err)
+	}
+	_, err = f.Write(healthProbeData)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("storage directory not writable: %v", err)
+	}
+
+	data, readErr := os.ReadFile(f.Name())
+	if err := os.Remove(f.Name()); err != nil {
+		return fmt.Errorf("storage directory does not allow deletes: %v", err)
+	}
+	if readErr != nil {
+		return fmt.Errorf("storage directory not readable: %v", readErr)
+	}
+	if !bytes.Equal(data, healthProbeData) {
+		return errors.New("storage probe read back different content")
+	}
+	return nil
+}
+
+// newStorage creates the backend selected by Config.StorageBackend
+func newStorage(cfg Config) (Storage, error) {
+	switch cfg.StorageBackend {
+	case "", "local":
+		return NewLocalStorage("./images", cfg.StorageShardDepth), nil
+	case "s3":
+		return NewS3Storage(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.StorageBackend)
+	}
+}
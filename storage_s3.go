@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/google/uuid"
+)
+
+// S3Config describes where S3Storage keeps its objects.
+//
+// Credentials come from the standard AWS chain (env vars, shared config,
+// instance role). To run against MinIO locally, start it with
+// `docker run -p 9000:9000 minio/minio server /data`, create the bucket,
+// export AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY with the MinIO credentials and
+// set S3_ENDPOINT=http://localhost:9000 and S3_FORCE_PATH_STYLE=true.
+type S3Config struct {
+	Bucket         string
+	Region         string
+	Prefix         string
+	Endpoint       string
+	ForcePathStyle bool
+}
+
+// S3Storage keeps images in an S3 (or S3-compatible) bucket
+type S3Storage struct {
+	cfg      S3Config
+	client   *s3.S3
+	uploader *s3manager.Uploader
+}
+
+// NewS3Storage creates an S3Storage using the default credential chain
+func NewS3Storage(cfg S3Config) (*S3Storage, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("S3 bucket not set")
+	}
+
+	awsCfg := aws.NewConfig().WithS3ForcePathStyle(cfg.ForcePathStyle)
+	if cfg.Region != "" {
+		awsCfg = awsCfg.WithRegion(cfg.Region)
+	}
+	if cfg.Endpoint != "" {
+		awsCfg = awsCfg.WithEndpoint(cfg.Endpoint)
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *awsCfg,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session: %v", err)
+	}
+
+	return &S3Storage{
+		cfg:      cfg,
+		client:   s3.New(sess),
+		uploader: s3manager.NewUploader(sess),
+	}, nil
+}
+
+// Save uploads the image and returns the object's URL. The object carries
+// contentType, so the bucket serves it as an image rather than as
+// binary/octet-stream.
+func (s *S3Storage) Save(ctx context.Context, filename, contentType string, r io.Reader) (string, error) {
+	out, err := s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:      aws.String(s.cfg.Bucket),
+		Key:         aws.String(s.key(filename)),
+		ContentType: aws.String(contentType),
+		Body:        r,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload image: %v", err)
+	}
+
+	return out.Location, nil
+}
+
+// Open streams the object behind a URL returned by Save
+func (s *S3Storage) Open(ctx context.Context, objectURL string) (io.ReadCloser, error) {
+	key, err := s.keyFromURL(objectURL)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var reqErr awserr.RequestFailure
+		if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotFound {
+			return nil, errObjectNotFound
+		}
+		return nil, fmt.Errorf("failed to read object: %v", err)
+	}
+	return out.Body, nil
+}
+
+// Delete removes the object behind a URL returned by Save
+func (s *S3Storage) Delete(ctx context.Context, objectURL string) error {
+	if objectURL == "" {
+		return nil
+	}
+
+	key, err := s.keyFromURL(objectURL)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete image: %v", err)
+	}
+
+	return nil
+}
+
+// Trash copies the object behind a URL returned by Save to the same name
+// under S3_PREFIX/trash, then deletes the original
+func (s *S3Storage) Trash(ctx context.Context, objectURL string) error {
+	key, err := s.keyFromURL(objectURL)
+	if err != nil {
+		return err
+	}
+
+	source := url.URL{Path: s.cfg.Bucket + "/" + key}
+	_, err = s.client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(s.cfg.Bucket),
+		Key:        aws.String(s.key(path.Join(trashPrefix, path.Base(key)))),
+		CopySource: aws.String(source.EscapedPath()),
+	})
+	if err != nil {
+		var reqErr awserr.RequestFailure
+		if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotFound {
+			return nil
+		}
+		return fmt.Errorf("failed to move image to trash: %v", err)
+	}
+	return s.Delete(ctx, objectURL)
+}
+
+// PresignUpload returns a presigned PUT URL for the key under the prefix
+func (s *S3Storage) PresignUpload(key, contentType string, ttl time.Duration) (string, error) {
+	req, _ := s.client.PutObjectRequest(&s3.PutObjectInput{
+		Bucket:      aws.String(s.cfg.Bucket),
+		Key:         aws.String(s.key(key)),
+		ContentType: aws.String(contentType),
+	})
+	u, err := req.Presign(ttl)
+	if err != nil {
+		return "", fmt.Errorf("failed to presign upload: %v", err)
+	}
+	return u, nil
+}
+
+// StatUpload looks up a directly uploaded object and returns its URL and size
+func (s *S3Storage) StatUpload(ctx context.Context, key string) (string, int64, error) {
+	out, err := s.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	if err != nil {
+		var reqErr awserr.RequestFailure
+		if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotFound {
+			return "", 0, errUploadNotFound
+		}
+		return "", 0, fmt.Errorf("failed to look up upload: %v", err)
+	}
+
+	// Build the plain object URL the same way the uploader reports Location
+	req, _ := s.client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	if err := req.Build(); err != nil {
+		return "", 0, fmt.Errorf("failed to build object URL: %v", err)
+	}
+
+	return req.HTTPRequest.URL.String(), aws.Int64Value(out.ContentLength), nil
+}
+
+// ReadUpload fetches up to the first n bytes of a directly uploaded object
+// with a ranged GET
+func (s *S3Storage) ReadUpload(ctx context.Context, key string, n int64) ([]byte, error) {
+	out, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(s.key(key)),
+		Range:  aws.String(fmt.Sprintf("bytes=0-%d", n-1)),
+	})
+	if err != nil {
+		var reqErr awserr.RequestFailure
+		if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotFound {
+			return nil, errUploadNotFound
+		}
+		return nil, fmt.Errorf("failed to read upload: %v", err)
+	}
+	defer out.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(out.Body, n))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload: %v", err)
+	}
+	return data, nil
+}
+
+// DeleteUpload removes a directly uploaded object
+func (s *S3Storage) DeleteUpload(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete upload: %v", err)
+	}
+	return nil
+}
+
+// StorageHealth puts, gets and deletes a probe object under the prefix,
+// which confirms the credentials and bucket permissions the service needs
+func (s *S3Storage) StorageHealth(ctx context.Context) error {
+	key := aws.String(s.key(".health/" + uuid.NewString()))
+
+	_, err := s.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    key,
+		Body:   bytes.NewReader(healthProbeData),
+	})
+	if err != nil {
+		return fmt.Errorf("bucket not writable: %v", err)
+	}
+
+	readErr := s.readProbe(ctx, key)
+	// Delete even after a failed read so probes don't pile up in the bucket
+	_, err = s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    key,
+	})
+	if readErr != nil {
+		return readErr
+	}
+	if err != nil {
+		return fmt.Errorf("bucket does not allow deletes: %v", err)
+	}
+	return nil
+}
+
+// readProbe checks that the probe object at key reads back intact
+func (s *S3Storage) readProbe(ctx context.Context, key *string) error {
+	out, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    key,
+	})
+	if err != nil {
+		return fmt.Errorf("bucket not readable: %v", err)
+	}
+	defer out.Body.Close()
+
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return fmt.Errorf("bucket not readable: %v", err)
+	}
+	if !bytes.Equal(data, healthProbeData) {
+		return errors.New("storage probe read back different content")
+	}
+	return nil
+}
+
+// key returns the object key for a filename under the configured prefix
+func (s *S3Storage) key(filename string) string {
+	return path.Join(s.cfg.Prefix, filename)
+}
+
+// keyFromURL recovers the object key from a URL produced by the uploader
+func (s *S3Storage) keyFromURL(objectURL string) (string, error) {
+	u, err := url.Parse(objectURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid object URL %q: %v", objectURL, err)
+	}
+
+	key := strings.TrimPrefix(u.Path, "/")
+	if s.cfg.ForcePathStyle {
+		key = strings.TrimPrefix(key, s.cfg.Bucket+"/")
+	}
+	if key == "" {
+		return "", fmt.Errorf("invalid object URL %q: missing key", objectURL)
+	}
+
+	return key, nil
+}
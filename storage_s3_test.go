@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeS3 answers S3 object requests on a path-style endpoint and records them
+func fakeS3(t *testing.T) (*S3Storage, *[]*http.Request) {
+	t.Helper()
+	var requests []*http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		if r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "" {
+			w.Write([]byte(`<CopyObjectResult><ETag>"x"</ETag></CopyObjectResult>`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	s, err := NewS3Storage(S3Config{Bucket: "albums", Region: "us-east-1", Prefix: "images", Endpoint: srv.URL, ForcePathStyle: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, &requests
+}
+
+func TestS3SaveSetsContentType(t *testing.T) {
+	s, requests := fakeS3(t)
+
+	url, err := s.Save(context.Background(), "cover.png", "image/png", strings.NewReader("png data"))
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if !strings.HasSuffix(url, "/albums/images/cover.png") {
+		t.Errorf("url = %q", url)
+	}
+	if len(*requests) != 1 {
+		t.Fatalf("%d requests, want 1", len(*requests))
+	}
+	if got := (*requests)[0].Header.Get("Content-Type"); got != "image/png" {
+		t.Errorf("Content-Type = %q, want image/png", got)
+	}
+}
+
+func TestS3TrashMovesObject(t *testing.T) {
+	s, requests := fakeS3(t)
+
+	if err := s.Trash(context.Background(), "http://s3.example.com/albums/images/old.jpg"); err != nil {
+		t.Fatalf("Trash: %v", err)
+	}
+	if len(*requests) != 2 {
+		t.Fatalf("%d requests, want a copy and a delete", len(*requests))
+	}
+	copyReq, deleteReq := (*requests)[0], (*requests)[1]
+	if copyReq.Method != http.MethodPut || copyReq.URL.Path != "/albums/images/trash/old.jpg" ||
+		copyReq.Header.Get("X-Amz-Copy-Source") != "albums/images/old.jpg" {
+		t.Errorf("copy = %s %s from %q", copyReq.Method, copyReq.URL.Path, copyReq.Header.Get("X-Amz-Copy-Source"))
+	}
+	if deleteReq.Method != http.MethodDelete || deleteReq.URL.Path != "/albums/images/old.jpg" {
+		t.Errorf("delete = %s %s", deleteReq.Method, deleteReq.URL.Path)
+	}
+}
+
+func TestS3ReadUploadRequestsRange(t *testing.T) {
+	s, requests := fakeS3(t)
+
+	if _, err := s.ReadUpload(context.Background(), "k.png", 1024); err != nil {
+		t.Fatalf("ReadUpload: %v", err)
+	}
+	if len(*requests) != 1 {
+		t.Fatalf("%d requests, want 1", len(*requests))
+	}
+	req := (*requests)[0]
+	if req.Method != http.MethodGet || req.URL.Path != "/albums/images/k.png" || req.Header.Get("Range") != "bytes=0-1023" {
+		t.Errorf("request = %s %s with Range %q", req.Method, req.URL.Path, req.Header.Get("Range"))
+	}
+}
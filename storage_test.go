@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+func TestLocalStorageShardsFiles(t *testing.T) {
+	const name = "3f2a9c1e-7b44-4c1f-9a3e-2d8b6f0e1c55.jpg"
+	sum := sha256.Sum256([]byte(name))
+	prefix := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		depth   int
+		wantDir []string
+	}{
+		{0, nil},
+		{1, []string{prefix[0:2]}},
+		{2, []string{prefix[0:2], prefix[2:4]}},
+		{3, []string{prefix[0:2], prefix[2:4], prefix[4:6]}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("depth %d", tt.depth), func(t *testing.T) {
+			root := t.TempDir()
+			s := NewLocalStorage(root, tt.depth)
+
+			url, err := s.Save(context.Background(), name, "image/jpeg", strings.NewReader("jpeg bytes"))
+			if err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+			want := filepath.Join(append(append([]string{root}, tt.wantDir...), name)...)
+			if url != want {
+				t.Errorf("url = %q, want %q", url, want)
+			}
+
+			// The stored path is all serving needs to find the file again
+			data, err := os.ReadFile(url)
+			if err != nil || string(data) != "jpeg bytes" {
+				t.Errorf("read back %q, %v", data, err)
+			}
+
+			// Only the file itself is left behind, no temp files
+			entries, _ := os.ReadDir(filepath.Dir(url))
+			if len(entries) != 1 {
+				t.Errorf("%d entries in %s, want just the image", len(entries), filepath.Dir(url))
+			}
+		})
+	}
+}
+
+func TestLocalStorageShardIsStable(t *testing.T) {
+	s := NewLocalStorage("images", 2)
+	if a, b := s.shardDir("a.jpg"), s.shardDir("a.jpg"); a != b {
+		t.Errorf("shardDir differs between calls: %q and %q", a, b)
+	}
+	if s.shardDir("a.jpg") == s.shardDir("b.jpg") {
+		t.Errorf("a.jpg and b.jpg share shard %q", s.shardDir("a.jpg"))
+	}
+}
+
+func TestServeShardedImage(t *testing.T) {
+	ts := newTestServer(t, nil)
+	local := NewLocalStorage(t.TempDir(), 2)
+	ts.Server.storage = local
+	url, err := local.Save(context.Background(), "cover.png", "image/png", strings.NewReader("\x89PNG\r\n\x1a\nrest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	ts.mock.ExpectQuery(regexp.QuoteMeta("SELECT " + albumColumns + " FROM albums WHERE id = ?")).
+		WithArgs(1).
+		WillReturnRows(albumRows().AddRow(1, nil, url, nil, nil, "abc", nil, nil, nil,
+			`{"artist":"A","title":"T","year":"2001"}`, nil, nil, nil, 1, now, now, nil))
+	ts.mock.ExpectQuery(regexp.QuoteMeta("FROM album_images WHERE album_id IN (?)")).
+		WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"album_id", "id", "image_url", "position", "width", "height", "size_bytes", "created_at"}))
+
+	w := ts.do(http.MethodGet, "/albums/1/image", "", nil)
+	if w.Code != http.StatusOK || w.Body.String() != "\x89PNG\r\n\x1a\nrest" {
+		t.Errorf("status = %d, body %q", w.Code, w.Body)
+	}
+}
+
+func TestSafeFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"cover.jpg", true},
+		{"3f2a9c1e-7b44-4c1f-9a3e-2d8b6f0e1c55.webp", true},
+		{"..cover.jpg", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"../cover.jpg", false},
+		{"../../etc/cron.d/x", false},
+		{"sub/cover.jpg", false},
+		{"/etc/passwd", false},
+		{`..\..\windows\win.ini`, false},
+		{"cover.jpg\x00.png", false},
+	}
+	for _, tt := range tests {
+		if got := safeFilename(tt.name); got != tt.want {
+			t.Errorf("safeFilename(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLocalStorageRefusesTraversal(t *testing.T) {
+	parent := t.TempDir()
+	root := filepath.Join(parent, "images")
+	s := NewLocalStorage(root, 0)
+
+	for _, name := range []string{"../escaped.jpg", "../../escaped.jpg", "a/../../escaped.jpg", "/tmp/escaped.jpg", ".."} {
+		if _, err := s.Save(context.Background(), name, "image/jpeg", strings.NewReader("x")); !errors.Is(err, errUnsafeFilename) {
+			t.Errorf("Save(%q) err = %v, want errUnsafeFilename", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(parent, "escaped.jpg")); !os.IsNotExist(err) {
+		t.Errorf("file escaped the storage directory: %v", err)
+	}
+}
+
+// TestUploadIgnoresClientFilename checks that a traversal attempt in the
+// uploaded filename neither picks the stored name nor reaches storage
+func TestUploadIgnoresClientFilename(t *testing.T) {
+	var pngData bytes.Buffer
+	png.Encode(&pngData, image.NewRGBA(image.Rect(0, 0, 2, 2)))
+
+	for _, filename := range []string{"../../etc/cron.d/x.png", `..\..\x.html`, "/etc/passwd", "cover.php"} {
+		t.Run(filename, func(t *testing.T) {
+			ts := newTestServer(t, func(cfg *Config) { cfg.DedupUploads = false })
+			root := filepath.Join(t.TempDir(), "images")
+			local := NewLocalStorage(root, 1)
+			ts.Server.storage = local
+			m := ts.mock
+			m.ExpectBegin()
+			m.ExpectExec(regexp.QuoteMeta("INSERT INTO albums (")).WillReturnResult(sqlmock.NewResult(1, 1))
+			m.ExpectExec(regexp.QuoteMeta("INSERT INTO album_images")).WillReturnResult(sqlmock.NewResult(1, 1))
+			m.ExpectCommit()
+			now := time.Now()
+			m.ExpectQuery(regexp.QuoteMeta("SELECT " + albumColumns + " FROM albums WHERE id = ?")).
+				WithArgs(1).
+				WillReturnRows(albumRows().AddRow(1, nil, "x", nil, nil, nil, nil, nil, nil,
+					`{"artist":"A","title":"T","year":""}`, nil, nil, nil, 1, now, now, nil))
+			m.ExpectQuery(regexp.QuoteMeta("FROM album_images WHERE album_id IN (?)")).
+				WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"album_id", "id", "image_url", "position", "width", "height", "size_bytes", "created_at"}))
+
+			var form bytes.Buffer
+			mw := multipart.NewWriter(&form)
+			part, _ := mw.CreateFormFile("image", filename)
+			part.Write(pngData.Bytes())
+			mw.WriteField("artist", "A")
+			mw.WriteField("title", "T")
+			mw.Close()
+			req := httptest.NewRequest(http.MethodPost, "/albums", &form)
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+			w := httptest.NewRecorder()
+			ts.router.ServeHTTP(w, req)
+			if w.Code != http.StatusCreated {
+				t.Fatalf("status = %d, body %s", w.Code, w.Body)
+			}
+
+			// Every file written, image and thumbnail, is under root and named
+			// by a generated UUID with the extension of its stored type
+			var stored []string
+			filepath.WalkDir(filepath.Dir(root), func(path string, d fs.DirEntry, err error) error {
+				if err == nil && !d.IsDir() {
+					stored = append(stored, path)
+				}
+				return err
+			})
+			if len(stored) != 2 {
+				t.Fatalf("stored %v, want the image and its thumbnail", stored)
+			}
+			for _, path := range stored {
+				stem, ext, _ := strings.Cut(filepath.Base(path), ".")
+				_, err := uuid.Parse(strings.TrimSuffix(stem, "_thumb"))
+				if !strings.HasPrefix(path, root+string(filepath.Separator)) || err != nil || (ext != "png" && ext != "jpg") {
+					t.Errorf("stored %q", path)
+				}
+			}
+		})
+	}
+}
+
+// TestLocalStorageSaveIsAtomic checks that a failed write leaves nothing
+// behind and a successful one leaves only the final file
+func TestLocalStorageSaveIsAtomic(t *testing.T) {
+	dir := t.TempDir()
+	s := NewLocalStorage(dir, 0)
+
+	failing := io.MultiReader(strings.NewReader("partial"), errReader{errors.New("connection reset")})
+	if _, err := s.Save(context.Background(), "broken.jpg", "image/jpeg", failing); err == nil {
+		t.Fatal("Save succeeded with a failing reader")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("failed Save left %d entries, want none", len(entries))
+	}
+
+	url, err := s.Save(context.Background(), "cover.jpg", "image/jpeg", strings.NewReader("jpeg bytes"))
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if url != filepath.Join(dir, "cover.jpg") {
+		t.Errorf("url = %q", url)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("%d entries after Save, want just the image", len(entries))
+	}
+}
+
+// errReader fails every read with err
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
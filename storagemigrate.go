@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultMigrateBatch and maxMigrateBatch bound how many albums one call of
+// POST /admin/storage-migration moves, so each call stays well inside
+// REQUEST_TIMEOUT
+const (
+	defaultMigrateBatch = 50
+	maxMigrateBatch     = 500
+)
+
+// StorageMigrationReport is the progress of one storage migration batch
+type StorageMigrationReport struct {
+	Migrated int                `json:"migrated"`
+	Failed   []MigrationFailure `json:"failed"`
+	// Remaining counts albums that still reference local files, failed
+	// ones included
+	Remaining int `json:"remaining"`
+	// NextAfter is the ?after= that continues past this batch; 0 once the
+	// last album has been visited
+	NextAfter int `json:"next_after,omitempty"`
+}
+
+// MigrationFailure is an album whose files could not be moved; the album is
+// left untouched and a later run retries it
+type MigrationFailure struct {
+	AlbumID int    `json:"albumID"`
+	Error   string `json:"error"`
+}
+
+// localPath matches a column holding a local file path rather than a URL,
+// the SQL counterpart of !isRemoteURL
+func localPath(column string) string {
+	return "(" + column + " <> '' AND " + column + " NOT LIKE 'http://%' AND " + column + " NOT LIKE 'https://%')"
+}
+
+// hasLocalFiles selects albums with any file still on local disk
+var hasLocalFiles = "(" + localPath("image_url") + " OR " + localPath("thumbnail_url") + " OR " + localPath("audio_url") +
+	" OR EXISTS (SELECT 1 FROM album_images i WHERE i.album_id = albums.id AND " + localPath("i.image_url") + "))"
+
+// POST /admin/storage-migration -> copies the local files of up to ?limit=
+// albums with IDs above ?after= to the configured storage backend and points
+// their URLs at the copies. Migrated albums no longer match, so calling it
+// again, with next_after or from the start, only picks up what is left; a
+// crashed or failed run is resumed by simply running it again. The local
+// files are kept; remove them once every album has migrated.
+func (s *Server) migrateStorage(c *gin.Context) {
+	if _, ok := s.storage.(*LocalStorage); ok {
+		respondError(c, http.StatusNotImplemented, ErrCodeNotImplemented, "Storage migration needs a non-local STORAGE_BACKEND to migrate to")
+		return
+	}
+
+	limit, after := defaultMigrateBatch, 0
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "limit must be a positive integer")
+			return
+		}
+		limit = min(n, maxMigrateBatch)
+	}
+	if v := c.Query("after"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			respondError(c, http.StatusBadRequest, ErrCodeBadRequest, "after must be a non-negative integer")
+			return
+		}
+		after = n
+	}
+
+	ctx := c.Request.Context()
+	ids, err := s.albumsWithLocalFiles(ctx, after, limit)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	report := StorageMigrationReport{Failed: []MigrationFailure{}}
+	for _, id := range ids {
+		if err := s.migrateAlbumFiles(ctx, id); err != nil {
+			if ctx.Err() != nil {
+				respondInternalError(c, err)
+				return
+			}
+			slog.WarnContext(ctx, "Failed to migrate album files", "album_id", id, "error", err)
+			report.Failed = append(report.Failed, MigrationFailure{AlbumID: id, Error: err.Error()})
+			continue
+		}
+		report.Migrated++
+	}
+	if len(ids) == limit {
+		report.NextAfter = ids[len(ids)-1]
+	}
+
+	queryCtx, cancel := s.queryContext(ctx)
+	defer cancel()
+	if err := s.db.QueryRowContext(queryCtx, "SELECT COUNT(*) FROM albums WHERE "+hasLocalFiles).Scan(&report.Remaining); err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	slog.InfoContext(ctx, "Migrated album files", "migrated", report.Migrated, "failed", len(report.Failed), "remaining", report.Remaining)
+	c.JSON(200, report)
+}
+
+// albumsWithLocalFiles returns up to limit IDs above after of albums,
+// soft-deleted ones included, that still reference local files
+func (s *Server) albumsWithLocalFiles(ctx context.Context, after, limit int) ([]int, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, "SELECT id FROM albums WHERE id > ? AND "+hasLocalFiles+" ORDER BY id LIMIT ?", after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// migrateAlbumFiles uploads every local file of an album to the storage
+// backend, then swaps the URLs in one transaction. Each URL is only replaced
+// if it still holds the old path, so a concurrent image replacement wins;
+// copies that end up unreferenced are deleted again.
+func (s *Server) migrateAlbumFiles(ctx context.Context, albumID int) error {
+	paths, err := s.albumFilePaths(ctx, albumID)
+	if err != nil {
+		return err
+	}
+
+	moved := make(map[string]string, len(paths))
+	var copies []string
+	for _, path := range paths {
+		url, err := s.copyToStorage(ctx, path)
+		if err != nil {
+			s.removeStoredFiles(context.WithoutCancel(ctx), copies...)
+			return err
+		}
+		moved[path] = url
+		copies = append(copies, url)
+	}
+
+	queryCtx, cancel := s.queryContext(ctx)
+	defer cancel()
+	tx, err := s.db.BeginTx(queryCtx, nil)
+	if err != nil {
+		s.removeStoredFiles(context.WithoutCancel(ctx), copies...)
+		return err
+	}
+	defer tx.Rollback()
+
+	var unused []string
+	for path, url := range moved {
+		var n int64
+		for _, query := range []string{
+			"UPDATE albums SET image_url = ? WHERE id = ? AND image_url = ?",
+			"UPDATE albums SET thumbnail_url = ? WHERE id = ? AND thumbnail_url = ?",
+			"UPDATE albums SET audio_url = ? WHERE id = ? AND audio_url = ?",
+			"UPDATE album_images SET image_url = ? WHERE album_id = ? AND image_url = ?",
+		} {
+			res, err := tx.ExecContext(queryCtx, query, url, albumID, path)
+			if err != nil {
+				s.removeStoredFiles(context.WithoutCancel(ctx), copies...)
+				return err
+			}
+			affected, _ := res.RowsAffected()
+			n += affected
+		}
+		if n == 0 {
+			unused = append(unused, url)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.removeStoredFiles(context.WithoutCancel(ctx), copies...)
+		return err
+	}
+	s.albums.invalidate(albumID)
+	s.removeStoredFiles(ctx, unused...)
+	return nil
+}
+
+// albumFilePaths lists the distinct local files an album references
+func (s *Server) albumFilePaths(ctx context.Context, albumID int) ([]string, error) {
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	var imageURL, thumbnailURL, audioURL sql.NullString
+	err := s.db.QueryRowContext(ctx, "SELECT image_url, thumbnail_url, audio_url FROM albums WHERE id = ?", albumID).Scan(&imageURL, &thumbnailURL, &audioURL)
+	if err != nil {
+		return nil, err
+	}
+	urls := []string{imageURL.String, thumbnailURL.String, audioURL.String}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT image_url FROM album_images WHERE album_id = ?", albumID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			return nil, err
+		}
+		urls = append(urls, url)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(urls))
+	var paths []string
+	for _, url := range urls {
+		if url == "" || isRemoteURL(url) || seen[url] {
+			continue
+		}
+		seen[url] = true
+		paths = append(paths, url)
+	}
+	return paths, nil
+}
+
+// copyToStorage uploads a local file under its own name, with the content
+// type sniffed from its first bytes, and returns the new URL
+func (s *Server) copyToStorage(ctx context.Context, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReaderSize(f, 512)
+	head, err := r.Peek(512)
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return s.storage.Save(ctx, filepath.Base(path), http.DetectContentType(head), r)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"log/slog"
+
+	"github.com/google/uuid"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// thumbnailMaxDim is the longest side of a generated thumbnail, in pixels
+const thumbnailMaxDim = 200
+
+var errImageTooLarge = errors.New("image dimensions exceed the pixel limit")
+
+// checkImagePixels reads only the header of image data and rejects images
+// of more than maxPixels, so a small file declaring huge dimensions never
+// reaches a full decode and its allocation. It returns the header read.
+func checkImagePixels(data []byte, maxPixels int64) (image.Config, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return cfg, fmt.Errorf("%w: %v", errMalformedImage, err)
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+		return cfg, fmt.Errorf("%w: %dx%d is more than %d pixels", errImageTooLarge, cfg.Width, cfg.Height, maxPixels)
+	}
+	return cfg, nil
+}
+
+// decodeImage fully decodes image data that passes checkImagePixels. A
+// decode can't be interrupted, so ctx is checked before it starts.
+func decodeImage(ctx context.Context, data []byte, maxPixels int64) (image.Image, error) {
+	if _, err := checkImagePixels(data, maxPixels); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errMalformedImage, err)
+	}
+	return img, nil
+}
+
+// makeThumbnail scales img down so its longest side is at most maxDim,
+// preserving the aspect ratio. Smaller images are returned unchanged.
+func makeThumbnail(img image.Image, maxDim int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxDim && h <= maxDim {
+		return img
+	}
+
+	if w >= h {
+		h = max(1, h*maxDim/w)
+		w = maxDim
+	} else {
+		w = max(1, w*maxDim/h)
+		h = maxDim
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	return dst
+}
+
+// saveThumbnail stores a JPEG thumbnail of the uploaded image and returns its
+// URL. Thumbnails are best effort: images that can't be decoded are logged
+// and skipped with an empty URL so the upload itself still succeeds.
+func (s *Server) saveThumbnail(ctx context.Context, data []byte) string {
+	thumbURL, err := s.createThumbnail(ctx, data)
+	if err != nil {
+		slog.WarnContext(ctx, "Skipping thumbnail", "error", err)
+		return ""
+	}
+	return thumbURL
+}
+
+func (s *Server) createThumbnail(ctx context.Context, data []byte) (string, error) {
+	img, err := decodeImage(ctx, data, s.maxImagePixels)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, makeThumbnail(img, thumbnailMaxDim), &jpeg.Options{Quality: 85}); err != nil {
+		return "", fmt.Errorf("failed to encode thumbnail: %v", err)
+	}
+
+	return s.storage.Save(ctx, uuid.NewString()+"_thumb.jpg", "image/jpeg", &buf)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// bombPNG returns a tiny PNG whose header declares w×h pixels
+func bombPNG(t *testing.T, w, h uint32) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// IHDR is the first chunk: length, type, width, height, ..., CRC
+	ihdr := data[len(pngSignature):]
+	binary.BigEndian.PutUint32(ihdr[8:], w)
+	binary.BigEndian.PutUint32(ihdr[12:], h)
+	binary.BigEndian.PutUint32(ihdr[21:], crc32.ChecksumIEEE(ihdr[4:21]))
+	return data
+}
+
+// bombJPEG returns a tiny JPEG rotated by EXIF whose header declares w×h
+// pixels, so autoOrientJPEG would decode it
+func bombJPEG(t *testing.T, w, h uint16) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1)), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	sof := bytes.Index(data, []byte{0xFF, 0xC0})
+	if sof < 0 {
+		t.Fatal("no SOF0 segment")
+	}
+	binary.BigEndian.PutUint16(data[sof+5:], h)
+	binary.BigEndian.PutUint16(data[sof+7:], w)
+
+	// APP1 Exif with a single IFD0 entry: orientation 6, rotate 90° clockwise
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x06\x00\x00\x00\x00\x00\x00")
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(2+len(segment)))
+	app1 = append(app1, segment...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, app1...)
+	return append(out, data[2:]...)
+}
+
+func TestCheckImagePixels(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		max     int64
+		wantErr error
+	}{
+		{"within limit", bombPNG(t, 100, 100), 10_000, nil},
+		{"over limit", bombPNG(t, 100, 101), 10_000, errImageTooLarge},
+		{"huge", bombPNG(t, 1<<20, 1<<20), 50_000_000, errImageTooLarge},
+		{"malformed", []byte("not an image"), 10_000, errMalformedImage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := checkImagePixels(tt.data, tt.max)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil) != (err == nil) {
+				t.Errorf("checkImagePixels = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestDecodersRejectBombs checks that every full decode stops at the header
+// of an image declaring more than the pixel limit
+func TestDecodersRejectBombs(t *testing.T) {
+	const maxPixels = 1_000_000
+	bomb := bombPNG(t, 50_000, 50_000)
+	s := &Server{storage: newMemStorage(), maxImagePixels: maxPixels}
+
+	t.Run("createThumbnail", func(t *testing.T) {
+		if _, err := s.createThumbnail(context.Background(), bomb); !errors.Is(err, errImageTooLarge) {
+			t.Errorf("err = %v, want errImageTooLarge", err)
+		}
+	})
+	t.Run("reencodeImage", func(t *testing.T) {
+		if _, err := reencodeImage(context.Background(), bomb, "image/jpeg", 85, maxPixels); !errors.Is(err, errImageTooLarge) {
+			t.Errorf("err = %v, want errImageTooLarge", err)
+		}
+	})
+	t.Run("autoOrientJPEG", func(t *testing.T) {
+		if _, err := autoOrientJPEG(context.Background(), bombJPEG(t, 50_000, 50_000), maxPixels); !errors.Is(err, errImageTooLarge) {
+			t.Errorf("err = %v, want errImageTooLarge", err)
+		}
+	})
+	t.Run("webpVariant", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "bomb.png")
+		if err := os.WriteFile(path, bomb, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writeWebPVariant(context.Background(), bomb, path+webpVariantExt, maxPixels); !errors.Is(err, errImageTooLarge) {
+			t.Errorf("err = %v, want errImageTooLarge", err)
+		}
+		if variant := webpVariant(context.Background(), path, maxPixels); variant != "" {
+			t.Errorf("webpVariant = %q, want the original served", variant)
+		}
+	})
+}
+
+func TestUploadRejectsBomb(t *testing.T) {
+	ts := newTestServer(t, func(cfg *Config) { cfg.MaxImagePixels = 1_000_000 })
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	part, _ := mw.CreateFormFile("image", "bomb.png")
+	part.Write(bombPNG(t, 50_000, 50_000))
+	mw.WriteField("artist", "Artist")
+	mw.WriteField("title", "Title")
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/albums", &form)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	ts.router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413, body %s", w.Code, w.Body)
+	}
+	if len(ts.storage.objects) != 0 {
+		t.Errorf("stored %d objects, want none", len(ts.storage.objects))
+	}
+}
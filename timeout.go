@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestTimeout puts a deadline on the request context, so a handler stuck
+// waiting on a deadlocked DB pool or a hung storage call gives up after d
+// instead of hanging forever. Handlers that fail because of it answer 503
+// through respondInternalError; if one returns without responding, the 503
+// is sent here. Every request that runs out of time is counted, by route.
+// Routes in exempt, such as streaming exports, get no deadline.
+//
+// The deadline is cooperative, unlike http.TimeoutHandler: the handler keeps
+// the response writer and nothing is written behind its back, so there are
+// no late writes to discard. It takes effect wherever the context is
+// consulted: DB calls, storage reads and writes, and the image pipeline
+// between its decode and encode steps. A single decode can't be interrupted
+// and may overrun d by its duration, which MAX_IMAGE_PIXELS bounds.
+func requestTimeout(d time.Duration, exempt ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if slices.Contains(exempt, c.FullPath()) {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), d)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return
+		}
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		requestTimeoutsTotal.WithLabelValues(route).Inc()
+		slog.WarnContext(ctx, "Request exceeded REQUEST_TIMEOUT", "method", c.Request.Method, "path", c.Request.URL.Path, "timeout", d)
+		if !c.Writer.Written() {
+			respondRequestTimeout(c)
+		}
+	}
+}
+
+// respondRequestTimeout aborts with the 503 for a request past its deadline
+func respondRequestTimeout(c *gin.Context) {
+	respondError(c, http.StatusServiceUnavailable, ErrCodeTimeout, "Request did not finish in time")
+}
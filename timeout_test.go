@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestTimeoutStopsUpload(t *testing.T) {
+	ts := newTestServer(t, func(cfg *Config) { cfg.RequestTimeout = time.Nanosecond })
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	part, _ := mw.CreateFormFile("image", "cover.png")
+	png.Encode(part, image.NewRGBA(image.Rect(0, 0, 8, 8)))
+	mw.WriteField("artist", "Artist")
+	mw.WriteField("title", "Title")
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/albums", &form)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	ts.router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503, body %s", w.Code, w.Body)
+	}
+	var resp ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Code != ErrCodeTimeout {
+		t.Errorf("body = %s, want code %q", w.Body, ErrCodeTimeout)
+	}
+	if len(ts.storage.objects) != 0 {
+		t.Errorf("stored %d objects after the deadline, want none", len(ts.storage.objects))
+	}
+}
+
+func TestImagePipelineHonoursContext(t *testing.T) {
+	var data bytes.Buffer
+	png.Encode(&data, image.NewRGBA(image.Rect(0, 0, 8, 8)))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := reencodeImage(ctx, data.Bytes(), "image/jpeg", 85, 1_000_000); !errors.Is(err, context.Canceled) {
+		t.Errorf("reencodeImage err = %v, want context.Canceled", err)
+	}
+	s := &Server{storage: newMemStorage(), maxImagePixels: 1_000_000}
+	if _, err := s.createThumbnail(ctx, data.Bytes()); !errors.Is(err, context.Canceled) {
+		t.Errorf("createThumbnail err = %v, want context.Canceled", err)
+	}
+	if _, err := s.prepareUpload(ctx, data.Bytes()); !errors.Is(err, context.Canceled) {
+		t.Errorf("prepareUpload err = %v, want context.Canceled", err)
+	}
+}
+
+func TestLocalSaveHonoursContext(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := NewLocalStorage(dir, 0).Save(ctx, "a.png", "image/png", strings.NewReader("data"))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Save err = %v, want context.Canceled", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("left %d files behind", len(entries))
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"strings"
+
+	"github.com/XSAM/otelsql"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// setupTracing installs an OTLP/HTTP trace exporter for cfg.OTLPEndpoint and
+// returns a function that flushes pending spans on shutdown. With no endpoint
+// OpenTelemetry's default no-op provider stays in place, so spans cost nothing.
+func setupTracing(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	// Accept trace context from callers even when this service doesn't export
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if cfg.OTLPEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// The exporter reads OTEL_EXPORTER_OTLP_ENDPOINT and the other standard
+	// OTEL_EXPORTER_OTLP_* variables itself
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(cfg.ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// tracingMiddleware starts a server span per request, continuing any trace
+// context the caller sent. Probes and metrics scrapes are not traced.
+func tracingMiddleware(serviceName string) gin.HandlerFunc {
+	return otelgin.Middleware(serviceName, otelgin.WithFilter(func(r *http.Request) bool {
+		return r.URL.Path != "/metrics" && !strings.HasPrefix(r.URL.Path, "/health")
+	}))
+}
+
+type spanAttrsKey struct{}
+
+// spanAttributes tags the request span with the operation and album ID and
+// keeps them in the context so DB spans started by the handler carry them too
+func spanAttributes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		attrs := []attribute.KeyValue{attribute.String("album.operation", operationName(c.HandlerName()))}
+		if id := c.Param("albumID"); id != "" {
+			attrs = append(attrs, attribute.String("album.id", id))
+		}
+
+		ctx := c.Request.Context()
+		trace.SpanFromContext(ctx).SetAttributes(attrs...)
+		c.Request = c.Request.WithContext(context.WithValue(ctx, spanAttrsKey{}, attrs))
+		c.Next()
+	}
+}
+
+// operationName turns a handler name such as "main.(*Server).getAlbum-fm"
+// into "getAlbum"
+func operationName(handler string) string {
+	handler = strings.TrimSuffix(handler, "-fm")
+	handler = strings.TrimPrefix(handler, "main.")
+	return strings.TrimPrefix(handler, "(*Server).")
+}
+
+// otelSQLOptions configures the DB spans: one per statement, tagged as MySQL
+// and with the request's span attributes
+var otelSQLOptions = []otelsql.Option{
+	otelsql.WithAttributes(semconv.DBSystemMySQL),
+	otelsql.WithSpanOptions(otelsql.SpanOptions{OmitConnResetSession: true, OmitRows: true}),
+	otelsql.WithAttributesGetter(func(ctx context.Context, _ otelsql.Method, _ string, _ []driver.NamedValue) []attribute.KeyValue {
+		attrs, _ := ctx.Value(spanAttrsKey{}).([]attribute.KeyValue)
+		return attrs
+	}),
+}
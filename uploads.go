@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Direct uploads let clients send large images straight to storage:
+//
+//  1. POST /albums/upload-url with {"content_type": "image/jpeg"} returns an
+//     upload_id and a presigned upload_url.
+//  2. The client PUTs the image bytes to upload_url with the same
+//     Content-Type header before expires_at.
+//  3. POST /albums/uploads/{upload_id}/confirm with the album metadata checks
+//     the object and creates the album.
+//
+// Uploads that are never confirmed are swept once they expire, together
+// with any object the client did upload.
+
+// pendingSweepInterval is how often expired direct uploads are cleaned up
+const pendingSweepInterval = time.Minute
+
+// uploadHeadBytes is how much of a direct upload confirm reads to check its
+// type and dimensions, enough for the header behind large EXIF or ICC
+// segments
+const uploadHeadBytes = 1 << 20
+
+// imageExtensions maps accepted content types to the extension used for keys
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// UploadURLRequest is the body of POST /albums/upload-url
+type UploadURLRequest struct {
+	ContentType string `json:"content_type"`
+}
+
+// UploadURL tells the client where to upload the image and until when
+type UploadURL struct {
+	UploadID  string    `json:"upload_id"`
+	UploadURL string    `json:"upload_url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// directUploader returns the storage backend as a DirectUploader, responding
+// with 501 when the configured backend can't presign uploads
+func (s *Server) directUploader(c *gin.Context) (DirectUploader, bool) {
+	uploader, ok := s.storage.(DirectUploader)
+	if !ok {
+		respondError(c, http.StatusNotImplemented, ErrCodeNotImplemented, "Direct uploads require STORAGE_BACKEND=s3")
+		return nil, false
+	}
+	return uploader, true
+}
+
+// POST /albums/upload-url -> reserves a direct upload and returns a presigned URL
+func (s *Server) createUploadURL(c *gin.Context) {
+	uploader, ok := s.directUploader(c)
+	if !ok {
+		return
+	}
+
+	var req UploadURLRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondJSONBodyError(c, err, "Invalid upload request")
+		return
+	}
+
+	ext, ok := imageExtensions[req.ContentType]
+	if !ok {
+		respondError(c, http.StatusUnsupportedMediaType, ErrCodeUnsupportedMedia, errUnsupportedImageType.Error())
+		return
+	}
+
+	uploadID := uuid.NewString()
+	key := uuid.NewString() + ext
+	uploadURL, err := uploader.PresignUpload(key, req.ContentType, s.directUploadTTL)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	// The expiry is computed by MySQL so the sweeper compares like with like
+	ctx, cancel := s.queryContext(c.Request.Context())
+	defer cancel()
+	_, err = s.db.ExecContext(ctx, "INSERT INTO pending_uploads (id, object_key, content_type, expires_at) VALUES (?, ?, ?, TIMESTAMPADD(SECOND, ?, CURRENT_TIMESTAMP))",
+		uploadID, key, req.ContentType, int(s.directUploadTTL.Seconds()))
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, UploadURL{
+		UploadID:  uploadID,
+		UploadURL: uploadURL,
+		ExpiresAt: time.Now().Add(s.directUploadTTL).UTC(),
+	})
+}
+
+// POST /albums/uploads/{uploadID}/confirm -> creates the album for a finished
+// direct upload
+func (s *Server) confirmUpload(c *gin.Context) {
+	uploader, ok := s.directUploader(c)
+	if !ok {
+		return
+	}
+	uploadID := c.Param("uploadID")
+
+	var metadata AlbumMetadata
+	if !bindMetadataJSON(c, &metadata, nil, "Invalid metadata") {
+		return
+	}
+
+	if errs := validateMetadata(&metadata, true); len(errs) > 0 {
+		respondValidationErrors(c, errs)
+		return
+	}
+
+	lookupCtx, cancelLookup := s.queryContext(c.Request.Context())
+	defer cancelLookup()
+
+	var key, contentType string
+	err := s.db.QueryRowContext(lookupCtx, "SELECT object_key, content_type FROM pending_uploads WHERE id = ? AND expires_at > CURRENT_TIMESTAMP", uploadID).Scan(&key, &contentType)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Upload not found or expired")
+		return
+	}
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	imageURL, size, err := uploader.StatUpload(c.Request.Context(), key)
+	if err != nil {
+		if errors.Is(err, errUploadNotFound) {
+			respondError(c, http.StatusConflict, ErrCodeConflict, "Image has not been uploaded yet")
+			return
+		}
+		respondInternalError(c, err)
+		return
+	}
+
+	// A presigned PUT can't cap the body, so enforce the limit now and void the upload
+	if size > s.maxUploadBytes {
+		s.discardUpload(c.Request.Context(), uploader, uploadID, key)
+		respondError(c, http.StatusRequestEntityTooLarge, ErrCodePayloadTooLarge, "Image file too large")
+		return
+	}
+
+	// Nor can it check the content, so the object gets the checks an upload
+	// through the server does before anything refers to it
+	info, err := s.checkDirectUpload(c.Request.Context(), uploader, key, contentType)
+	if err != nil {
+		if errors.Is(err, errUploadNotFound) {
+			respondError(c, http.StatusConflict, ErrCodeConflict, "Image has not been uploaded yet")
+			return
+		}
+		if errors.Is(err, errUnsupportedImageType) || errors.Is(err, errMalformedImage) || errors.Is(err, errImageTooLarge) {
+			s.discardUpload(c.Request.Context(), uploader, uploadID, key)
+		}
+		respondUploadError(c, err)
+		return
+	}
+	info.sizeBytes = sql.NullInt64{Int64: size, Valid: true}
+
+	stored, err := s.storeMetadata(metadata)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	ctx, cancel := s.queryContext(c.Request.Context())
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Claiming the pending row makes a second confirm of the same upload a 404
+	res, err := tx.ExecContext(ctx, "DELETE FROM pending_uploads WHERE id = ? AND expires_at > CURRENT_TIMESTAMP", uploadID)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Upload not found or expired")
+		return
+	}
+
+	res, err = tx.ExecContext(ctx, "INSERT INTO albums (image_url, width, height, size_bytes, "+metadataColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		append([]any{imageURL, info.width, info.height, info.sizeBytes}, stored.args()...)...)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	id, _ := res.LastInsertId()
+	// The bytes never pass through here whole, so there is no checksum
+	if err := insertPrimaryImage(ctx, tx, id, imageURL, info); err != nil {
+		respondInternalError(c, err)
+		return
+	}
+	if err := s.chargeUpload(ctx, tx, size); err != nil {
+		if errors.Is(err, errQuotaExceeded) {
+			// Release the pending row before voiding the upload
+			tx.Rollback()
+			s.discardUpload(c.Request.Context(), uploader, uploadID, key)
+			respondQuotaExceeded(c)
+			return
+		}
+		respondInternalError(c, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	uploadedBytesTotal.Add(float64(size))
+
+	s.notifyAlbumCreated(c.Request.Context(), id)
+
+	album, err := s.fetchAlbum(c.Request.Context(), int(id), false)
+	if err != nil {
+		respondInternalError(c, err)
+		return
+	}
+
+	s.presentAlbum(&album)
+	c.Header("Location", "/albums/"+strconv.FormatInt(id, 10))
+	c.JSON(http.StatusCreated, album)
+}
+
+// checkDirectUpload reads the head of an uploaded object and checks that it
+// is the image type the upload was presigned for, within MAX_IMAGE_PIXELS,
+// returning its dimensions
+func (s *Server) checkDirectUpload(ctx context.Context, uploader DirectUploader, key, contentType string) (storedImage, error) {
+	head, err := uploader.ReadUpload(ctx, key, uploadHeadBytes)
+	if err != nil {
+		return storedImage{}, err
+	}
+	if sniffed := http.DetectContentType(head); !allowedImageTypes[sniffed] || sniffed != contentType {
+		return storedImage{}, errUnsupportedImageType
+	}
+	cfg, err := checkImagePixels(head, s.maxImagePixels)
+	if err != nil {
+		return storedImage{}, err
+	}
+	return storedImage{
+		width:  sql.NullInt64{Int64: int64(cfg.Width), Valid: true},
+		height: sql.NullInt64{Int64: int64(cfg.Height), Valid: true},
+	}, nil
+}
+
+// discardUpload deletes a pending upload's object and row, logging failures
+func (s *Server) discardUpload(ctx context.Context, uploader DirectUploader, uploadID, key string) {
+	if err := uploader.DeleteUpload(ctx, key); err != nil {
+		slog.WarnContext(ctx, "Failed to delete upload", "upload_id", uploadID, "error", err)
+		return
+	}
+
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM pending_uploads WHERE id = ?", uploadID); err != nil {
+		slog.WarnContext(ctx, "Failed to delete pending upload", "upload_id", uploadID, "error", err)
+	}
+}
+
+// sweepPendingUploads periodically discards direct uploads that expired
+// without being confirmed, until ctx is cancelled
+func (s *Server) sweepPendingUploads(ctx context.Context) {
+	uploader, ok := s.storage.(DirectUploader)
+	if !ok {
+		return
+	}
+
+	ticker := time.NewTicker(pendingSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.sweepExpiredUploads(ctx, uploader); err != nil {
+				slog.Warn("Failed to sweep expired uploads", "error", err)
+			}
+		}
+	}
+}
+
+// sweepExpiredUploads discards one batch of expired pending uploads
+func (s *Server) sweepExpiredUploads(ctx context.Context, uploader DirectUploader) error {
+	queryCtx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(queryCtx, "SELECT id, object_key FROM pending_uploads WHERE expires_at <= CURRENT_TIMESTAMP LIMIT 100")
+	if err != nil {
+		return err
+	}
+
+	type expired struct{ id, key string }
+	var batch []expired
+	for rows.Next() {
+		var e expired
+		if err := rows.Scan(&e.id, &e.key); err != nil {
+			rows.Close()
+			return err
+		}
+		batch = append(batch, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, e := range batch {
+		s.discardUpload(ctx, uploader, e.id, e.key)
+	}
+	if len(batch) > 0 {
+		slog.Info("Swept expired uploads", "count", len(batch))
+	}
+	return nil
+}
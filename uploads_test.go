@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// directStorage adds presigned uploads to memStorage, with the objects
+// clients PUT kept in uploads by key
+type directStorage struct {
+	*memStorage
+	uploads        map[string][]byte
+	deletedUploads []string
+}
+
+func (d *directStorage) PresignUpload(key, contentType string, ttl time.Duration) (string, error) {
+	return "https://bucket.s3.amazonaws.com/" + key + "?signed", nil
+}
+
+func (d *directStorage) StatUpload(ctx context.Context, key string) (string, int64, error) {
+	data, ok := d.uploads[key]
+	if !ok {
+		return "", 0, errUploadNotFound
+	}
+	return "https://bucket.s3.amazonaws.com/" + key, int64(len(data)), nil
+}
+
+func (d *directStorage) DeleteUpload(ctx context.Context, key string) error {
+	delete(d.uploads, key)
+	d.deletedUploads = append(d.deletedUploads, key)
+	return nil
+}
+
+func (d *directStorage) ReadUpload(ctx context.Context, key string, n int64) ([]byte, error) {
+	data, ok := d.uploads[key]
+	if !ok {
+		return nil, errUploadNotFound
+	}
+	return data[:min(int64(len(data)), n)], nil
+}
+
+func TestConfirmUploadChecksObject(t *testing.T) {
+	var pngData bytes.Buffer
+	png.Encode(&pngData, image.NewRGBA(image.Rect(0, 0, 8, 6)))
+
+	tests := []struct {
+		name       string
+		object     []byte
+		wantStatus int
+	}{
+		{"valid image", pngData.Bytes(), http.StatusCreated},
+		{"not an image", []byte("<html><script>alert(1)</script></html>"), http.StatusUnsupportedMediaType},
+		{"other image type", []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"), http.StatusUnsupportedMediaType},
+		{"truncated header", pngData.Bytes()[:20], http.StatusBadRequest},
+		{"too many pixels", bombPNG(t, 50_000, 50_000), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, nil)
+			storage := &directStorage{memStorage: ts.storage, uploads: map[string][]byte{"k.png": tt.object}}
+			ts.Server.storage = storage
+			m := ts.mock
+			objectURL := "https://bucket.s3.amazonaws.com/k.png"
+
+			m.ExpectQuery(regexp.QuoteMeta("SELECT object_key, content_type FROM pending_uploads WHERE id = ?")).
+				WithArgs("u1").WillReturnRows(sqlmock.NewRows([]string{"object_key", "content_type"}).AddRow("k.png", "image/png"))
+			if tt.wantStatus == http.StatusCreated {
+				size := len(tt.object)
+				m.ExpectBegin()
+				m.ExpectExec(regexp.QuoteMeta("DELETE FROM pending_uploads WHERE id = ? AND expires_at > CURRENT_TIMESTAMP")).
+					WithArgs("u1").WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectExec(regexp.QuoteMeta("INSERT INTO albums (image_url, width, height, size_bytes, ")).
+					WithArgs(objectURL, 8, 6, size, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				m.ExpectExec(regexp.QuoteMeta("INSERT INTO album_images")).
+					WithArgs(1, objectURL, nil, 8, 6, size).WillReturnResult(sqlmock.NewResult(1, 1))
+				m.ExpectCommit()
+				now := time.Now()
+				m.ExpectQuery(regexp.QuoteMeta("SELECT " + albumColumns + " FROM albums WHERE id = ?")).
+					WithArgs(1).
+					WillReturnRows(albumRows().AddRow(1, nil, objectURL, nil, nil, nil, 8, 6, size,
+						`{"artist":"A","title":"T","year":"2001"}`, nil, nil, nil, 1, now, now, nil))
+				m.ExpectQuery(regexp.QuoteMeta("FROM album_images WHERE album_id IN (?)")).
+					WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"album_id", "id", "image_url", "position", "width", "height", "size_bytes", "created_at"}))
+			} else {
+				// A rejected object is voided with its upload
+				m.ExpectExec(regexp.QuoteMeta("DELETE FROM pending_uploads WHERE id = ?")).
+					WithArgs("u1").WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			w := ts.do(http.MethodPost, "/albums/uploads/u1/confirm", `{"artist":"A","title":"T","year":"2001"}`, nil)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if deleted := slices.Contains(storage.deletedUploads, "k.png"); deleted != (tt.wantStatus != http.StatusCreated) {
+				t.Errorf("object deleted = %v for status %d", deleted, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// minAlbumYear is the year of the first recorded sound (the phonograph)
+const minAlbumYear = 1877
+
+// maxFieldLength caps free-text metadata fields, matching VARCHAR(255)
+const maxFieldLength = 255
+
+// Tag limits: tags are short labels and an album only needs a handful
+const (
+	maxTags      = 20
+	maxTagLength = 50
+)
+
+// FieldError describes a problem with a single request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validateArtistTitle checks artist and title lengths and, when required is
+// set, that both are non-blank. Values are expected to be trimmed already.
+func validateArtistTitle(artist, title string, required bool) []FieldError {
+	var errs []FieldError
+	for _, f := range []struct{ name, value string }{{"artist", artist}, {"title", title}} {
+		switch {
+		case required && f.value == "":
+			errs = append(errs, FieldError{Field: f.name, Message: f.name + " is required"})
+		case utf8.RuneCountInString(f.value) > maxFieldLength:
+			errs = append(errs, FieldError{Field: f.name, Message: fmt.Sprintf("%s must be at most %d characters", f.name, maxFieldLength)})
+		}
+	}
+	return errs
+}
+
+// fieldNames lists the fields named in errs, for a summary message
+func fieldNames(errs []FieldError) string {
+	names := make([]string, len(errs))
+	for i, e := range errs {
+		names[i] = e.Field
+	}
+	return strings.Join(names, ", ")
+}
+
+// validateMetadata trims m in place, normalizes its tags and returns every
+// field problem at once rather than stopping at the first. required demands
+// artist and title, as for a new album; updates leave it unset.
+func validateMetadata(m *AlbumMetadata, required bool) []FieldError {
+	m.Artist = strings.TrimSpace(m.Artist)
+	m.Title = strings.TrimSpace(m.Title)
+	m.Year = strings.TrimSpace(m.Year)
+
+	errs := validateArtistTitle(m.Artist, m.Title, required)
+	if err := validateYear(m.Year); err != nil {
+		errs = append(errs, FieldError{Field: "year", Message: err.Error()})
+	}
+	tags, err := normalizeTags(m.Tags)
+	if err != nil {
+		errs = append(errs, FieldError{Field: "tags", Message: err.Error()})
+	}
+	m.Tags = tags
+	titles, titleErrs := normalizeTitles(m.Titles)
+	m.Titles = titles
+	return append(errs, titleErrs...)
+}
+
+// respondValidationErrors aborts with a 400 whose details list every field in errs
+func respondValidationErrors(c *gin.Context, errs []FieldError) {
+	respondErrorDetails(c, http.StatusBadRequest, ErrCodeValidation, "Invalid fields: "+fieldNames(errs), errs)
+}
+
+// POST /albums/validate -> checks album metadata as POST /albums would,
+// without storing anything, so forms can report problems before uploading
+func validateAlbum(c *gin.Context) {
+	var metadata AlbumMetadata
+	if !bindMetadataJSON(c, &metadata, nil, "Invalid metadata") {
+		return
+	}
+
+	if errs := validateMetadata(&metadata, true); len(errs) > 0 {
+		respondValidationErrors(c, errs)
+		return
+	}
+	c.JSON(200, gin.H{"valid": true})
+}
+
+// validateYear checks that a non-empty year is a 4-digit year between
+// minAlbumYear and next year. Years are still stored as strings.
+func validateYear(year string) error {
+	if year == "" {
+		return nil
+	}
+
+	maxYear := time.Now().Year() + 1
+	n, err := strconv.Atoi(year)
+	if err != nil || len(year) != 4 || n < minAlbumYear || n > maxYear {
+		return fmt.Errorf("year must be a 4-digit year between %d and %d", minAlbumYear, maxYear)
+	}
+
+	return nil
+}
+
+// normalizeTags trims and lowercases tags, dropping blanks and duplicates so
+// "Jazz" and " jazz" are the same tag, then checks the count and length limits
+func normalizeTags(tags []string) ([]string, error) {
+	var out []string
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, fmt.Errorf("tags must be at most %d characters", maxTagLength)
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+
+	if len(out) > maxTags {
+		return nil, fmt.Errorf("at most %d tags are allowed", maxTags)
+	}
+	return out, nil
+}
+
+// validateImageURL checks that a client-supplied image URL, named field in
+// messages, is a non-empty absolute http or https URL that fits the
+// image_url column
+func validateImageURL(field, raw string) error {
+	if raw == "" {
+		return fmt.Errorf("%s is required", field)
+	}
+	if utf8.RuneCountInString(raw) > maxFieldLength {
+		return fmt.Errorf("%s must be at most %d characters", field, maxFieldLength)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s must be an absolute http or https URL", field)
+	}
+	return nil
+}
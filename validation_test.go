@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateYear(t *testing.T) {
+	next := strconv.Itoa(time.Now().Year() + 1)
+	tests := []struct {
+		year  string
+		valid bool
+	}{
+		{"", true},
+		{"1877", true},
+		{"1999", true},
+		{next, true},
+		{"1876", false},
+		{strconv.Itoa(time.Now().Year() + 2), false},
+		{"99", false},
+		{"01999", false},
+		{"+999", false},
+		{"19a9", false},
+		{"-1999", false},
+		{"twenty twenty", false},
+	}
+	for _, tt := range tests {
+		if err := validateYear(tt.year); (err == nil) != tt.valid {
+			t.Errorf("validateYear(%q) = %v, want valid %v", tt.year, err, tt.valid)
+		}
+	}
+}
+
+func TestValidateArtistTitle(t *testing.T) {
+	long := strings.Repeat("é", maxFieldLength+1)
+	tests := []struct {
+		name          string
+		artist, title string
+		required      bool
+		wantFields    []string
+	}{
+		{"complete", "Air", "Moon Safari", true, nil},
+		{"missing artist", "", "Moon Safari", true, []string{"artist"}},
+		{"missing title", "Air", "", true, []string{"title"}},
+		{"both missing", "", "", true, []string{"artist", "title"}},
+		{"blank allowed when optional", "", "", false, nil},
+		{"at the length limit", strings.Repeat("é", maxFieldLength), "T", true, nil},
+		{"artist too long", long, "T", true, []string{"artist"}},
+		{"too long even when optional", "A", long, false, []string{"title"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fields []string
+			for _, e := range validateArtistTitle(tt.artist, tt.title, tt.required) {
+				fields = append(fields, e.Field)
+			}
+			if !slices.Equal(fields, tt.wantFields) {
+				t.Errorf("fields = %v, want %v", fields, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestValidateAlbumRequiresArtistAndTitle(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantFields []string
+	}{
+		{"complete", `{"artist":"Air","title":"Moon Safari","year":"1998"}`, nil},
+		{"no year", `{"artist":"Air","title":"Moon Safari"}`, nil},
+		{"missing artist", `{"title":"Moon Safari"}`, []string{"artist"}},
+		{"blank title", `{"artist":"Air","title":"   "}`, []string{"title"}},
+		{"both blank", `{"artist":"","title":"\t"}`, []string{"artist", "title"}},
+		{"artist too long", `{"artist":"` + strings.Repeat("a", maxFieldLength+1) + `","title":"T"}`, []string{"artist"}},
+		{"every problem at once", `{"title":"","year":"98"}`, []string{"artist", "title", "year"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, nil)
+			w := ts.do(http.MethodPost, "/albums/validate", tt.body, nil)
+			if tt.wantFields == nil {
+				if w.Code != http.StatusOK {
+					t.Errorf("status = %d, body %s", w.Code, w.Body)
+				}
+				return
+			}
+
+			var resp struct {
+				Code    string       `json:"code"`
+				Details []FieldError `json:"details"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			var fields []string
+			for _, d := range resp.Details {
+				fields = append(fields, d.Field)
+			}
+			if w.Code != http.StatusBadRequest || resp.Code != ErrCodeValidation || !slices.Equal(fields, tt.wantFields) {
+				t.Errorf("status = %d, code %q, fields %v; want 400 %s %v", w.Code, resp.Code, fields, ErrCodeValidation, tt.wantFields)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Webhook delivery is retried this many times in total, waiting
+// webhookRetryDelay, then twice that, between attempts
+const (
+	webhookMaxAttempts = 3
+	webhookRetryDelay  = time.Second
+)
+
+// WebhookEvent is the JSON body POSTed to WEBHOOK_URL. Receivers verify it
+// by comparing X-AlbumStore-Signature with "sha256=" and the hex HMAC-SHA256
+// of the raw body keyed with WEBHOOK_SECRET.
+type WebhookEvent struct {
+	Event  string    `json:"event"`
+	SentAt time.Time `json:"sent_at"`
+	Album  AlbumInfo `json:"album"`
+}
+
+// webhookNotifier delivers events in the background; a nil notifier sends nothing
+type webhookNotifier struct {
+	url    string
+	secret []byte
+	client *http.Client
+	wg     sync.WaitGroup
+}
+
+// newWebhookNotifier returns nil when no webhook URL is configured
+func newWebhookNotifier(url, secret string, timeout time.Duration) *webhookNotifier {
+	if url == "" {
+		return nil
+	}
+	return &webhookNotifier{url: url, secret: []byte(secret), client: &http.Client{Timeout: timeout}}
+}
+
+// notifyAlbumCreated sends an album.created event for albumID without
+// blocking the response. The album is loaded in the background so the event
+// carries the stored record.
+func (s *Server) notifyAlbumCreated(ctx context.Context, albumID int64) {
+	n := s.webhooks
+	if n == nil {
+		return
+	}
+
+	// Keep the request ID for logging but outlive the request
+	ctx = context.WithoutCancel(ctx)
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+
+		album, err := s.fetchAlbum(ctx, int(albumID), true)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load album for webhook", "album_id", albumID, "error", err)
+			return
+		}
+		s.presentAlbum(&album)
+		n.deliver(ctx, WebhookEvent{Event: "album.created", SentAt: time.Now().UTC(), Album: album})
+	}()
+}
+
+// deliver POSTs event, retrying failed attempts with backoff
+func (n *webhookNotifier) deliver(ctx context.Context, event WebhookEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode webhook", "event", event.Event, "error", err)
+		return
+	}
+
+	mac := hmac.New(sha256.New, n.secret)
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	// The same delivery ID on every attempt lets receivers drop duplicates
+	deliveryID := uuid.NewString()
+
+	delay := webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := n.post(ctx, body, signature, deliveryID, event.Event)
+		if err == nil {
+			return
+		}
+		if attempt >= webhookMaxAttempts {
+			slog.ErrorContext(ctx, "Webhook delivery failed", "event", event.Event, "album_id", event.Album.AlbumID, "delivery_id", deliveryID, "attempts", attempt, "error", err)
+			return
+		}
+		slog.WarnContext(ctx, "Retrying webhook delivery", "event", event.Event, "delivery_id", deliveryID, "attempt", attempt, "error", err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// post makes one delivery attempt; any non-2xx response is a failure
+func (n *webhookNotifier) post(ctx context.Context, body []byte, signature, deliveryID, event string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-AlbumStore-Event", event)
+	req.Header.Set("X-AlbumStore-Delivery", deliveryID)
+	req.Header.Set("X-AlbumStore-Signature", signature)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Drain so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("receiver responded %s", resp.Status)
+	}
+	return nil
+}
+
+// waitForWebhooks blocks until queued deliveries finish or ctx is done
+func (s *Server) waitForWebhooks(ctx context.Context) {
+	if s.webhooks == nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.webhooks.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Warn("Shutting down with webhook deliveries still pending")
+	}
+}